@@ -0,0 +1,163 @@
+package gobreaker
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// ExecuteBatch runs reqs one after another in the calling goroutine as a single admission,
+// so that many quick calls to the same service take the lock of the CircuitBreaker
+// a constant number of times instead of twice per call.
+// The i-th result and error are those of reqs[i].
+//
+// The batch is admitted or rejected as a whole, as one request of Execute:
+// if the CircuitBreaker rejects it, no request runs and every error is the rejection error,
+// or the result of Settings.Fallback if it is set. An admitted batch takes a single half-open slot,
+// and all its requests run, even if the state of the CircuitBreaker changes while they run.
+//
+// The outcomes are classified as in Execute and recorded once all requests have returned,
+// in the order of reqs and as if they had completed at that moment.
+// If an outcome changes the state or the generation, for example a failure that trips the CircuitBreaker,
+// the outcomes after it are dropped, as the outcomes of requests that started in an older generation are.
+// For the same reason, if the generation changes while the batch runs, the whole batch is dropped,
+// unless Settings.AttributeStaleToCurrentGeneration allows it to be counted.
+//
+// A panic in a request is handled as in Execute: the outcomes of the requests before it are recorded
+// and the panic is propagated, or, if Settings.RecoverPanic is true, returned as the error of that request
+// while the other requests still run. A nil request gets ErrNilRequest and is not counted.
+// RequestTimeout, HedgeDelay and Retry don't apply to ExecuteBatch.
+func (cb *CircuitBreaker) ExecuteBatch(reqs []func() (interface{}, error)) ([]interface{}, []error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	results := make([]interface{}, len(reqs))
+	errs := make([]error, len(reqs))
+
+	empty := true
+	for i, req := range reqs {
+		if req == nil {
+			errs[i] = ErrNilRequest
+		} else {
+			empty = false
+		}
+	}
+	if empty {
+		return results, errs
+	}
+
+	c := defaultCall(cb, cb.fallback)
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		for i, req := range reqs {
+			if req != nil {
+				results[i], errs[i] = rejectedWithFallback(err, c.fallback)
+			}
+		}
+		return results, errs
+	}
+
+	outcomes := make([]batchOutcome, 0, len(reqs))
+	for i, req := range reqs {
+		if req == nil {
+			continue
+		}
+
+		start := time.Now()
+		result, panicVal, stack, err := callRecovered(req)
+		o := batchOutcome{err: err, elapsed: time.Since(start)}
+		if stack != nil {
+			o.class = ErrorClassFailure
+			if cb.ignorePanics {
+				o.class = ErrorClassIgnore
+			}
+			if !cb.recoverPanic {
+				cb.afterBatch(generation, append(outcomes, o))
+				panic(panicVal)
+			}
+			err = fmt.Errorf("panic: %v\n%s", panicVal, stack)
+			o.err = err
+		} else {
+			o.class = c.classify(result, err)
+		}
+		results[i], errs[i] = result, err
+		outcomes = append(outcomes, o)
+	}
+
+	cb.afterBatch(generation, outcomes)
+	return results, errs
+}
+
+// batchOutcome 是批量请求中一个请求的结果
+type batchOutcome struct {
+	class   ErrorClass
+	err     error
+	elapsed time.Duration
+}
+
+// callRecovered 执行 req，发生 panic 时返回 panic 的值和调用栈，没有 panic 时 stack 为 nil
+func callRecovered(req func() (interface{}, error)) (result, panicVal interface{}, stack []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			panicVal, stack = e, debug.Stack()
+		}
+	}()
+	result, err = req()
+	return result, nil, nil, err
+}
+
+// afterBatch 在一次加锁中按顺序记录批量请求的结果。准入时已经计数了一个请求，
+// 其余的请求在记录结果时计数，没有需要计数的结果时撤销准入时的计数
+func (cb *CircuitBreaker) afterBatch(before uint64, outcomes []batchOutcome) {
+	// 在加锁前更新耗时和共享计数
+	var shared Counts
+	var sharedOK, failed bool
+	for _, o := range outcomes {
+		cb.observeLatency(o.elapsed)
+		if o.class == ErrorClassIgnore {
+			continue
+		}
+		failed = failed || o.class != ErrorClassSuccess
+		if counts, ok := cb.sharedCounts(o.class == ErrorClassSuccess); ok {
+			shared, sharedOK = counts, true
+		}
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.metricsObserver != nil {
+		for _, o := range outcomes {
+			if o.class != ErrorClassIgnore {
+				cb.metricsObserver.ObserveResult(cb.name, o.class == ErrorClassSuccess)
+			}
+		}
+	}
+
+	now := cb.clock.Now()
+	if state, ok := cb.outcomeState(before, now); ok {
+		generation := cb.generation
+		pending := true // 准入时的计数还没有对应的结果
+		for _, o := range outcomes {
+			if o.class == ErrorClassIgnore {
+				continue
+			}
+			if !pending {
+				cb.countRequest(now)
+			}
+			pending = false
+			cb.recordOutcome(state, o.class == ErrorClassSuccess, o.elapsed, now, o.err)
+			if cb.generation != generation {
+				break // 状态或周期已经变化，之后的结果与过期的请求一样丢弃
+			}
+		}
+		if pending {
+			cb.uncountRequest(now)
+		}
+	}
+
+	// 共享计数满足熔断条件时，同样切换为开启状态
+	if sharedOK && failed && cb.state == StateClosed && cb.readyToTrip(shared) {
+		cb.setState(StateOpen, now)
+	}
+}
@@ -0,0 +1,130 @@
+package gobreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func batchOf(errs ...error) []func() (interface{}, error) {
+	reqs := make([]func() (interface{}, error), len(errs))
+	for i, err := range errs {
+		i, err := i, err
+		reqs[i] = func() (interface{}, error) { return i, err }
+	}
+	return reqs
+}
+
+func TestExecuteBatch(t *testing.T) {
+	errFail := errors.New("fail")
+	cb := NewCircuitBreaker(Settings{})
+
+	results, errs := cb.ExecuteBatch(batchOf(nil, errFail, nil))
+	assert.Equal(t, []interface{}{0, 1, 2}, results)
+	assert.Equal(t, []error{nil, errFail, nil}, errs)
+	assert.Equal(t, newCounts(3, 2, 1, 1, 0), cb.Counts())
+
+	results, errs = cb.ExecuteBatch(nil)
+	assert.Nil(t, results)
+	assert.Nil(t, errs)
+
+	// nil requests are not counted
+	reqs := batchOf(nil, nil)
+	reqs[0] = nil
+	_, errs = cb.ExecuteBatch(reqs)
+	assert.Equal(t, []error{ErrNilRequest, nil}, errs)
+	assert.Equal(t, newCounts(4, 3, 1, 2, 0), cb.Counts())
+
+	// the failure that trips the breaker drops the outcomes after it
+	_, errs = cb.ExecuteBatch(batchOf(errFail, errFail, errFail, errFail, errFail, errFail, nil))
+	assert.Equal(t, errFail, errs[5])
+	assert.Nil(t, errs[6])
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	// rejected as a whole
+	calls := 0
+	reqs = []func() (interface{}, error){
+		func() (interface{}, error) { calls++; return nil, nil },
+		func() (interface{}, error) { calls++; return nil, nil },
+	}
+	_, errs = cb.ExecuteBatch(reqs)
+	assert.Equal(t, 0, calls)
+	assert.True(t, errors.Is(errs[0], ErrOpenState))
+	assert.True(t, errors.Is(errs[1], ErrOpenState))
+	assert.Equal(t, uint32(1), cb.Counts().Rejections)
+
+	// a batch takes a single half-open slot and its successes close the breaker
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	_, errs = cb.ExecuteBatch(reqs)
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestExecuteBatchIgnored(t *testing.T) {
+	errIgnored := errors.New("ignored")
+	cb := NewCircuitBreaker(Settings{
+		InitialState: StateHalfOpen,
+		ClassifyError: func(err error) ErrorClass {
+			if err == errIgnored {
+				return ErrorClassIgnore
+			} else if err != nil {
+				return ErrorClassFailure
+			}
+			return ErrorClassSuccess
+		},
+	})
+
+	// a batch with nothing to count releases its slot and its count
+	_, errs := cb.ExecuteBatch(batchOf(errIgnored, errIgnored))
+	assert.Equal(t, []error{errIgnored, errIgnored}, errs)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestExecuteBatchPanic(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	reqs := batchOf(nil, nil, nil)
+	reqs[1] = func() (interface{}, error) { panic("oops") }
+
+	assert.Panics(t, func() { cb.ExecuteBatch(reqs) })
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	cb = NewCircuitBreaker(Settings{RecoverPanic: true})
+	results, errs := cb.ExecuteBatch(reqs)
+	assert.Equal(t, []interface{}{0, nil, 2}, results)
+	assert.Nil(t, errs[0])
+	assert.Contains(t, errs[1].Error(), "panic: oops")
+	assert.Nil(t, errs[2])
+	assert.Equal(t, newCounts(3, 2, 1, 1, 0), cb.Counts())
+}
+
+func BenchmarkExecuteBatch(b *testing.B) {
+	const size = 100
+	req := func() (interface{}, error) { return nil, nil }
+	reqs := make([]func() (interface{}, error), size)
+	for i := range reqs {
+		reqs[i] = req
+	}
+
+	b.Run("Execute", func(b *testing.B) {
+		cb := NewCircuitBreaker(Settings{})
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < size; j++ {
+				_, _ = cb.Execute(req)
+			}
+		}
+	})
+	b.Run("ExecuteBatch", func(b *testing.B) {
+		cb := NewCircuitBreaker(Settings{})
+		for i := 0; i < b.N; i++ {
+			cb.ExecuteBatch(reqs)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package gobreaker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Supported values of SettingsConfig.ReadyToTripPolicy.
+const (
+	// ReadyToTripDefault uses the default ReadyToTrip.
+	ReadyToTripDefault = ""
+	// ReadyToTripConsecutive trips when the number of consecutive failures reaches
+	// ReadyToTripParams.ConsecutiveFailures.
+	ReadyToTripConsecutive = "consecutive"
+	// ReadyToTripRatio trips when the number of requests is at least ReadyToTripParams.MinRequests
+	// and the failure ratio is at least ReadyToTripParams.FailureRatio.
+	ReadyToTripRatio = "ratio"
+)
+
+// Duration is a time.Duration that is encoded as text such as "30s",
+// so that it can be read from JSON and YAML config files.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ReadyToTripParams holds the parameters of the named ReadyToTrip policies.
+type ReadyToTripParams struct {
+	ConsecutiveFailures uint32  `json:"consecutiveFailures,omitempty" yaml:"consecutiveFailures,omitempty"`
+	MinRequests         uint32  `json:"minRequests,omitempty" yaml:"minRequests,omitempty"`
+	FailureRatio        float64 `json:"failureRatio,omitempty" yaml:"failureRatio,omitempty"`
+}
+
+// SettingsConfig is the serializable subset of Settings.
+// It has no function fields, so it can be loaded from config files.
+// Function fields are selected by name and resolved by ToSettings.
+// InitialState is written as the String of the State, e.g. "open".
+//
+// ReadyToTripPolicy is one of:
+//
+//	""            the default ReadyToTrip
+//	"consecutive" trips when ConsecutiveFailures consecutive failures occur
+//	"ratio"       trips when at least MinRequests requests were made
+//	              and the failure ratio is at least FailureRatio
+type SettingsConfig struct {
+	Name              string            `json:"name" yaml:"name"`
+	MaxRequests       uint32            `json:"maxRequests,omitempty" yaml:"maxRequests,omitempty"`
+	SuccessThreshold  uint32            `json:"successThreshold,omitempty" yaml:"successThreshold,omitempty"`
+	Interval          Duration          `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Timeout           Duration          `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	InitialState      State             `json:"initialState,omitempty" yaml:"initialState,omitempty"`
+	NearTripMargin    float64           `json:"nearTripMargin,omitempty" yaml:"nearTripMargin,omitempty"`
+	ReadyToTripPolicy string            `json:"readyToTripPolicy,omitempty" yaml:"readyToTripPolicy,omitempty"`
+	ReadyToTripParams ReadyToTripParams `json:"readyToTripParams,omitempty" yaml:"readyToTripParams,omitempty"`
+}
+
+// ToSettings returns the Settings described by the SettingsConfig.
+// It returns an error if the policy name is unknown or its parameters are invalid.
+func (c SettingsConfig) ToSettings() (Settings, error) {
+	st := Settings{
+		Name:             c.Name,
+		MaxRequests:      c.MaxRequests,
+		SuccessThreshold: c.SuccessThreshold,
+		Interval:         time.Duration(c.Interval),
+		Timeout:          time.Duration(c.Timeout),
+		InitialState:     c.InitialState,
+		NearTripMargin:   c.NearTripMargin,
+	}
+
+	p := c.ReadyToTripParams
+	switch c.ReadyToTripPolicy {
+	case ReadyToTripDefault:
+	case ReadyToTripConsecutive:
+		if p.ConsecutiveFailures == 0 {
+			return Settings{}, fmt.Errorf("gobreaker: policy %q requires consecutiveFailures > 0", c.ReadyToTripPolicy)
+		}
+		threshold := p.ConsecutiveFailures
+		st.ReadyToTrip = func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= threshold
+		}
+		st.TripMargin = ConsecutiveFailuresMargin(threshold)
+	case ReadyToTripRatio:
+		if p.FailureRatio <= 0 || p.FailureRatio > 1 {
+			return Settings{}, fmt.Errorf("gobreaker: policy %q requires 0 < failureRatio <= 1", c.ReadyToTripPolicy)
+		}
+		minRequests, ratio := p.MinRequests, p.FailureRatio
+		st.ReadyToTrip = func(counts Counts) bool {
+			if counts.Requests == 0 || counts.Requests < minRequests {
+				return false
+			}
+			return counts.FailureRatio() >= ratio
+		}
+		st.TripMargin = FailureRatioMargin(minRequests, ratio)
+	default:
+		return Settings{}, fmt.Errorf("gobreaker: unknown ReadyToTrip policy %q", c.ReadyToTripPolicy)
+	}
+
+	return st, nil
+}
@@ -0,0 +1,105 @@
+package gobreaker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSettingsConfigFromYAML(t *testing.T) {
+	src := `
+name: yaml
+maxRequests: 2
+interval: 30s
+timeout: 90s
+readyToTripPolicy: ratio
+readyToTripParams:
+  minRequests: 3
+  failureRatio: 0.6
+`
+	var c SettingsConfig
+	assert.Nil(t, yaml.Unmarshal([]byte(src), &c))
+	assert.Equal(t, Duration(30*time.Second), c.Interval)
+
+	st, err := c.ToSettings()
+	assert.Nil(t, err)
+	cb := NewCircuitBreaker(st)
+	assert.Equal(t, "yaml", cb.Name())
+	assert.Equal(t, uint32(2), cb.maxRequests)
+	assert.Equal(t, 30*time.Second, cb.interval)
+	assert.Equal(t, 90*time.Second, cb.timeout)
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb)) // failure ratio: 2/3 >= 0.6
+	assert.Equal(t, StateOpen, cb.State())
+
+	out, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	var c2 SettingsConfig
+	assert.Nil(t, yaml.Unmarshal(out, &c2))
+	assert.Equal(t, c, c2)
+}
+
+func TestSettingsConfigFromJSON(t *testing.T) {
+	var c SettingsConfig
+	src := `{"name":"json","timeout":"1m","readyToTripPolicy":"consecutive","readyToTripParams":{"consecutiveFailures":2}}`
+	assert.Nil(t, json.Unmarshal([]byte(src), &c))
+
+	st, err := c.ToSettings()
+	assert.Nil(t, err)
+	cb := NewCircuitBreaker(st)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	out, err := json.Marshal(c)
+	assert.Nil(t, err)
+	var c2 SettingsConfig
+	assert.Nil(t, json.Unmarshal(out, &c2))
+	assert.Equal(t, c, c2)
+}
+
+func TestSettingsConfigInitialState(t *testing.T) {
+	var c SettingsConfig
+	assert.Nil(t, yaml.Unmarshal([]byte("name: start-open\ninitialState: open\n"), &c))
+	assert.Equal(t, StateOpen, c.InitialState)
+
+	st, err := c.ToSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, StateOpen, NewCircuitBreaker(st).State())
+
+	out, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "initialState: open")
+	var c2 SettingsConfig
+	assert.Nil(t, yaml.Unmarshal(out, &c2))
+	assert.Equal(t, c, c2)
+
+	out, err = json.Marshal(c)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"initialState":"open"`)
+
+	assert.NotNil(t, yaml.Unmarshal([]byte("initialState: ajar\n"), &c))
+}
+
+func TestSettingsConfigErrors(t *testing.T) {
+	_, err := SettingsConfig{ReadyToTripPolicy: "unknown"}.ToSettings()
+	assert.Error(t, err)
+	_, err = SettingsConfig{ReadyToTripPolicy: ReadyToTripConsecutive}.ToSettings()
+	assert.Error(t, err)
+	_, err = SettingsConfig{ReadyToTripPolicy: ReadyToTripRatio}.ToSettings()
+	assert.Error(t, err)
+
+	st, err := SettingsConfig{}.ToSettings()
+	assert.Nil(t, err)
+	assert.Nil(t, st.ReadyToTrip)
+
+	var d Duration
+	assert.Error(t, d.UnmarshalText([]byte("soon")))
+}
@@ -0,0 +1,129 @@
+package gobreaker
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrDependencyCycle is returned by AddDependent when the dependent would trip the CircuitBreaker itself,
+// directly or through its own dependents.
+// 该错误在 AddDependent 会形成环（包括把熔断器加为自己的依赖）时返回
+var ErrDependencyCycle = errors.New("circuit breaker dependency cycle")
+
+// dependencyMutex 串行化所有依赖关系的变更，避免两个并发的 AddDependent 一起形成环
+var dependencyMutex sync.Mutex
+
+// AddDependent makes child a dependent of the CircuitBreaker:
+// whenever the CircuitBreaker changes to the open state, child is tripped by Trip,
+// and if Settings.ResetDependents is true, child is reset by Reset
+// whenever the CircuitBreaker changes to the closed state.
+// A dependent can have dependents of its own, which are tripped in turn.
+//
+// The dependents are updated in a background goroutine after the CircuitBreaker has released its lock,
+// in the order of the state changes, so a dependent may still accept requests for a short time
+// after the CircuitBreaker trips.
+//
+// AddDependent returns ErrDependencyCycle if child is the CircuitBreaker itself
+// or already depends on it, directly or indirectly. Adding the same child twice has no effect.
+func (cb *CircuitBreaker) AddDependent(child *CircuitBreaker) error {
+	dependencyMutex.Lock()
+	defer dependencyMutex.Unlock()
+
+	if child == cb || child.dependsOn(cb) {
+		return ErrDependencyCycle
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	for _, d := range cb.dependents {
+		if d == child {
+			return nil
+		}
+	}
+	cb.dependents = append(cb.dependents, child)
+	return nil
+}
+
+// RemoveDependent stops propagating the state changes of the CircuitBreaker to child.
+// It does nothing if child is not a dependent of the CircuitBreaker.
+func (cb *CircuitBreaker) RemoveDependent(child *CircuitBreaker) {
+	dependencyMutex.Lock()
+	defer dependencyMutex.Unlock()
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	for i, d := range cb.dependents {
+		if d == child {
+			cb.dependents = append(cb.dependents[:i:i], cb.dependents[i+1:]...)
+			return
+		}
+	}
+}
+
+// dependsOn 判断 parent 是否可以通过依赖关系到达 cb，调用方需要持有 dependencyMutex。
+// 每次只持有一个熔断器的锁
+func (cb *CircuitBreaker) dependsOn(parent *CircuitBreaker) bool {
+	visited := map[*CircuitBreaker]bool{}
+	stack := []*CircuitBreaker{cb}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n == parent {
+			return true
+		}
+		if visited[n] {
+			continue
+		}
+		visited[n] = true
+		stack = append(stack, n.dependentsCopy()...)
+	}
+	return false
+}
+
+// dependentsCopy 返回依赖的熔断器的副本
+func (cb *CircuitBreaker) dependentsCopy() []*CircuitBreaker {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return append([]*CircuitBreaker(nil), cb.dependents...)
+}
+
+// propagateState 把状态变更加入队列，由后台 goroutine 在释放锁之后同步给依赖的熔断器，调用方需要持有锁
+func (cb *CircuitBreaker) propagateState(state State) {
+	if len(cb.dependents) == 0 || (state == StateClosed && !cb.resetDependents) || state == StateHalfOpen {
+		return
+	}
+
+	cb.propagation = append(cb.propagation, state)
+	if !cb.propagating {
+		cb.propagating = true
+		go cb.runPropagation()
+	}
+}
+
+// runPropagation 按顺序处理队列中的状态变更，队列为空时退出。
+// 调用依赖的熔断器时不持有 cb 的锁，因此依赖之间的级联不会死锁
+func (cb *CircuitBreaker) runPropagation() {
+	for {
+		cb.mutex.Lock()
+		if len(cb.propagation) == 0 {
+			cb.propagating = false
+			cb.mutex.Unlock()
+			return
+		}
+		state := cb.propagation[0]
+		cb.propagation = cb.propagation[1:]
+		dependents := append([]*CircuitBreaker(nil), cb.dependents...)
+		cb.mutex.Unlock()
+
+		for _, d := range dependents {
+			if state == StateOpen {
+				d.Trip()
+			} else {
+				d.Reset()
+			}
+		}
+	}
+}
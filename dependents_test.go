@@ -0,0 +1,56 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddDependent(t *testing.T) {
+	parent := NewCircuitBreaker(Settings{Name: "parent", ResetDependents: true})
+	child := NewCircuitBreaker(Settings{Name: "child"})
+	grandchild := NewCircuitBreaker(Settings{Name: "grandchild"})
+	assert.Nil(t, parent.AddDependent(child))
+	assert.Nil(t, parent.AddDependent(child))
+	assert.Nil(t, child.AddDependent(grandchild))
+	assert.Equal(t, 1, len(parent.dependentsCopy()))
+
+	parent.Trip()
+	assert.True(t, waitState(child, StateOpen))
+	assert.True(t, waitState(grandchild, StateOpen))
+
+	// child doesn't reset its own dependents
+	parent.Reset()
+	assert.True(t, waitState(child, StateClosed))
+	assert.Equal(t, StateOpen, grandchild.State())
+
+	// a dependent tripping on its own doesn't affect the parent
+	child.Trip()
+	assert.True(t, waitState(child, StateOpen))
+	assert.Equal(t, StateClosed, parent.State())
+
+	parent.RemoveDependent(child)
+	parent.RemoveDependent(child)
+	child.Reset()
+	parent.Trip()
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Equal(t, StateClosed, child.State())
+}
+
+func TestAddDependentCycle(t *testing.T) {
+	a := NewCircuitBreaker(Settings{Name: "a"})
+	b := NewCircuitBreaker(Settings{Name: "b"})
+	c := NewCircuitBreaker(Settings{Name: "c"})
+
+	assert.Equal(t, ErrDependencyCycle, a.AddDependent(a))
+	assert.Nil(t, a.AddDependent(b))
+	assert.Nil(t, b.AddDependent(c))
+	assert.Equal(t, ErrDependencyCycle, b.AddDependent(a))
+	assert.Equal(t, ErrDependencyCycle, c.AddDependent(a))
+
+	// a diamond is not a cycle
+	assert.Nil(t, a.AddDependent(c))
+	a.Trip()
+	assert.True(t, waitState(c, StateOpen))
+}
@@ -0,0 +1,126 @@
+package gobreaker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Event is a request outcome in a trace replayed by Diff.
+// Offset is the time of the request relative to the start of the trace.
+// Events in a trace must be sorted by Offset.
+type Event struct {
+	Offset  time.Duration
+	Success bool
+}
+
+// Divergence is a point in a trace where two CircuitBreakers ended up in different states.
+type Divergence struct {
+	Index  int           // index of the event in the trace
+	Offset time.Duration // offset of the event in the trace
+	StateA State         // state of the first CircuitBreaker after the event
+	StateB State         // state of the second CircuitBreaker after the event
+}
+
+// DiffReport describes how two CircuitBreakers behaved on the same trace.
+type DiffReport struct {
+	Events      int          // number of events replayed
+	RejectedA   int          // number of events rejected by the first CircuitBreaker
+	RejectedB   int          // number of events rejected by the second CircuitBreaker
+	Divergences []Divergence // events after which the states differ
+}
+
+// Equal returns true if the two CircuitBreakers were in the same state after every event.
+func (r DiffReport) Equal() bool {
+	return len(r.Divergences) == 0
+}
+
+// DivergenceRatio returns the fraction of events after which the states differ.
+func (r DiffReport) DivergenceRatio() float64 {
+	if r.Events == 0 {
+		return 0
+	}
+	return float64(len(r.Divergences)) / float64(r.Events)
+}
+
+// Diff replays the same trace through fresh copies of a and b and reports where their states diverged.
+// Each event is a request that completes instantly at its offset.
+// Only the configuration of a and b is used: their current state and counts are left untouched,
+// and OnStateChange, OnNearTrip and AdmissionFunc are not called and Logger is not used during the replay.
+// IntervalJitter is ignored and the random admissions of HalfOpenAdmitRatio use a fixed seed instead of RandFunc,
+// so that the replay is deterministic.
+func Diff(a, b *CircuitBreaker, events []Event) DiffReport {
+	start := time.Now()
+	ra, rb := a.replayCopy(start), b.replayCopy(start)
+
+	report := DiffReport{Events: len(events)}
+	for i, e := range events {
+		now := start.Add(e.Offset)
+		stateA, admittedA := ra.replay(e.Success, now)
+		stateB, admittedB := rb.replay(e.Success, now)
+		if !admittedA {
+			report.RejectedA++
+		}
+		if !admittedB {
+			report.RejectedB++
+		}
+		if stateA != stateB {
+			report.Divergences = append(report.Divergences, Divergence{i, e.Offset, stateA, stateB})
+		}
+	}
+	return report
+}
+
+// replayCopy 返回一个配置相同、状态全新的熔断器，用于回放，不会调用任何外部回调
+func (cb *CircuitBreaker) replayCopy(start time.Time) *CircuitBreaker {
+	cb.mutex.Lock()
+	// 不复制 intervalJitter，回放使用固定的周期，半开状态的放行概率使用固定的种子，结果可以重复
+	c := &CircuitBreaker{
+		name:                              cb.name,
+		maxRequests:                       cb.maxRequests,
+		successThreshold:                  cb.successThreshold,
+		limitInFlight:                     cb.limitInFlight,
+		halfOpenSuccessRatio:              cb.halfOpenSuccessRatio,
+		halfOpenSampleSize:                cb.halfOpenSampleSize,
+		halfOpenAdmitRatio:                cb.halfOpenAdmitRatio,
+		interval:                          cb.interval,
+		clearAfterConsecutiveSuccesses:    cb.clearAfterConsecutiveSuccesses,
+		timeout:                           cb.timeout,
+		backoffExpiry:                     cb.backoffExpiry,
+		readyToTrip:                       cb.readyToTrip,
+		evaluateOnSuccess:                 cb.evaluateOnSuccess,
+		tripImmediately:                   cb.tripImmediately,
+		isSuccessful:                      cb.isSuccessful,
+		classifyError:                     cb.classifyError,
+		attributeStaleToCurrentGeneration: cb.attributeStaleToCurrentGeneration,
+		halfLife:                          cb.halfLife,
+		maxConcurrent:                     cb.maxConcurrent,
+		logger:                            nopLogger{},
+		clock:                             cb.clock,
+		maxOpenDuration:                   cb.maxOpenDuration,
+		openSince:                         cb.openSince,
+	}
+	if cb.window != nil {
+		c.window = cb.window.empty()
+	}
+	c.random = rand.New(rand.NewSource(1)).Float64
+	if cb.recent != nil {
+		c.recent = newCountWindow(uint32(len(cb.recent.outcomes)))
+	}
+	cb.mutex.Unlock()
+
+	c.toNewGeneration(start)
+	return c
+}
+
+// replay 在 now 时刻执行一个立即完成的请求，返回请求后的状态以及请求是否被放行
+func (cb *CircuitBreaker) replay(success bool, now time.Time) (State, bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	generation, err := cb.beforeRequestAt(now)
+	if err == nil {
+		cb.afterRequestAt(generation, success, 0, now, nil)
+	}
+	state, _ := cb.currentState(now)
+	return state, err == nil
+}
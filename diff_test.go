@@ -0,0 +1,60 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func failureTrace(n int, step time.Duration) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{Offset: time.Duration(i) * step}
+	}
+	return events
+}
+
+func TestDiffIdentical(t *testing.T) {
+	a := newCustom()
+	b := newCustom()
+	events := append(failureTrace(5, time.Second), Event{Offset: 100 * time.Second, Success: true})
+
+	report := Diff(a, b, events)
+	assert.True(t, report.Equal())
+	assert.Equal(t, 6, report.Events)
+	assert.Equal(t, report.RejectedA, report.RejectedB)
+	assert.Equal(t, 0.0, report.DivergenceRatio())
+
+	// the breakers themselves are untouched
+	assert.Equal(t, StateClosed, a.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), a.Counts())
+}
+
+func TestDiffDifferent(t *testing.T) {
+	a := NewCircuitBreaker(Settings{})
+	b := NewCircuitBreaker(Settings{
+		ReadyToTrip: func(counts Counts) bool { return counts.ConsecutiveFailures >= 3 },
+	})
+
+	report := Diff(a, b, failureTrace(8, time.Second))
+	assert.False(t, report.Equal())
+	assert.Equal(t, 2, report.RejectedA) // a trips at the 6th failure
+	assert.Equal(t, 5, report.RejectedB) // b trips at the 3rd failure
+	assert.Equal(t, Divergence{2, 2 * time.Second, StateClosed, StateOpen}, report.Divergences[0])
+	assert.Equal(t, 3, len(report.Divergences))
+	assert.Equal(t, 3.0/8, report.DivergenceRatio())
+
+	assert.True(t, Diff(a, b, nil).Equal())
+}
+
+func TestDiffWindowType(t *testing.T) {
+	readyToTrip := func(counts Counts) bool { return counts.TotalFailures >= 3 }
+	a := NewCircuitBreaker(Settings{ReadyToTrip: readyToTrip})
+	b := NewCircuitBreaker(Settings{ReadyToTrip: readyToTrip, WindowType: WindowTypeCount, WindowSize: 3})
+	events := []Event{{Offset: 0}, {Offset: time.Second, Success: true}, {Offset: 2 * time.Second, Success: true},
+		{Offset: 3 * time.Second}, {Offset: 4 * time.Second}}
+
+	report := Diff(a, b, events)
+	assert.Equal(t, []Divergence{{4, 4 * time.Second, StateOpen, StateClosed}}, report.Divergences)
+}
@@ -0,0 +1,15 @@
+package gobreaker
+
+import "golang.org/x/sync/errgroup"
+
+// Go runs req in a new goroutine of g, guarded by the CircuitBreaker as in Do.
+// A rejection by the CircuitBreaker, such as ErrOpenState or ErrTooManyRequests,
+// is returned to g like any other error of req.
+// If g was created by errgroup.WithContext, the first such error cancels the context of g,
+// so a single open breaker fails the whole group fast.
+// Wrap req's errors or check errors.Is on the result of g.Wait to tell rejections from failures.
+func (cb *CircuitBreaker) Go(g *errgroup.Group, req func() error) {
+	g.Go(func() error {
+		return cb.Do(req)
+	})
+}
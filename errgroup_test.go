@@ -0,0 +1,47 @@
+package gobreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestGo(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	var g errgroup.Group
+	for i := 0; i < 3; i++ {
+		cb.Go(&g, func() error { return nil })
+	}
+	assert.Nil(t, g.Wait())
+	assert.Equal(t, newCounts(3, 3, 0, 3, 0), cb.Counts())
+}
+
+func TestGoRejectionCancelsGroup(t *testing.T) {
+	open := NewCircuitBreaker(Settings{})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(open))
+	}
+	assert.Equal(t, StateOpen, open.State())
+
+	closed := NewCircuitBreaker(Settings{})
+	g, ctx := errgroup.WithContext(context.Background())
+	canceled := make(chan bool, 1)
+	closed.Go(g, func() error {
+		select {
+		case <-ctx.Done():
+			canceled <- true
+			return ctx.Err()
+		case <-time.After(time.Duration(5) * time.Second):
+			canceled <- false
+			return nil
+		}
+	})
+	open.Go(g, func() error { return nil })
+
+	assert.True(t, errors.Is(g.Wait(), ErrOpenState))
+	assert.True(t, <-canceled)
+}
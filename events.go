@@ -0,0 +1,76 @@
+package gobreaker
+
+import "time"
+
+// defaultEventBufferSize 是订阅通道的默认缓冲大小
+const defaultEventBufferSize = 16
+
+// StateChange is a state change of a CircuitBreaker delivered to subscribers.
+// Counts is a copy of the Counts as it was right before the change cleared it.
+type StateChange struct {
+	Name   string
+	From   State
+	To     State
+	At     time.Time
+	Counts Counts
+}
+
+// Subscribe returns a channel that receives every subsequent state change of the CircuitBreaker.
+// Each subscriber gets its own channel, buffered by Settings.EventBufferSize.
+// The CircuitBreaker never waits for a subscriber: when the buffer of a slow subscriber is full,
+// the new event is dropped, or the oldest buffered event if Settings.DropOldestEvents is true.
+// Call Unsubscribe when the channel is no longer read.
+// All channels are closed by Close, and Subscribe returns a closed channel after Close.
+func (cb *CircuitBreaker) Subscribe() <-chan StateChange {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	ch := make(chan StateChange, cb.eventBufferSize)
+	if cb.closed {
+		close(ch)
+		return ch
+	}
+	cb.subscribers = append(cb.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops sending state changes to ch, which was returned by Subscribe, and closes it.
+// Events already buffered in ch can still be received.
+// Unsubscribe does nothing if ch is not subscribed.
+func (cb *CircuitBreaker) Unsubscribe(ch <-chan StateChange) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	for i, sub := range cb.subscribers {
+		if sub == ch {
+			cb.subscribers = append(cb.subscribers[:i], cb.subscribers[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+// publishEvent 将状态变更发送给所有订阅者，调用方需要持有锁。
+// 发送不会阻塞，缓冲已满时丢弃新事件，或在 dropOldestEvents 为 true 时丢弃最旧的事件
+func (cb *CircuitBreaker) publishEvent(event StateChange) {
+	for _, ch := range cb.subscribers {
+		select {
+		case ch <- event:
+			continue
+		default:
+		}
+		if !cb.dropOldestEvents {
+			continue
+		}
+
+		// 持有锁时只有订阅者会并发接收，腾出位置后再发送一次，仍然失败就放弃
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
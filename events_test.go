@@ -0,0 +1,50 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "cb"})
+	a := cb.Subscribe()
+	b := cb.Subscribe()
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	for _, ch := range []<-chan StateChange{a, b} {
+		event := <-ch
+		assert.Equal(t, "cb", event.Name)
+		assert.Equal(t, StateClosed, event.From)
+		assert.Equal(t, StateOpen, event.To)
+		assert.Equal(t, newCounts(6, 0, 6, 0, 6), event.Counts)
+		assert.False(t, event.At.IsZero())
+	}
+
+	cb.Unsubscribe(a)
+	_, ok := <-a
+	assert.False(t, ok)
+	cb.Unsubscribe(a) // no effect
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	event := <-b
+	assert.Equal(t, StateHalfOpen, event.To)
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{EventBufferSize: 1})
+	ch := cb.Subscribe()
+	cb.Trip()
+	cb.Reset() // dropped without blocking
+	assert.Equal(t, StateOpen, (<-ch).To)
+
+	cb = NewCircuitBreaker(Settings{EventBufferSize: 1, DropOldestEvents: true})
+	ch = cb.Subscribe()
+	cb.Trip()
+	cb.Reset()
+	assert.Equal(t, StateClosed, (<-ch).To)
+}
@@ -3,9 +3,15 @@
 package gobreaker
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,6 +29,8 @@ const (
 	StateOpen
 )
 
+// ErrTooManyRequests, ErrOpenState and ErrTooManyConcurrent are wrapped with the name of the CircuitBreaker when returned,
+// so use errors.Is to detect them.
 var (
 	// ErrTooManyRequests is returned when the CB state is half open and the requests count is over the cb maxRequests
 	// 该错误在状态为半开且请求数超过 maxRequests 时返回
@@ -30,6 +38,27 @@ var (
 	// ErrOpenState is returned when the CB state is open
 	// 该错误在状态为开启时返回
 	ErrOpenState = errors.New("circuit breaker is open")
+	// ErrNotAdmitted is returned when AdmissionFunc rejects a request without an error
+	// 该错误在 AdmissionFunc 拒绝请求且没有返回错误时返回
+	ErrNotAdmitted = errors.New("request not admitted")
+	// ErrRequestTimeout is returned when a request doesn't return within RequestTimeout
+	// 该错误在请求没有在 RequestTimeout 内返回时返回
+	ErrRequestTimeout = errors.New("request timeout")
+	// ErrInvalidForcedState is returned when SetForcedState is called with a state other than open or closed
+	// 该错误在 SetForcedState 的参数不是开启或关闭状态时返回
+	ErrInvalidForcedState = errors.New("forced state must be open or closed")
+	// ErrTooManyConcurrent is returned when MaxConcurrent requests are already in flight
+	// 该错误在正在进行的请求数达到 MaxConcurrent 时返回
+	ErrTooManyConcurrent = errors.New("too many concurrent requests")
+	// ErrBreakerClosed is returned when the CB has been closed by Close
+	// 该错误在熔断器调用 Close 之后返回
+	ErrBreakerClosed = errors.New("circuit breaker is closed")
+	// ErrNilRequest is returned when the request passed to Execute is nil
+	// 该错误在传给 Execute 的请求为 nil 时返回
+	ErrNilRequest = errors.New("nil request")
+	// ErrDraining is returned when the CB has stopped accepting requests by Drain
+	// 该错误在熔断器调用 Drain 之后返回
+	ErrDraining = errors.New("circuit breaker is draining")
 )
 
 // String implements stringer interface.
@@ -47,6 +76,120 @@ func (s State) String() string {
 	}
 }
 
+// ParseState returns the State whose String is s.
+func ParseState(s string) (State, error) {
+	switch s {
+	case "closed":
+		return StateClosed, nil
+	case "half-open":
+		return StateHalfOpen, nil
+	case "open":
+		return StateOpen, nil
+	default:
+		return StateClosed, fmt.Errorf("gobreaker: unknown state: %q", s)
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+// The State is encoded as its String, e.g. "half-open".
+func (s State) MarshalJSON() ([]byte, error) {
+	switch s {
+	case StateClosed, StateHalfOpen, StateOpen:
+		return json.Marshal(s.String())
+	default:
+		return nil, fmt.Errorf("gobreaker: cannot marshal unknown state: %d", s)
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// It accepts the strings returned by String and, for compatibility, the integer values of the states.
+func (s *State) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		// 兼容以整数保存的状态
+		var n int
+		if json.Unmarshal(data, &n) != nil {
+			return fmt.Errorf("gobreaker: invalid state: %s", data)
+		}
+		str = State(n).String()
+	}
+
+	state, err := ParseState(str)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, so that text formats such as YAML
+// encode the State as its String, like MarshalJSON.
+func (s State) MarshalText() ([]byte, error) {
+	switch s {
+	case StateClosed, StateHalfOpen, StateOpen:
+		return []byte(s.String()), nil
+	default:
+		return nil, fmt.Errorf("gobreaker: cannot marshal unknown state: %d", s)
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// It accepts the strings returned by String.
+func (s *State) UnmarshalText(text []byte) error {
+	state, err := ParseState(string(text))
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// ErrorClass is a type that represents how the result of a request is counted.
+type ErrorClass int
+
+// These constants are classes of request results returned by Settings.ClassifyError.
+// 请求结果的分类
+const (
+	// ErrorClassSuccess 计为成功
+	ErrorClassSuccess ErrorClass = iota
+	// ErrorClassFailure 计为失败
+	ErrorClassFailure
+	// ErrorClassIgnore 既不计为成功也不计为失败，请求本身也不计数
+	ErrorClassIgnore
+)
+
+// String implements stringer interface.
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassSuccess:
+		return "success"
+	case ErrorClassFailure:
+		return "failure"
+	case ErrorClassIgnore:
+		return "ignore"
+	default:
+		return fmt.Sprintf("unknown error class: %d", c)
+	}
+}
+
+// DetailedState is the state of CircuitBreaker together with its operational modes.
+// State is the base state, the same value that CircuitBreaker.State returns.
+// Each operational mode of CircuitBreaker adds a flag here and documents
+// which base State it reports.
+//
+// Forced is set while a state is forced by SetForcedState; State is then the forced state.
+//
+// Draining is set once Drain has been called, and Drained once, in addition,
+// no request is in flight any more. A draining CircuitBreaker keeps reporting its own State,
+// which can still change with the outcomes of the requests in flight and with Timeout,
+// although every new request is refused with ErrDraining.
+type DetailedState struct {
+	State    State
+	Forced   bool
+	Draining bool
+	Drained  bool
+}
+
 // Counts holds the numbers of requests and their successes/failures.
 // CircuitBreaker clears the internal Counts either
 // on the change of the state or at the closed-state intervals.
@@ -60,6 +203,94 @@ type Counts struct {
 	TotalFailures        uint32 // 总失败次数
 	ConsecutiveSuccesses uint32 // 连续成功次数
 	ConsecutiveFailures  uint32 // 连续失败次数
+	TotalItems           uint32 // ExecutePartial 上报的总条目数
+	SucceededItems       uint32 // ExecutePartial 上报的成功条目数
+	SlowCalls            uint32 // 耗时超过 SlowCallDuration 的请求数
+	Rejections           uint32 // 因开启状态、半开状态请求过多或并发数达到上限而被拒绝的请求数
+}
+
+// ItemSuccessRatio returns the ratio of succeeded items to total items
+// reported by ExecutePartial. It returns 1 if no items were reported.
+func (c Counts) ItemSuccessRatio() float64 {
+	if c.TotalItems == 0 {
+		return 1
+	}
+	return float64(c.SucceededItems) / float64(c.TotalItems)
+}
+
+// FailureRatio returns the ratio of TotalFailures to Requests.
+// It returns 0 if there are no requests.
+func (c Counts) FailureRatio() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.TotalFailures) / float64(c.Requests)
+}
+
+// SuccessRatio returns the ratio of TotalSuccesses to Requests.
+// It returns 0 if there are no requests.
+// Requests still in flight count toward neither ratio,
+// so FailureRatio and SuccessRatio may add up to less than 1.
+func (c Counts) SuccessRatio() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.TotalSuccesses) / float64(c.Requests)
+}
+
+// Stats is the state of a CircuitBreaker at one point in time with the ratios derived from its Counts.
+// Expiry is the time of the next scheduled transition, as returned by ExpiresAt.
+type Stats struct {
+	State        State
+	Counts       Counts
+	FailureRatio float64
+	SuccessRatio float64
+	Expiry       time.Time
+}
+
+// Add returns the sum of c and other, for example to aggregate the Counts of several CircuitBreakers
+// guarding the same service. The totals are added, saturating at math.MaxUint32 instead of wrapping
+// around, so that a sum that overflows uint32 reads as "at least math.MaxUint32" and never as a small number.
+// Consecutive counts can't be added across CircuitBreakers,
+// so ConsecutiveSuccesses and ConsecutiveFailures are the larger of the two.
+// Add doesn't modify c or other.
+func (c Counts) Add(other Counts) Counts {
+	return Counts{
+		Requests:             addSaturating(c.Requests, other.Requests),
+		TotalSuccesses:       addSaturating(c.TotalSuccesses, other.TotalSuccesses),
+		TotalFailures:        addSaturating(c.TotalFailures, other.TotalFailures),
+		ConsecutiveSuccesses: maxUint32(c.ConsecutiveSuccesses, other.ConsecutiveSuccesses),
+		ConsecutiveFailures:  maxUint32(c.ConsecutiveFailures, other.ConsecutiveFailures),
+		TotalItems:           addSaturating(c.TotalItems, other.TotalItems),
+		SucceededItems:       addSaturating(c.SucceededItems, other.SucceededItems),
+		SlowCalls:            addSaturating(c.SlowCalls, other.SlowCalls),
+		Rejections:           addSaturating(c.Rejections, other.Rejections),
+	}
+}
+
+// MergeCounts returns the sum of counts as computed by Counts.Add.
+// It returns zero Counts if counts is empty.
+func MergeCounts(counts ...Counts) Counts {
+	var sum Counts
+	for _, c := range counts {
+		sum = sum.Add(c)
+	}
+	return sum
+}
+
+// addSaturating 返回 a + b，溢出时返回 math.MaxUint32
+func addSaturating(a, b uint32) uint32 {
+	if a > math.MaxUint32-b {
+		return math.MaxUint32
+	}
+	return a + b
+}
+
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 func (c *Counts) onRequest() {
@@ -78,12 +309,84 @@ func (c *Counts) onFailure() {
 	c.ConsecutiveSuccesses = 0
 }
 
+func (c *Counts) onItems(total, succeeded uint32) {
+	c.TotalItems += total
+	c.SucceededItems += succeeded
+}
+
+func (c *Counts) onSlowCall() {
+	c.SlowCalls++
+}
+
+func (c *Counts) onRejection() {
+	c.Rejections++
+}
+
+func (c *Counts) resetConsecutive() {
+	c.ConsecutiveSuccesses = 0
+	c.ConsecutiveFailures = 0
+}
+
 func (c *Counts) clear() {
 	c.Requests = 0
 	c.TotalSuccesses = 0
 	c.TotalFailures = 0
 	c.ConsecutiveSuccesses = 0
 	c.ConsecutiveFailures = 0
+	c.TotalItems = 0
+	c.SucceededItems = 0
+	c.SlowCalls = 0
+	c.Rejections = 0
+}
+
+// decayedCounts 是指数衰减模式下的总数，每次更新前先按经过的时间衰减
+type decayedCounts struct {
+	requests  float64
+	successes float64
+	failures  float64
+	updated   time.Time
+}
+
+func (d *decayedCounts) decay(now time.Time, halfLife time.Duration) {
+	if !d.updated.IsZero() && now.After(d.updated) {
+		factor := math.Pow(0.5, float64(now.Sub(d.updated))/float64(halfLife))
+		d.requests *= factor
+		d.successes *= factor
+		d.failures *= factor
+	}
+	d.updated = now
+}
+
+// copyTo 将衰减后的总数四舍五入后写入 Counts，连续次数不受影响
+func (d *decayedCounts) copyTo(c *Counts) {
+	c.Requests = uint32(math.Round(d.requests))
+	c.TotalSuccesses = uint32(math.Round(d.successes))
+	c.TotalFailures = uint32(math.Round(d.failures))
+}
+
+// MetricsObserver observes a CircuitBreaker for metrics collection.
+// ObserveResult is called exactly once for every completed request,
+// including requests whose outcome is dropped from Counts because the generation changed,
+// so cumulative counters built on it neither miss nor double-count results.
+// ObserveStateChange is called whenever the state changes, after OnStateChange.
+// Both are called while the CircuitBreaker holds its lock and must not call back into it.
+type MetricsObserver interface {
+	ObserveResult(name string, success bool)
+	ObserveStateChange(name string, from State, to State)
+}
+
+// Clock provides the current time to a CircuitBreaker.
+// A Clock controlled by a test, such as the ManualClock of the gobreakertest package,
+// lets the test move the CircuitBreaker through its states without waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock 是默认的 Clock，返回系统时间
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
 }
 
 // Settings configures CircuitBreaker:
@@ -93,26 +396,379 @@ func (c *Counts) clear() {
 // MaxRequests is the maximum number of requests allowed to pass through
 // when the CircuitBreaker is half-open.
 // If MaxRequests is 0, the CircuitBreaker allows only 1 request.
+// If SuccessThreshold is set, MaxRequests limits only the requests in flight at the same time.
+//
+// SuccessThreshold is the number of consecutive successes needed to close the CircuitBreaker
+// from the half-open state.
+// If SuccessThreshold is 0, MaxRequests is used as before: the CircuitBreaker allows
+// MaxRequests requests in total while half-open and closes after MaxRequests consecutive successes.
+//
+// HalfOpenSuccessRatio, if greater than 0, closes the CircuitBreaker from the half-open state
+// by the ratio of successes instead of consecutive successes, so that a single failure
+// doesn't throw away the progress of the half-open state.
+// The CircuitBreaker takes a sample of the first HalfOpenSampleSize outcomes in the half-open state:
+// it closes as soon as the successes reach HalfOpenSuccessRatio of the sample,
+// and opens again as soon as the failures make that impossible.
+// If HalfOpenSampleSize is 0, a sample of 10 outcomes is used.
+// In this mode SuccessThreshold is ignored and, as with SuccessThreshold,
+// MaxRequests limits only the requests in flight at the same time.
+//
+// HalfOpenAdmitRatio, if greater than 0, makes the half-open state admit requests at random
+// instead of by the slots of MaxRequests, so that traffic ramps up gradually during recovery.
+// Each request is admitted with the probability HalfOpenAdmitRatio * (1 + ConsecutiveSuccesses),
+// capped at 1, so the share of admitted traffic grows with every success and, with
+// HalfOpenSuccessRatio, falls back to HalfOpenAdmitRatio after a failure.
+// The other requests are rejected with ErrTooManyRequests; they are counted as rejections,
+// not as failures. The rules to close or open the CircuitBreaker from the half-open state are unchanged,
+// and HalfOpenWait doesn't apply. HalfOpenAdmitRatio is capped at 1.
+//
+// HalfOpenWait, if greater than 0, makes a request that finds all half-open slots taken
+// wait up to HalfOpenWait instead of being rejected with ErrTooManyRequests right away.
+// The CircuitBreaker doesn't hold its lock while requests wait, and every waiting request
+// is woken when a slot frees and whenever the state or generation changes, so a waiting request
+// never blocks the requests in flight: if they open the CircuitBreaker, it is rejected with ErrOpenState,
+// if they close it, it proceeds, and if the CircuitBreaker is closed by Close, it gets ErrBreakerClosed.
+// The wait applies to Execute, ExecuteContext, RoundTripper and TwoStepCircuitBreaker.Allow;
+// it doesn't end early when the context of ExecuteContext is done.
+//
+// FairHalfOpen, if true, hands out the half-open slots to waiting requests in the order in which they
+// arrived, instead of to whichever request takes the lock first once a slot frees.
+// A request that arrives while others are waiting queues behind them even if a slot is free,
+// and a request whose HalfOpenWait runs out leaves the queue and is rejected with ErrTooManyRequests.
+// The order is the order in which requests reach the admission check of the CircuitBreaker.
+// FairHalfOpen has no effect without HalfOpenWait, since no request waits.
 //
 // Interval is the cyclic period of the closed state
 // for the CircuitBreaker to clear the internal Counts.
 // If Interval is less than or equal to 0, the CircuitBreaker doesn't clear internal Counts during the closed state.
+// By default the outcome of a request that is still in flight when Interval clears the Counts is dropped,
+// so with requests longer than Interval the CircuitBreaker may never see enough failures to trip;
+// set AttributeStaleToCurrentGeneration to count such outcomes in the current generation instead.
+//
+// IntervalJitter, if greater than 0, adds a random offset between -IntervalJitter and +IntervalJitter
+// to every Interval, so that CircuitBreakers started at the same time don't clear their Counts
+// at the same moments. IntervalJitter is limited to half of Interval.
+//
+// RandFunc, if not nil, returns the pseudo-random numbers in [0.0, 1.0) used by IntervalJitter
+// and HalfOpenAdmitRatio, instead of the default source of the math/rand package,
+// e.g. SeededRand to make them reproducible in tests.
+// It is called while the CircuitBreaker holds its lock, so it doesn't need to be safe for concurrent use
+// unless it is shared with other CircuitBreakers or goroutines, as the function returned by SeededRand is.
+//
+// ClearAfterConsecutiveSuccesses, if greater than 0, clears the Counts in the closed state
+// once ConsecutiveSuccesses reaches it, starting a new generation as the end of Interval does.
+// It can be used together with Interval: whichever comes first clears the Counts,
+// and clearing by ClearAfterConsecutiveSuccesses also restarts the Interval.
+// As with Interval, the outcomes of requests in flight when the Counts are cleared are ignored
+// unless AttributeStaleToCurrentGeneration is true.
+//
+// MaxOpenDuration, if greater than 0, is the longest time the CircuitBreaker stays away from the closed state.
+// Once it has been open or half-open for MaxOpenDuration since it last left the closed state,
+// for example because the requests in the half-open state keep failing, it changes to the closed state
+// with cleared Counts, so that a burst of requests is let through and evaluated from scratch.
+// MaxOpenDuration takes precedence over Timeout: when both are due, the CircuitBreaker changes to closed,
+// not half-open. It doesn't apply while the state is forced by SetForcedState.
+//
+// OnStuckOpen, if not nil and StuckOpenAfter is greater than 0, is called when the CircuitBreaker
+// has not been closed for StuckOpenAfter since it last left the closed state, and then every StuckOpenAfter
+// until it is closed again, with the time since it left the closed state, e.g. to page someone.
+// The time includes the half-open state, so a CircuitBreaker that keeps failing its probes
+// and flaps between open and half-open is reported as stuck open, as is one whose Timeout is longer
+// than StuckOpenAfter. It is driven by a timer that is started when the CircuitBreaker leaves the closed state
+// and stopped when it is closed again or Close is called.
+// It is called from the goroutine of the timer, without holding the lock of the CircuitBreaker.
+// StuckOpenAfter is measured in real time, unlike Timeout.
 //
 // Timeout is the period of the open state,
 // after which the state of the CircuitBreaker becomes half-open.
 // If Timeout is less than or equal to 0, the timeout value of the CircuitBreaker is set to 60 seconds.
 //
+// BackoffExpiry, if not nil, is called with the number of times the CircuitBreaker has opened
+// since it was last closed, starting at 1, and returns the period of the open state instead of Timeout.
+// The count is reset when the CircuitBreaker is closed.
+// If BackoffExpiry returns a value less than or equal to 0, Timeout is used.
+// ExponentialBackoff returns a BackoffExpiry that doubles the period on each trip.
+//
+// InitialState is the state of a new CircuitBreaker.
+// If InitialState is StateOpen, the CircuitBreaker starts open for Timeout (or BackoffExpiry)
+// as if it had just tripped, but without calling OnStateChange.
+// The default is StateClosed. NewCircuitBreaker panics if InitialState is not a known State,
+// and NewCircuitBreakerWithValidation returns an error.
+//
 // ReadyToTrip is called with a copy of Counts whenever a request fails in the closed state.
+// If EvaluateOnSuccess is true, it is also called whenever a request succeeds in the closed state,
+// e.g. for a policy that trips on the rate of slow calls.
 // If ReadyToTrip returns true, the CircuitBreaker will be placed into the open state.
 // If ReadyToTrip is nil, default ReadyToTrip is used.
 // Default ReadyToTrip returns true when the number of consecutive failures is more than 5.
 //
+// TripImmediately, if not nil, is called with the error of every request that fails in the closed state,
+// before ReadyToTrip. If it returns true, the CircuitBreaker opens right away regardless of Counts,
+// e.g. on a refused connection. It is not called for failures without an error
+// or in the half-open state, where any failure opens the CircuitBreaker anyway.
+//
+// ConsecutiveFailuresThreshold, if greater than 0 and ReadyToTrip is nil, replaces the default ReadyToTrip
+// with one that returns true when the number of consecutive failures reaches ConsecutiveFailuresThreshold,
+// and the default TripMargin with the matching ConsecutiveFailuresMargin.
+// If ReadyToTrip is set, it takes precedence and ConsecutiveFailuresThreshold is ignored.
+//
 // OnStateChange is called whenever the state of the CircuitBreaker changes.
 //
+// BeforeStateChange, if not nil, is called before every state change, including those made by Trip
+// and Reset, and can veto it by returning false, e.g. to keep the CircuitBreaker from closing
+// during a known maintenance window. A vetoed change doesn't happen and isn't reported to OnStateChange,
+// and the CircuitBreaker restarts the timer of its current state so that the change isn't asked again
+// on every request: an open CircuitBreaker whose Timeout has passed waits another Timeout,
+// a half-open one starts a new generation of probes, and MaxOpenDuration starts over.
+// In the closed state the Counts are kept, and BeforeStateChange is asked again on the next trip.
+// SetForcedState is not subject to BeforeStateChange. It is called while the CircuitBreaker
+// holds its lock and must not call back into it.
+// Use it with care: a BeforeStateChange that keeps returning false can leave the CircuitBreaker
+// open or closed indefinitely, whatever the health of the service.
+//
+// TwoStepCallbackTimeout, if greater than 0, guards against callers of TwoStepCircuitBreaker
+// that forget to call the callback returned by Allow or AllowErr, which would otherwise
+// keep a half-open slot taken forever. A callback not called within TwoStepCallbackTimeout
+// is counted as a failure with ErrRequestTimeout and logged by Logger at the warn level;
+// once the timeout has fired, calling the callback has no effect.
+// With TwoStepCallbackTimeout set, the callback also has no effect when called more than once.
+//
+// OnHalfOpen is called whenever the CircuitBreaker becomes half-open and starts probing,
+// right after OnStateChange, with the number of requests it lets through at the same time,
+// which is MaxRequests. It is not called for a CircuitBreaker created with InitialState StateHalfOpen.
+//
+// OnSuccess and OnFailure are called whenever the outcome of a request is counted,
+// with the Counts right after the update and before any state change it causes.
+// A failure in the half-open state is not added to Counts unless HalfOpenSuccessRatio is set,
+// but OnFailure is still called.
+// OnFailure receives the error of the request, or nil if there is none,
+// e.g. for a TwoStepCircuitBreaker.Allow callback or a RoundTripper response classified as a failure.
+// Outcomes dropped because the generation changed are not reported.
+// Both are called while the CircuitBreaker holds its lock and must not call back into it.
+//
+// OnReject is called with the error whenever a request is rejected
+// because the CircuitBreaker is open, has too many requests in the half-open state,
+// or has MaxConcurrent requests in flight.
+// Such rejections are also counted in Counts.Rejections.
+//
+// OnAdmit is called with the current state whenever a request is admitted,
+// after it is counted in Counts.Requests and before the request itself runs.
+// Like OnReject, it is called while the CircuitBreaker holds its lock and must not call back into it.
+//
+// RejectionError, if not nil, is called with the current state and Counts whenever a request is rejected
+// for one of the reasons given for OnReject, and the error it returns is returned instead of the default one,
+// e.g. a single sentinel for every rejection or a transport-specific error such as a gRPC status.
+// If it returns nil, the default error is returned. The returned error is wrapped so that
+// errors.Is still matches the default error, e.g. ErrOpenState, and Fallback, Retry and the adapters
+// keep treating the request as rejected; errors.Is and errors.As also match the error of RejectionError.
+// It is called while the CircuitBreaker holds its lock, before OnReject, and must not call back into it.
+//
+// Fallback is called by Execute and ExecuteContext with the rejection error
+// instead of returning ErrOpenState or ErrTooManyRequests, and its result is returned instead.
+// It is not called for errors returned by the request itself.
+//
+// RecoverPanic, if true, makes Execute, ExecuteContext and ExecutePartial return a panic
+// in the request as an error, which contains the panic value and the stack trace,
+// instead of causing the same panic again.
+//
+// PanicCountsAsFailure controls how a request that panics is counted by Execute, ExecuteContext,
+// ExecutePartial and Middleware. If PanicCountsAsFailure is nil or points to true, the default,
+// the request is counted as a failure. If it points to false, the request is ignored
+// as if it had never been made, so that a bug in the caller doesn't trip the CircuitBreaker
+// protecting a healthy service. Either way the panic is propagated, or returned as an error
+// if RecoverPanic is true.
+//
+// OnStateChangeWithCounts is like OnStateChange, but also receives a copy of Counts
+// as it was right before the change cleared it, for example to log why the CircuitBreaker tripped.
+//
 // IsSuccessful is called with the error returned from a request.
 // If IsSuccessful returns true, the error is counted as a success.
 // Otherwise the error is counted as a failure.
 // If IsSuccessful is nil, default IsSuccessful is used, which returns false for all non-nil errors.
+//
+// ClassifyError, if not nil, is used instead of IsSuccessful to classify the error returned from a request
+// as ErrorClassSuccess, ErrorClassFailure or ErrorClassIgnore.
+// An ignored request is not counted at all, as if it had never been made, and doesn't hold a half-open slot.
+//
+// IsSuccessfulResult, if not nil, is called with both the result and the error of a request
+// run by Execute, ExecuteContext or a TypedCircuitBreaker, for APIs that report failures in the result.
+// It takes precedence over ClassifyError and IsSuccessful for these requests;
+// requests without a result, such as those of TwoStepCircuitBreaker, RoundTripper and ExecutePartial,
+// are still classified by ClassifyError or IsSuccessful.
+// The result of a TypedCircuitBreaker is passed as its value of type T.
+//
+// OnNearTrip is called whenever a request fails in the closed state, ReadyToTrip returns false,
+// and the margin returned by TripMargin is less than or equal to NearTripMargin.
+// The margin is a value between 0 and 1, where 0 means the trip condition is met
+// and 1 means the breaker is far from tripping.
+// This is best-effort: if a custom ReadyToTrip is set without TripMargin, OnNearTrip is never called.
+//
+// NearTripMargin is the margin at or below which OnNearTrip is called.
+// If NearTripMargin is less than or equal to 0, the default margin of 0.2 is used.
+//
+// TripMargin computes the margin to the trip condition from a copy of Counts.
+// If TripMargin is nil and ReadyToTrip is nil, the margin of the default ReadyToTrip is used,
+// or of the one built from ConsecutiveFailuresThreshold.
+// ConsecutiveFailuresMargin and FailureRatioMargin are provided for the common policies.
+//
+// AdmissionFunc is called with the current state and a copy of Counts before each request,
+// after the built-in checks of the open and half-open states have passed.
+// It can only further restrict admission: a request rejected by the built-in checks never reaches it.
+// If AdmissionFunc returns a non-nil error, the request is rejected with that error.
+// If it returns false without an error, the request is rejected with ErrNotAdmitted.
+// Rejected requests are not counted.
+//
+// TrackAdmissionLatency enables measuring the time spent deciding whether to admit a request,
+// including waiting for the internal lock. The statistics are returned by AdmissionLatencyStats.
+//
+// AttributeStaleToCurrentGeneration changes how the closed-state CircuitBreaker handles the outcome
+// of a request that was admitted before an interval reset of Counts.
+// By default such an outcome is dropped. If AttributeStaleToCurrentGeneration is true,
+// it is counted as a new request of the current generation instead,
+// as long as the state has stayed closed since the request was admitted.
+// This keeps ratios accurate for long requests with a short Interval,
+// at the cost of counting a request in a generation other than the one that admitted it.
+//
+// HalfLife enables exponential decay of Counts as an alternative to the hard reset of Interval.
+// Whenever Counts are updated, Requests, TotalSuccesses and TotalFailures are first multiplied
+// by 0.5^(elapsed/HalfLife), where elapsed is the time since the previous update,
+// and then rounded, so ReadyToTrip sees the decayed values. Consecutive counts are not decayed.
+// If HalfLife is less than or equal to 0, Counts are not decayed.
+// HalfLife is usually combined with an Interval of 0.
+// CircuitBreaker.FailureRate returns the failure rate computed from the unrounded decayed values.
+//
+// IgnoreContextErrors changes how ExecuteContext handles a request that returns
+// after its context was cancelled or its deadline exceeded.
+// By default the error of such a request is passed to IsSuccessful like any other error.
+// If IgnoreContextErrors is true, the request is ignored: it is counted neither as a success nor as a failure,
+// and it doesn't hold a half-open slot.
+//
+// IgnoreContextCancellation, if true, classifies every request error that wraps context.Canceled
+// or context.DeadlineExceeded as ErrorClassIgnore, in Execute, ExecuteContext,
+// TwoStepCircuitBreaker.AllowErr and RoundTripper alike, so that cancellations by callers don't trip
+// the CircuitBreaker. It takes precedence over ClassifyError and IsSuccessful,
+// which classify all other errors. The deadline of RequestTimeout is not a cancellation by the caller,
+// so a request of ExecuteContext that fails with it is still counted as a failure.
+//
+// RequestTimeout is the maximum duration of a request run by Execute or ExecuteContext.
+// If a request doesn't return within RequestTimeout, ErrRequestTimeout is returned
+// and the request is counted as a failure. The late result of the request is discarded,
+// and so is a panic that occurs after the timeout.
+// ExecuteContext also cancels the context passed to the request when the timeout fires.
+// The request runs in its own goroutine, which leaks if the request never returns.
+// If RequestTimeout is less than or equal to 0, requests have no timeout.
+//
+// MetricsObserver, if not nil, is notified of every request result and state change.
+//
+// Logger, if not nil, logs state changes at the info level, except changes to the open state,
+// which are logged at the warn level, and rejected requests at the debug level.
+// Each entry includes the name of the CircuitBreaker, the states and the Counts.
+// If Logger is nil, nothing is logged.
+//
+// WindowType selects how the results of requests are aggregated into Counts in the closed state.
+// WindowTypeGeneration, the default, counts all results since the last state change or interval reset.
+// WindowTypeCount counts only the results of the last WindowSize requests,
+// so that ReadyToTrip sees recent history without abrupt resets:
+// Requests, TotalSuccesses and TotalFailures cover the window plus the requests in flight,
+// while the consecutive counts are unchanged. With WindowTypeCount, Interval and HalfLife are ignored.
+// The window is still cleared on every state change.
+//
+// WindowSize is the number of requests in the window of WindowTypeCount.
+// If WindowSize is 0, the default size of 100 is used.
+//
+// WindowTypeTime counts only the results of the requests in the last RollingWindow,
+// split into BucketCount buckets that rotate as time advances.
+// Results leave the window one bucket at a time, also when no requests are made,
+// and Counts returns the aggregate of the live buckets.
+// As with WindowTypeCount, Interval and HalfLife are ignored.
+// If RollingWindow is less than or equal to 0, the default of 10 seconds is used.
+// If BucketCount is less than or equal to 0, the default of 10 buckets is used.
+//
+// MinimumSamples, if greater than 0, makes ReadyToTrip see the results of exactly
+// the last MinimumSamples requests completed in the closed state.
+// ReadyToTrip is not called until that many results are known, and then it receives Counts
+// whose Requests, TotalSuccesses and TotalFailures cover only those results,
+// so that a threshold on Counts.FailureRatio applies to a fixed number of requests.
+// Unlike the Counts cleared by Interval, the last results are kept across interval resets
+// and ClearAfterConsecutiveSuccesses; they are dropped only on a state change or Reset.
+// MinimumSamples changes only the argument of ReadyToTrip: Counts and the other callbacks
+// still see the counts of the generation or of the window selected by WindowType.
+// The default, 0, calls ReadyToTrip with those counts after every failure.
+//
+// SlowCallDuration is the duration at or above which a request run by Execute, ExecuteContext or RoundTripper
+// is counted in Counts.SlowCalls, whether it succeeds or fails.
+// A request that times out by RequestTimeout is counted as slow if RequestTimeout is at least SlowCallDuration.
+// In the closed state, ReadyToTrip is also called after a slow successful request,
+// so that a policy based on SlowCalls can trip the CircuitBreaker on slowness alone.
+// If SlowCallDuration is less than or equal to 0, slow calls are not counted.
+//
+// SharedStateStore, if not nil, shares the state and Counts with other CircuitBreakers of the same name.
+// Before each request, a shared open state places the CircuitBreaker into the open state.
+// After each request run by Execute, ExecuteContext or TwoStepCircuitBreaker, its result is added
+// to the shared Counts, and a failure trips the CircuitBreaker if ReadyToTrip returns true for them.
+// When the CircuitBreaker trips, the open state is shared for Timeout;
+// when it closes, the shared state is cleared. The half-open state is not shared.
+// If the store returns an error, the CircuitBreaker falls back to its local state and Counts.
+// The store is called while the CircuitBreaker holds its lock when the state changes,
+// so it should enforce its own timeouts.
+//
+// Retry configures Execute and ExecuteContext to retry failed requests. See RetrySettings.
+//
+// MaxConcurrent is the maximum number of requests in flight at the same time in any state.
+// When MaxConcurrent requests are in flight, new requests are rejected with ErrTooManyConcurrent
+// without being run. A request run with RequestTimeout stops counting as in flight when it times out.
+// If MaxConcurrent is 0, the number of requests in flight is not limited.
+//
+// HedgeDelay, if greater than 0, makes Execute and ExecuteContext start a second attempt of a request
+// when the first one hasn't returned within HedgeDelay. The first result that is not classified
+// as a failure is returned; if both attempts fail, the result of the later one is returned.
+// The request is admitted and counted once, with the outcome of the returned result.
+// The second attempt takes another slot of MaxConcurrent until both attempts have returned,
+// and it is not started if no slot is free. ExecuteContext cancels the context of the attempt
+// still running when it returns; Execute leaves it running and discards its result.
+// The request must be safe to run twice concurrently.
+//
+// TrackLatency enables a histogram of the latency of the requests run by Execute, ExecuteContext
+// and RoundTripper, read by CircuitBreaker.LatencyPercentile. A request that times out
+// by RequestTimeout is observed with a latency of RequestTimeout.
+//
+// LatencyBuckets are the upper bounds of the histogram buckets.
+// If LatencyBuckets is empty, DefaultLatencyBuckets are used.
+//
+// ResetLatency, if true, clears the histogram on every new generation, like Counts.
+// Otherwise the histogram covers all requests since the CircuitBreaker was created.
+//
+// ProbeFunc, if not nil, is run in the background to test whether the downstream has recovered,
+// so that the CircuitBreaker doesn't stay open or half-open just because no requests arrive.
+// A goroutine is started when the CircuitBreaker leaves the closed state and stopped when it is closed again.
+// Every ProbeInterval it checks the state: once the open state has expired,
+// and while no request is in flight in the half-open state, it runs ProbeFunc as a half-open request.
+// The error of ProbeFunc is classified and counted like the error of any request,
+// so successful probes close the CircuitBreaker and a failed probe opens it again.
+// A panic in ProbeFunc is recovered and counted as in Execute, whatever RecoverPanic is,
+// since it would otherwise crash the process from the background goroutine.
+// A probe that runs longer than RequestTimeout, or ProbeInterval if RequestTimeout is 0,
+// is counted as a failure with ErrRequestTimeout, so that a hung probe doesn't keep
+// its half-open slot; as with RequestTimeout, its goroutine is left to finish on its own.
+// Call CircuitBreaker.Close to stop the goroutine when the CircuitBreaker is no longer used.
+//
+// ProbeInterval is the period between the checks of the probe goroutine.
+// If ProbeInterval is less than or equal to 0, it is set to 1 second.
+//
+// Clock, if not nil, provides the time used for the transitions between states,
+// such as Interval, Timeout and the times reported by StateSince and ExpiresAt,
+// and for HalfLife and WindowTypeTime. If Clock is nil, the system time is used.
+// Latencies and waits, such as SlowCallDuration, RequestTimeout, HedgeDelay, HalfOpenWait,
+// ProbeInterval and TwoStepCallbackTimeout, are always measured in real time.
+//
+// EventBufferSize is the buffer size of each channel returned by CircuitBreaker.Subscribe.
+// If EventBufferSize is less than or equal to 0, the default size of 16 is used.
+//
+// DropOldestEvents selects which event is dropped when the buffer of a subscriber is full:
+// the oldest buffered event if true, or the new event if false.
+//
+// ResetDependents, if true, makes the CircuitBreaker reset the dependents added by AddDependent
+// whenever it changes to the closed state. Dependents are always tripped when it opens.
 type Settings struct {
 	// 熔断器的名称
 	Name string
@@ -127,6 +783,25 @@ type Settings struct {
 	// 那么会变更为关闭状态
 	MaxRequests uint32
 
+	// SuccessThreshold 是半开状态下变更为关闭状态所需的连续成功次数。
+	// 设置后 MaxRequests 只限制半开状态下同时进行的请求数；为 0 时与原来一样使用 MaxRequests
+	SuccessThreshold uint32
+
+	// HalfOpenSuccessRatio 大于 0 时，半开状态下按前 HalfOpenSampleSize 个结果的成功率
+	// 决定变更为关闭状态还是开启状态，而不是要求连续成功
+	HalfOpenSuccessRatio float64
+	HalfOpenSampleSize   uint32
+
+	// HalfOpenAdmitRatio 大于 0 时，半开状态下按概率放行请求，而不是按 MaxRequests 的名额，
+	// 概率为 HalfOpenAdmitRatio * (1 + 连续成功次数)，最大为 1，未放行的请求记为被拒绝
+	HalfOpenAdmitRatio float64
+
+	// HalfOpenWait 大于 0 时，半开状态下名额已满的请求最多等待该时间，而不是立即被拒绝
+	HalfOpenWait time.Duration
+
+	// FairHalfOpen 为 true 时，等待的请求按到达的顺序获得半开名额，需要同时设置 HalfOpenWait
+	FairHalfOpen bool
+
 	// Interval 是熔断器处于关闭状态时，定期清除内部 Counts 的时间。
 	// 如果 Interval 小于或等于 0，CircuitBreaker 在关闭状态期间不会清除内部计数。
 	// FIXME 这个东西暂时没发现用处何在
@@ -134,28 +809,212 @@ type Settings struct {
 	// 需要定期清空，不然可能会溢出
 	Interval time.Duration
 
+	// IntervalJitter 大于 0 时，每个周期的长度在 Interval 上随机增减不超过该值，避免多个实例同时清空计数
+	IntervalJitter time.Duration
+
+	// RandFunc 返回 [0.0, 1.0) 之间的随机数，用于 IntervalJitter 和 HalfOpenAdmitRatio，为 nil 时使用 math/rand 的默认源
+	RandFunc func() float64
+
+	// ClearAfterConsecutiveSuccesses 大于 0 时，关闭状态下连续成功次数达到该值后清空计数，可以与 Interval 同时使用
+	ClearAfterConsecutiveSuccesses uint32
+
+	// MaxOpenDuration 大于 0 时，离开关闭状态（开启或半开）超过该时长后强制变为关闭状态，优先于 Timeout
+	MaxOpenDuration time.Duration
+
+	// StuckOpenAfter 和 OnStuckOpen 都设置时，离开关闭状态（开启或半开）StuckOpenAfter 后调用 OnStuckOpen，
+	// 之后仍未关闭时每隔 StuckOpenAfter 再调用一次，duration 是离开关闭状态的时间
+	StuckOpenAfter time.Duration
+	OnStuckOpen    func(name string, duration time.Duration)
+
 	// Timeout 是打开状态的持续时间，到时后会变更为半打开状态。
 	// 如果 Timeout 小于或等于 0，则将 CircuitBreaker 的超时值设置为 60 秒。
 	Timeout time.Duration
 
+	// BackoffExpiry 根据关闭后连续开启的次数（从 1 开始）返回开启状态的持续时间，代替 Timeout，
+	// 用于下游长时间不可用时逐渐拉长探测间隔
+	BackoffExpiry func(attempt int) time.Duration
+
+	// 熔断器创建时的初始状态，默认为关闭状态
+	InitialState State
+
 	// 每当请求在关闭状态下失败时，就会调用 ReadyToTrip，参数传递的是 Counts 的副本。
 	// 如果 ReadyToTrip 返回 true，CircuitBreaker 将进入打开状态。
 	// 如果 ReadyToTrip 为 nil，则使用默认 ReadyToTrip。
 	// 当连续失败次数超过 5 次时，默认 ReadyToTrip 返回 true。
 	ReadyToTrip func(counts Counts) bool
 
+	// ConsecutiveFailuresThreshold 大于 0 且 ReadyToTrip 为 nil 时，连续失败次数达到该值就熔断，
+	// 设置了 ReadyToTrip 时忽略
+	ConsecutiveFailuresThreshold uint32
+
+	// EvaluateOnSuccess 为 true 时，关闭状态下请求成功后也会调用 ReadyToTrip
+	EvaluateOnSuccess bool
+
+	// TripImmediately 对关闭状态下失败请求的错误判断，返回 true 时不论计数如何直接熔断
+	TripImmediately func(err error) bool
+
 	// OnStateChange 是熔断器状态变更时的回调函数
 	OnStateChange func(name string, from State, to State)
 
+	// BeforeStateChange 在状态变更前调用，返回 false 时保持当前状态，
+	// 例如维护期间不允许关闭。有 bug 时熔断器可能一直停留在某个状态
+	BeforeStateChange func(from State, to State) bool
+
+	// TwoStepCallbackTimeout 大于 0 时，TwoStepCircuitBreaker 的回调超过该时间未被调用会自动记为失败
+	TwoStepCallbackTimeout time.Duration
+
+	// OnHalfOpen 在熔断器变更为半开状态、开始探测时调用，probeSlots 是允许同时通过的请求数
+	OnHalfOpen func(name string, probeSlots uint32)
+
+	// OnSuccess 和 OnFailure 在请求结果计数后调用，用于观察每次请求
+	OnSuccess func(name string, counts Counts)
+	OnFailure func(name string, counts Counts, err error)
+
+	// OnReject 在请求因开启状态或半开状态请求过多而被拒绝时调用
+	OnReject func(name string, err error)
+
+	// RejectionError 不为 nil 时，请求被拒绝时返回它返回的错误代替默认的错误，返回 nil 时使用默认的错误
+	RejectionError func(state State, counts Counts) error
+
+	// OnAdmit 在请求被放行并计数后、执行请求前调用
+	OnAdmit func(name string, state State)
+
+	// Fallback 在请求被拒绝时代替返回错误，例如返回缓存的数据
+	Fallback func(err error) (interface{}, error)
+
+	// RecoverPanic 为 true 时，请求中的 panic 会转换为错误返回，而不是再次 panic
+	RecoverPanic bool
+
+	// PanicCountsAsFailure 为 nil 或指向 true 时，panic 的请求记为失败；指向 false 时不计数，panic 仍会传播
+	PanicCountsAsFailure *bool
+
+	// OnStateChangeWithCounts 与 OnStateChange 相同，但会额外传入状态变更（清空计数）前的 Counts
+	OnStateChangeWithCounts func(name string, from State, to State, counts Counts)
+
 	// IsSuccessful 判断请求是否成功，传入的 err 是执行用户请求函数后返回的。
 	// （也就是 CircuitBreaker.Execute 的参数 req）
 	// 如果 IsSuccessful 返回 true， 则说明请求发生了错误，否则说明没有错误。
 	// 如果 IsSuccessful 为 nil， 则使用默认 IsSuccessful，该默认函数的逻辑是：
 	// if err == nil { return true }
 	IsSuccessful func(err error) bool
+
+	// ClassifyError 不为 nil 时代替 IsSuccessful 对请求的错误分类，ErrorClassIgnore 表示不计数
+	ClassifyError func(err error) ErrorClass
+
+	// IsSuccessfulResult 根据请求的结果和错误判断是否成功，优先于 ClassifyError 和 IsSuccessful，
+	// 只用于有结果的请求（Execute、ExecuteContext 和 TypedCircuitBreaker）
+	IsSuccessfulResult func(result interface{}, err error) bool
+
+	// OnNearTrip 在关闭状态下请求失败、ReadyToTrip 返回 false，
+	// 但距离熔断条件的 margin 小于等于 NearTripMargin 时调用，用于"差点熔断"的告警
+	OnNearTrip func(name string, counts Counts, margin float64)
+
+	// NearTripMargin 是触发 OnNearTrip 的阈值，小于等于 0 时使用默认值 0.2
+	NearTripMargin float64
+
+	// TripMargin 根据 Counts 计算距离熔断条件的 margin（0 表示已满足熔断条件，1 表示距离很远）
+	TripMargin func(counts Counts) float64
+
+	// AdmissionFunc 是自定义的准入判断，在内置的开启/半开检查通过后调用，
+	// 只能进一步限制请求，不能放行被内置检查拒绝的请求
+	AdmissionFunc func(state State, counts Counts) (admit bool, err error)
+
+	// TrackAdmissionLatency 开启后会统计准入判断（包括等待锁）的耗时
+	TrackAdmissionLatency bool
+
+	// AttributeStaleToCurrentGeneration 为 true 时，关闭状态下因 Interval 清空计数而过期的请求结果
+	// 不再被丢弃，而是计入当前周期
+	AttributeStaleToCurrentGeneration bool
+
+	// HalfLife 是计数指数衰减的半衰期，小于等于 0 时不衰减
+	HalfLife time.Duration
+
+	// IgnoreContextErrors 为 true 时，ExecuteContext 中因 context 取消或超时而返回的请求不计入成功或失败
+	IgnoreContextErrors bool
+
+	// IgnoreContextCancellation 为 true 时，包含 context.Canceled 或 context.DeadlineExceeded 的错误
+	// 分类为 ErrorClassIgnore，优先于 ClassifyError 和 IsSuccessful
+	IgnoreContextCancellation bool
+
+	// RequestTimeout 是单个请求的超时时间，超时的请求记为失败；小于等于 0 表示不限制
+	// 注意请求在单独的 goroutine 中执行，如果请求一直不返回，该 goroutine 会泄漏
+	RequestTimeout time.Duration
+
+	// MetricsObserver 用于收集监控指标，会收到每个请求的结果以及状态变更
+	MetricsObserver MetricsObserver
+
+	// Logger 记录状态变更和被拒绝的请求，为 nil 时不记录
+	Logger Logger
+
+	// WindowType 是关闭状态下统计请求结果的方式，默认按周期（generation）统计
+	WindowType WindowType
+
+	// WindowSize 是 WindowTypeCount 的窗口大小，为 0 时使用默认值 100
+	WindowSize uint32
+
+	// RollingWindow 和 BucketCount 是 WindowTypeTime 的窗口时长和桶的数量
+	RollingWindow time.Duration
+	BucketCount   int
+
+	// MinimumSamples 大于 0 时，ReadyToTrip 只根据最近 MinimumSamples 个请求的结果判断，
+	// 结果数不足时不调用 ReadyToTrip，Interval 到期时也不清空这些结果
+	MinimumSamples uint32
+
+	// SlowCallDuration 是慢调用的阈值，耗时达到该值的请求计入 Counts.SlowCalls
+	SlowCallDuration time.Duration
+
+	// SharedStateStore 用于在多个实例之间共享状态和计数，存储不可用时退回到本地状态
+	SharedStateStore SharedStateStore
+
+	// Retry 是请求失败时的重试设置，MaxAttempts 小于等于 1 时不重试
+	Retry RetrySettings
+
+	// MaxConcurrent 是任意状态下同时进行的最大请求数，为 0 时不限制
+	MaxConcurrent uint32
+
+	// HedgeDelay 大于 0 时，第一次尝试超过该时间仍未返回，会再发起一次尝试，使用先成功的结果
+	HedgeDelay time.Duration
+
+	// TrackLatency 为 true 时记录请求耗时的直方图，LatencyBuckets 是各个桶的上界
+	TrackLatency   bool
+	LatencyBuckets []time.Duration
+
+	// ResetLatency 为 true 时，耗时直方图与 Counts 一样在每个新周期清空
+	ResetLatency bool
+
+	// ProbeFunc 在没有请求时主动探测下游是否恢复，每隔 ProbeInterval 检查一次
+	ProbeFunc     func() error
+	ProbeInterval time.Duration
+
+	// Clock 提供状态切换使用的当前时间，为 nil 时使用系统时间，测试中可以用来控制时间
+	Clock Clock
+
+	// EventBufferSize 是 Subscribe 返回的通道的缓冲大小，小于等于 0 时为 16
+	EventBufferSize int
+
+	// DropOldestEvents 为 true 时，订阅者的缓冲已满时丢弃最旧的事件，否则丢弃新事件
+	DropOldestEvents bool
+
+	// ResetDependents 为 true 时，熔断器变为关闭状态时重置 AddDependent 添加的依赖
+	ResetDependents bool
+}
+
+// Breaker is the interface implemented by CircuitBreaker,
+// so that code using a CircuitBreaker can be tested with a fake.
+type Breaker interface {
+	Name() string
+	State() State
+	Counts() Counts
+	Execute(req func() (interface{}, error)) (interface{}, error)
 }
 
+// CircuitBreaker 需要实现 Breaker
+var _ Breaker = (*CircuitBreaker)(nil)
+
 // CircuitBreaker is a state machine to prevent sending requests that are likely to fail.
+// Each call of Execute takes the lock of the CircuitBreaker once to admit the request
+// and once to record its outcome; for many quick calls to the same service,
+// ExecuteBatch takes it once per batch instead.
 type CircuitBreaker struct {
 	// 虚线内的属性和 Settings 中的相同，如果 Settings 中没有设置，则使用默认值来填充
 	// ==================
@@ -166,30 +1025,205 @@ type CircuitBreaker struct {
 	// 1 在 beforeRequest() 函数中，2 在 afterRequest() -> onSuccess() 的 HalfOpen 分支中
 	// 也就是在请求前会确保请求总数不超过 MaxRequest，请求后如果处于半开状态且连续成功数 >= MaxRequest
 	// 那么会变更为关闭状态
+	// 设置了 SuccessThreshold 时只用于情况 1，且限制的是同时进行的请求数
 	maxRequests uint32
 
+	// 半开状态下变更为关闭状态所需的连续成功次数，未设置时等于 maxRequests
+	successThreshold uint32
+	// 是否只限制半开状态下同时进行的请求数，设置了 SuccessThreshold 时为 true
+	limitInFlight bool
+	// 半开状态下按成功率关闭时的成功率阈值和样本数，halfOpenSuccessRatio 为 0 时不启用
+	halfOpenSuccessRatio float64
+	halfOpenSampleSize   uint32
+	// 半开状态下按概率放行的基础概率，为 0 时按名额放行
+	halfOpenAdmitRatio float64
+	// 半开状态下名额已满时请求的最长等待时间，为 0 时立即拒绝
+	halfOpenWait time.Duration
+	// 等待的请求是否按到达顺序获得半开名额
+	fairHalfOpen bool
+
 	// 关闭状态下定期清空计数的时间，如果为 0，则不清空
 	// 这里我不太明白清空计数的原因，在网上找了一个分析，意思是如果一直处于成功状态，
 	// 那么计数的意义就不是很大，此外如果请求量过大可能会导致溢出，所以需要定期清空
 	interval time.Duration
+	// 周期长度的随机偏移范围，以及生成偏移和半开状态放行概率的随机数，只在持有锁时调用
+	intervalJitter time.Duration
+	random         func() float64
+	// 关闭状态下连续成功多少次后清空计数，为 0 时不清空
+	clearAfterConsecutiveSuccesses uint32
 
 	// 打开状态的持续时间，到时后会变更为半打开状态。
 	timeout time.Duration
 
+	// 根据连续开启的次数计算开启状态持续时间的回调函数
+	backoffExpiry func(attempt int) time.Duration
+
 	// 关闭状态下会调用该回调函数，如果返回 true，则进入打开状态
 	readyToTrip func(counts Counts) bool
+	// 请求成功时是否也调用 readyToTrip
+	evaluateOnSuccess bool
+	// 判断是否遇到需要立即熔断的错误
+	tripImmediately func(err error) bool
 
 	// 用来判断请求是否成功的回调函数
 	isSuccessful func(err error) bool
 
+	// 对请求的错误分类的回调函数，为 nil 时使用 isSuccessful
+	classifyError func(err error) ErrorClass
+
+	// 根据请求的结果和错误判断是否成功，不为 nil 时优先于 classifyError 和 isSuccessful
+	isSuccessfulResult func(result interface{}, err error) bool
+
 	// 发生状态变更时的回调函数
-	onStateChange func(name string, from State, to State)
+	onStateChange           func(name string, from State, to State)
+	onStateChangeWithCounts func(name string, from State, to State, counts Counts)
+
+	// 请求被拒绝时的回调函数
+	onReject func(name string, err error)
+	// 请求被放行时的回调函数
+	onAdmit func(name string, state State)
+	// 状态变更前的回调函数，返回 false 时不变更
+	beforeStateChange func(from State, to State) bool
+	// 自定义被拒绝时返回的错误
+	rejectionError func(state State, counts Counts) error
+
+	// 变更为半开状态时的回调函数
+	onHalfOpen func(name string, probeSlots uint32)
+
+	// TwoStepCircuitBreaker 的回调超时未被调用时自动记为失败的时间，为 0 时不检查
+	twoStepCallbackTimeout time.Duration
+
+	// 请求成功或失败计数后的回调函数
+	onSuccessHook func(name string, counts Counts)
+	onFailureHook func(name string, counts Counts, err error)
+
+	// 请求被拒绝时代替返回错误的函数
+	fallback func(err error) (interface{}, error)
+
+	// 是否将请求中的 panic 转换为错误返回
+	recoverPanic bool
+
+	// panic 的请求是否不计数，由 PanicCountsAsFailure 指向 false 时设置
+	ignorePanics bool
+
+	// 接近熔断但未熔断时的回调函数及其阈值
+	onNearTrip     func(name string, counts Counts, margin float64)
+	nearTripMargin float64
+	tripMargin     func(counts Counts) float64
+
+	// 自定义的准入判断
+	admissionFunc func(state State, counts Counts) (bool, error)
+
+	trackAdmissionLatency bool
+
+	attributeStaleToCurrentGeneration bool
+
+	// 计数的半衰期，大于 0 时使用 decayed 保存衰减后的总数
+	halfLife time.Duration
+
+	ignoreContextErrors       bool
+	ignoreContextCancellation bool
+
+	requestTimeout time.Duration
+
+	metricsObserver MetricsObserver
+	logger          Logger
+	clock           Clock
+
+	// 关闭状态下的滑动窗口，为 nil 时按周期统计
+	window window
+
+	// 关闭状态下最近 MinimumSamples 个请求的结果，只用于 readyToTrip，为 nil 时不启用
+	recent *countWindow
+
+	// 依赖的熔断器，熔断时一起熔断，resetDependents 为 true 时关闭时一起重置。
+	// propagation 是等待后台 goroutine 同步的状态变更，propagating 表示该 goroutine 正在运行
+	dependents      []*CircuitBreaker
+	resetDependents bool
+	propagation     []State
+	propagating     bool
+
+	// 离开关闭状态的最长时间，openSince 是最近一次离开关闭状态的时间
+	maxOpenDuration time.Duration
+	openSince       time.Time
+
+	// 长时间没有关闭时的回调。stuckOpenSince 是离开关闭状态的实际时间，
+	// stuckOpenArm 在每次设置或取消定时器时增加，用于识别过期的定时器
+	stuckOpenAfter time.Duration
+	onStuckOpen    func(name string, duration time.Duration)
+	stuckOpenSince time.Time
+	stuckOpenTimer *time.Timer
+	stuckOpenArm   uint64
+
+	slowCallDuration time.Duration
+
+	// 共享状态存储，syncingShared 为 true 表示正在应用共享状态，此时不写回
+	sharedStore   SharedStateStore
+	syncingShared bool
+
+	retry RetrySettings
+
+	// 同时进行的最大请求数，为 0 时不限制
+	maxConcurrent uint32
+
+	hedgeDelay time.Duration
+
+	// 请求耗时的直方图，没有开启 TrackLatency 时为 nil，内部使用原子操作，读写不需要持有 mutex
+	latency      *latencyHistogram
+	resetLatency bool
+
+	// 订阅通道的缓冲大小，以及缓冲已满时是否丢弃最旧的事件
+	eventBufferSize  int
+	dropOldestEvents bool
+
+	probeFunc     func() error
+	probeInterval time.Duration
 	// ====================
 
 	mutex      sync.Mutex
 	state      State
 	generation uint64
-	counts     Counts
+	// 强制状态，forced 为 true 时状态固定为 forcedState，不再自动变更
+	forced      bool
+	forcedState State
+	// 进入当前状态时的 generation 和时间
+	stateGeneration uint64
+	stateSince      time.Time
+	// counts 必须在 mutex 下更新：ReadyToTrip、回调、窗口和 generation 检查都依赖一致的快照，
+	// 不能像 latency 一样拆成独立的原子计数
+	counts  Counts
+	decayed decayedCounts
+	// 准入耗时统计，仅在 trackAdmissionLatency 为 true 时更新
+	admissionCount uint64
+	admissionTotal time.Duration
+	admissionMax   time.Duration
+	// 正在进行的请求数，以及其中半开状态下放行的请求数
+	inFlight         uint32
+	halfOpenInFlight uint32
+	// 半开状态下的成功和失败次数，按成功率关闭时使用，不受 HalfLife 衰减影响
+	halfOpenSuccesses uint32
+	halfOpenFailures  uint32
+	// 上次关闭后开启的次数，以及当前开启状态的持续时间
+	openAttempts int
+	openTimeout  time.Duration
+	// 状态变更的订阅者
+	subscribers []chan StateChange
+	// 最近一次拒绝请求的时间，不随计数清空
+	lastRejection time.Time
+	// 创建以来变更为开启状态的次数和最近一次的时间，不随计数清空
+	trips         uint64
+	lastTrippedAt time.Time
+	// 探测 goroutine 的停止通道，没有在探测时为 nil
+	probeStop chan struct{}
+	// 已经调用过 Close
+	closed bool
+	// 已经调用过 Drain，drained 在正在进行的请求数变为 0 时关闭，没有 Drain 在等待时为 nil
+	draining bool
+	drained  chan struct{}
+	// 等待半开名额的请求在该通道关闭时被唤醒，没有请求在等待时为 nil
+	halfOpenFreed chan struct{}
+	// fairHalfOpen 为 true 时按到达顺序排队等待半开名额的请求，只有队首的请求可以获得名额
+	halfOpenQueue []*halfOpenWaiter
 	// 这个变量貌似有两种情况：
 	// 1. 开启状态下，代表切换到半开启的绝对时间（time.Time 代表一个绝对时间）
 	//    具体值是 time.Now + timeout
@@ -201,6 +1235,8 @@ type CircuitBreaker struct {
 // TwoStepCircuitBreaker is like CircuitBreaker but instead of surrounding a function
 // with the breaker functionality, it only checks whether a request can proceed and
 // expects the caller to report the outcome in a separate step using a callback.
+// If Settings.TwoStepCallbackTimeout is set, a callback that is not called within it
+// is counted as a failure, and calling it later has no effect.
 type TwoStepCircuitBreaker struct {
 	cb *CircuitBreaker
 }
@@ -208,9 +1244,47 @@ type TwoStepCircuitBreaker struct {
 // NewCircuitBreaker returns a new CircuitBreaker configured with the given Settings.
 func NewCircuitBreaker(st Settings) *CircuitBreaker {
 	cb := new(CircuitBreaker)
+	cb.configure(st)
+
+	switch st.InitialState {
+	case StateClosed, StateHalfOpen, StateOpen:
+		cb.state = st.InitialState
+	default:
+		panic(fmt.Sprintf("gobreaker: invalid initial state: %d", st.InitialState))
+	}
+
+	now := cb.clock.Now()
+	cb.toNewGeneration(now)
+	cb.stateSince = now
+	if cb.state != StateClosed {
+		cb.openSince = now
+		cb.startProbe()
+	}
+	cb.stuckOpenSince = time.Now()
+	cb.armStuckOpen()
+
+	return cb
+}
 
+// configure 按 Settings 设置熔断器的配置，没有设置的使用默认值，不改变状态和计数。
+// NewCircuitBreaker 和 Reconfigure 都使用它，保证两者的默认值一致
+func (cb *CircuitBreaker) configure(st Settings) {
 	cb.name = st.Name
 	cb.onStateChange = st.OnStateChange
+	cb.onStateChangeWithCounts = st.OnStateChangeWithCounts
+	cb.onReject = st.OnReject
+	cb.onAdmit = st.OnAdmit
+	cb.beforeStateChange = st.BeforeStateChange
+	cb.rejectionError = st.RejectionError
+	cb.onHalfOpen = st.OnHalfOpen
+	if st.TwoStepCallbackTimeout > 0 {
+		cb.twoStepCallbackTimeout = st.TwoStepCallbackTimeout
+	}
+	cb.onSuccessHook = st.OnSuccess
+	cb.onFailureHook = st.OnFailure
+	cb.fallback = st.Fallback
+	cb.recoverPanic = st.RecoverPanic
+	cb.ignorePanics = st.PanicCountsAsFailure != nil && !*st.PanicCountsAsFailure
 
 	if st.MaxRequests == 0 {
 		cb.maxRequests = 1
@@ -218,33 +1292,173 @@ func NewCircuitBreaker(st Settings) *CircuitBreaker {
 		cb.maxRequests = st.MaxRequests
 	}
 
+	if st.SuccessThreshold == 0 {
+		cb.successThreshold = cb.maxRequests
+	} else {
+		cb.successThreshold = st.SuccessThreshold
+		cb.limitInFlight = true
+	}
+	if st.HalfOpenSuccessRatio > 0 {
+		cb.halfOpenSuccessRatio = math.Min(st.HalfOpenSuccessRatio, 1)
+		if st.HalfOpenSampleSize == 0 {
+			cb.halfOpenSampleSize = defaultHalfOpenSampleSize
+		} else {
+			cb.halfOpenSampleSize = st.HalfOpenSampleSize
+		}
+		cb.limitInFlight = true
+	}
+	cb.fairHalfOpen = st.FairHalfOpen
+	if st.HalfOpenWait > 0 {
+		cb.halfOpenWait = st.HalfOpenWait
+	}
+	if st.HalfOpenAdmitRatio > 0 {
+		cb.halfOpenAdmitRatio = math.Min(st.HalfOpenAdmitRatio, 1)
+	}
+
 	if st.Interval <= 0 {
 		cb.interval = defaultInterval
 	} else {
 		cb.interval = st.Interval
 	}
+	if st.IntervalJitter > 0 && cb.interval > 0 {
+		cb.intervalJitter = st.IntervalJitter
+		if cb.intervalJitter > cb.interval/2 {
+			cb.intervalJitter = cb.interval / 2
+		}
+	}
+	if st.RandFunc == nil {
+		cb.random = rand.Float64
+	} else {
+		cb.random = st.RandFunc
+	}
+	cb.clearAfterConsecutiveSuccesses = st.ClearAfterConsecutiveSuccesses
+	cb.resetDependents = st.ResetDependents
+	cb.maxOpenDuration = st.MaxOpenDuration
+	cb.stuckOpenAfter = st.StuckOpenAfter
+	cb.onStuckOpen = st.OnStuckOpen
 
 	if st.Timeout <= 0 {
 		cb.timeout = defaultTimeout
 	} else {
 		cb.timeout = st.Timeout
 	}
+	cb.backoffExpiry = st.BackoffExpiry
 
-	if st.ReadyToTrip == nil {
-		cb.readyToTrip = defaultReadyToTrip
-	} else {
+	if st.ReadyToTrip != nil {
 		cb.readyToTrip = st.ReadyToTrip
+	} else if threshold := st.ConsecutiveFailuresThreshold; threshold > 0 {
+		cb.readyToTrip = func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= threshold
+		}
+	} else {
+		cb.readyToTrip = defaultReadyToTrip
 	}
+	cb.evaluateOnSuccess = st.EvaluateOnSuccess
+	cb.tripImmediately = st.TripImmediately
 
 	if st.IsSuccessful == nil {
 		cb.isSuccessful = defaultIsSuccessful
 	} else {
 		cb.isSuccessful = st.IsSuccessful
 	}
+	cb.classifyError = st.ClassifyError
+	cb.isSuccessfulResult = st.IsSuccessfulResult
 
-	cb.toNewGeneration(time.Now())
+	cb.onNearTrip = st.OnNearTrip
 
-	return cb
+	if st.NearTripMargin <= 0 {
+		cb.nearTripMargin = defaultNearTripMargin
+	} else {
+		cb.nearTripMargin = st.NearTripMargin
+	}
+
+	if st.TripMargin != nil || st.ReadyToTrip != nil {
+		cb.tripMargin = st.TripMargin
+	} else if st.ConsecutiveFailuresThreshold > 0 {
+		cb.tripMargin = ConsecutiveFailuresMargin(st.ConsecutiveFailuresThreshold)
+	} else {
+		cb.tripMargin = defaultTripMargin
+	}
+
+	cb.admissionFunc = st.AdmissionFunc
+	cb.trackAdmissionLatency = st.TrackAdmissionLatency
+	cb.attributeStaleToCurrentGeneration = st.AttributeStaleToCurrentGeneration
+
+	if st.HalfLife > 0 {
+		cb.halfLife = st.HalfLife
+	}
+
+	cb.ignoreContextErrors = st.IgnoreContextErrors
+	cb.ignoreContextCancellation = st.IgnoreContextCancellation
+
+	if st.RequestTimeout > 0 {
+		cb.requestTimeout = st.RequestTimeout
+	}
+
+	cb.metricsObserver = st.MetricsObserver
+	if st.Logger == nil {
+		cb.logger = nopLogger{}
+	} else {
+		cb.logger = st.Logger
+	}
+	if st.Clock == nil {
+		cb.clock = systemClock{}
+	} else {
+		cb.clock = st.Clock
+	}
+
+	switch st.WindowType {
+	case WindowTypeCount:
+		size := st.WindowSize
+		if size == 0 {
+			size = defaultWindowSize
+		}
+		cb.window = newCountWindow(size)
+	case WindowTypeTime:
+		length, count := st.RollingWindow, st.BucketCount
+		if length <= 0 {
+			length = defaultRollingWindow
+		}
+		if count <= 0 {
+			count = defaultBucketCount
+		}
+		cb.window = newTimeWindow(length, count)
+	}
+	if st.MinimumSamples > 0 {
+		cb.recent = newCountWindow(st.MinimumSamples)
+	}
+	if st.SlowCallDuration > 0 {
+		cb.slowCallDuration = st.SlowCallDuration
+	}
+
+	cb.sharedStore = st.SharedStateStore
+	cb.retry = st.Retry
+	cb.maxConcurrent = st.MaxConcurrent
+	cb.hedgeDelay = st.HedgeDelay
+	if st.TrackLatency {
+		cb.latency = newLatencyHistogram(st.LatencyBuckets)
+		cb.resetLatency = st.ResetLatency
+	}
+
+	if st.EventBufferSize <= 0 {
+		cb.eventBufferSize = defaultEventBufferSize
+	} else {
+		cb.eventBufferSize = st.EventBufferSize
+	}
+	cb.dropOldestEvents = st.DropOldestEvents
+
+	cb.probeFunc = st.ProbeFunc
+	if st.ProbeInterval <= 0 {
+		cb.probeInterval = defaultProbeInterval
+	} else {
+		cb.probeInterval = st.ProbeInterval
+	}
+
+	if cb.window != nil {
+		// 滑动窗口自行淘汰旧结果，不再需要定期清空和衰减
+		cb.interval = 0
+		cb.halfLife = 0
+	}
 }
 
 // NewTwoStepCircuitBreaker returns a new TwoStepCircuitBreaker configured with the given Settings.
@@ -256,98 +1470,1062 @@ func NewTwoStepCircuitBreaker(st Settings) *TwoStepCircuitBreaker {
 
 const defaultInterval = time.Duration(0) * time.Second
 const defaultTimeout = time.Duration(60) * time.Second
+const defaultNearTripMargin = 0.2
+const defaultWindowSize = 100
+const defaultHalfOpenSampleSize = 10
+const defaultRollingWindow = time.Duration(10) * time.Second
+const defaultBucketCount = 10
 
 func defaultReadyToTrip(counts Counts) bool {
 	return counts.ConsecutiveFailures > 5
 }
 
-func defaultIsSuccessful(err error) bool {
-	return err == nil
+// defaultTripMargin 对应 defaultReadyToTrip，连续失败 6 次时熔断
+var defaultTripMargin = ConsecutiveFailuresMargin(6)
+
+// ConsecutiveFailuresMargin returns a TripMargin for a policy
+// that trips when the number of consecutive failures reaches threshold.
+func ConsecutiveFailuresMargin(threshold uint32) func(counts Counts) float64 {
+	return func(counts Counts) float64 {
+		if threshold == 0 || counts.ConsecutiveFailures >= threshold {
+			return 0
+		}
+		return float64(threshold-counts.ConsecutiveFailures) / float64(threshold)
+	}
+}
+
+// FailureRatioMargin returns a TripMargin for a policy that trips when
+// the number of requests is at least minRequests and the failure ratio is at least ratio.
+// The margin is the larger of the relative shortfalls in requests and in failure ratio.
+func FailureRatioMargin(minRequests uint32, ratio float64) func(counts Counts) float64 {
+	return func(counts Counts) float64 {
+		var margin float64
+		if counts.Requests < minRequests {
+			margin = float64(minRequests-counts.Requests) / float64(minRequests)
+		}
+		if counts.Requests > 0 && ratio > 0 {
+			failureRatio := counts.FailureRatio()
+			if failureRatio < ratio {
+				margin = math.Max(margin, (ratio-failureRatio)/ratio)
+			}
+		}
+		return math.Min(margin, 1)
+	}
+}
+
+func defaultIsSuccessful(err error) bool {
+	return err == nil
+}
+
+// classify 对请求的错误分类，没有设置 classifyError 时使用 isSuccessful，
+// 设置了 ignoreContextCancellation 时先忽略 context 取消和超时的错误
+func (cb *CircuitBreaker) classify(err error) ErrorClass {
+	if cb.ignoresCancellation(err) {
+		return ErrorClassIgnore
+	}
+	if cb.classifyError != nil {
+		return cb.classifyError(err)
+	}
+	if cb.isSuccessful(err) {
+		return ErrorClassSuccess
+	}
+	return ErrorClassFailure
+}
+
+// Classify returns how the CircuitBreaker classifies err as the error of a request,
+// by IgnoreContextCancellation, ClassifyError and IsSuccessful as in TwoStepCircuitBreaker.AllowErr,
+// without affecting its state or Counts. It is meant for testing the classification in Settings.
+// IsSuccessfulResult is not used, since it needs the result of a request.
+func (cb *CircuitBreaker) Classify(err error) ErrorClass {
+	return cb.classify(err)
+}
+
+// WouldSucceed reports whether the CircuitBreaker counts a request that returns err as a success,
+// without affecting its state or Counts. An error that is ignored is not a success.
+func (cb *CircuitBreaker) WouldSucceed(err error) bool {
+	return cb.classify(err) == ErrorClassSuccess
+}
+
+// ignoresCancellation 判断 err 是否因为 IgnoreContextCancellation 而不计数
+func (cb *CircuitBreaker) ignoresCancellation(err error) bool {
+	return cb.ignoreContextCancellation && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// ExponentialBackoff returns a BackoffExpiry that starts at base and doubles on each trip, up to max.
+// If max is less than or equal to 0, the period is not capped.
+// If jitter is greater than 0, each period is reduced by a random fraction of up to jitter (at most 1)
+// so that many CircuitBreakers tripped together don't probe at the same time.
+// The random fractions come from the default source of the math/rand package.
+func ExponentialBackoff(base, max time.Duration, jitter float64) func(attempt int) time.Duration {
+	return ExponentialBackoffRand(base, max, jitter, rand.Float64)
+}
+
+// ExponentialBackoffRand is like ExponentialBackoff, but takes the random fractions of jitter from randFunc,
+// which returns pseudo-random numbers in [0.0, 1.0), e.g. SeededRand to make them reproducible in tests.
+// randFunc must be safe for concurrent use if the BackoffExpiry is shared by several CircuitBreakers.
+func ExponentialBackoffRand(base, max time.Duration, jitter float64, randFunc func() float64) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && (max <= 0 || d < max) && d <= math.MaxInt64/2; i++ {
+			d *= 2
+		}
+		if max > 0 && d > max {
+			d = max
+		}
+		if jitter > 0 {
+			d -= time.Duration(randFunc() * math.Min(jitter, 1) * float64(d))
+		}
+		return d
+	}
+}
+
+// SeededRand returns a function for Settings.RandFunc and ExponentialBackoffRand that returns
+// the pseudo-random numbers in [0.0, 1.0) of a source seeded with seed, so that the same seed
+// always gives the same sequence. Unlike a *rand.Rand, the function is safe for concurrent use.
+func SeededRand(seed int64) func() float64 {
+	var mutex sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func() float64 {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return r.Float64()
+	}
+}
+
+// nextTimeout 返回这次开启状态的持续时间，调用前 openAttempts 已经更新
+func (cb *CircuitBreaker) nextTimeout() time.Duration {
+	if cb.backoffExpiry != nil {
+		if d := cb.backoffExpiry(cb.openAttempts); d > 0 {
+			return d
+		}
+	}
+	return cb.timeout
+}
+
+// Name returns the name of the CircuitBreaker.
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State returns the current state of the CircuitBreaker.
+func (cb *CircuitBreaker) State() State {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	state, _ := cb.currentState(now)
+	return state
+}
+
+// StateSince returns the time when the CircuitBreaker entered its current state.
+// Like State, it first makes a transition that is due, so an open CircuitBreaker past its timeout
+// reports the time it became half-open.
+func (cb *CircuitBreaker) StateSince() time.Time {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.currentState(cb.clock.Now())
+	return cb.stateSince
+}
+
+// StateDuration returns how long the CircuitBreaker has been in its current state.
+func (cb *CircuitBreaker) StateDuration() time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	cb.currentState(now)
+	return now.Sub(cb.stateSince)
+}
+
+// DetailedState returns the current state of the CircuitBreaker and its operational modes.
+func (cb *CircuitBreaker) DetailedState() DetailedState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.detailedState(cb.clock.Now())
+}
+
+func (cb *CircuitBreaker) detailedState(now time.Time) DetailedState {
+	state, _ := cb.currentState(now)
+	return DetailedState{
+		State:    state,
+		Forced:   cb.forced,
+		Draining: cb.draining,
+		Drained:  cb.draining && cb.inFlight == 0,
+	}
+}
+
+// LastRejection returns the time when the CircuitBreaker last rejected a request,
+// or the zero time if it has never rejected one.
+// Unlike Counts.Rejections, which counts the rejections of the current generation,
+// it is not cleared when the state changes.
+func (cb *CircuitBreaker) LastRejection() time.Time {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.lastRejection
+}
+
+// TripCount returns the number of times the CircuitBreaker has changed to the open state since it was created,
+// whether by ReadyToTrip, a failure in the half-open state, Trip, SetForcedState or a parent added by AddDependent.
+// Unlike the Counts, it is never cleared, and Restore doesn't change it.
+func (cb *CircuitBreaker) TripCount() uint64 {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.trips
+}
+
+// LastTrippedAt returns the time when the CircuitBreaker last changed to the open state,
+// or the zero time if it has never opened. A CircuitBreaker created with InitialState StateOpen
+// doesn't count as tripped until it opens again.
+func (cb *CircuitBreaker) LastTrippedAt() time.Time {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.lastTrippedAt
+}
+
+// Stats returns the state, Counts, ratios and expiry of the CircuitBreaker,
+// read at once under its lock so that they are consistent with each other.
+func (cb *CircuitBreaker) Stats() Stats {
+	status := cb.status()
+	return Stats{
+		State:        status.State,
+		Counts:       status.Counts,
+		FailureRatio: status.Counts.FailureRatio(),
+		SuccessRatio: status.Counts.SuccessRatio(),
+		Expiry:       status.Expiry,
+	}
+}
+
+// Generation returns the current generation of the CircuitBreaker.
+// The generation is incremented whenever the state changes and whenever the Counts are cleared
+// at the end of Interval, and the outcome of a request is counted only if it finishes
+// in the generation it started in.
+func (cb *CircuitBreaker) Generation() uint64 {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	_, generation := cb.currentState(cb.clock.Now())
+	return generation
+}
+
+// Counts returns internal counters
+func (cb *CircuitBreaker) Counts() Counts {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// 时间窗口中的结果会随时间过期，返回前先同步
+	if cb.window != nil && cb.state == StateClosed {
+		cb.syncWindow(cb.clock.Now())
+	}
+	return cb.counts
+}
+
+// ExpiresAt returns the time of the next scheduled transition of the CircuitBreaker.
+// In the open state, it is the time when the CircuitBreaker becomes half-open.
+// In the closed state, it is the time when the Counts are cleared at the end of Interval.
+// It returns the zero time if no transition is scheduled, e.g. in the half-open state
+// or in the closed state with Interval 0.
+// ExpiresAt does not change the state even if the returned time has passed.
+func (cb *CircuitBreaker) ExpiresAt() time.Time {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// 只读取 expiry，不调用 currentState，避免触发状态切换
+	return cb.expiry
+}
+
+// AdmissionLatencyStats returns the average and maximum time spent admitting requests.
+// It returns zeros unless TrackAdmissionLatency is set.
+func (cb *CircuitBreaker) AdmissionLatencyStats() (avg, max time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.admissionCount == 0 {
+		return 0, 0
+	}
+	return cb.admissionTotal / time.Duration(cb.admissionCount), cb.admissionMax
+}
+
+// Trip places the CircuitBreaker into the open state immediately, calling OnStateChange.
+// As with an automatic trip, the CircuitBreaker becomes half-open after Timeout.
+// Trip does nothing if the CircuitBreaker is already open.
+func (cb *CircuitBreaker) Trip() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.setState(StateOpen, cb.clock.Now())
+}
+
+// Reset places the CircuitBreaker into the closed state immediately, calling OnStateChange,
+// and clears the internal Counts.
+func (cb *CircuitBreaker) Reset() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	if cb.state == StateClosed {
+		cb.toNewGeneration(now) // 已经是关闭状态时只清空计数
+		cb.resetRecent()
+		return
+	}
+	cb.setState(StateClosed, now)
+}
+
+// ClearConsecutive sets ConsecutiveSuccesses and ConsecutiveFailures to 0, for example after fixing
+// the cause of a series of failures by hand. Unlike Reset, it doesn't change the state, the generation
+// or the other Counts, so the outcomes of requests in flight are still counted.
+// In the half-open state, the consecutive successes needed to close the CircuitBreaker start over.
+func (cb *CircuitBreaker) ClearConsecutive() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.counts.resetConsecutive()
+}
+
+// Close releases the background resources of the CircuitBreaker:
+// it stops the goroutine that runs Settings.ProbeFunc and the timer of Settings.OnStuckOpen,
+// and closes the channels returned by Subscribe.
+// After Close, every request is refused with ErrBreakerClosed without being counted,
+// and Subscribe returns a closed channel.
+// Close is safe to call concurrently and more than once; it always returns nil.
+func (cb *CircuitBreaker) Close() error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.closed {
+		return nil
+	}
+	cb.closed = true
+	cb.stopProbe()
+	cb.disarmStuckOpen()
+	cb.wakeHalfOpenWaiters()
+	for _, ch := range cb.subscribers {
+		close(ch)
+	}
+	cb.subscribers = nil
+	return nil
+}
+
+// Drain stops the CircuitBreaker from admitting new requests, which are refused with ErrDraining
+// without being counted, and waits until the requests in flight have finished.
+// It returns nil once no request is in flight, or ctx.Err() if ctx is done first.
+// The CircuitBreaker keeps refusing new requests after Drain returns, whatever the result,
+// so Drain is meant for a graceful shutdown. Drain is safe to call concurrently and more than once.
+func (cb *CircuitBreaker) Drain(ctx context.Context) error {
+	cb.mutex.Lock()
+	if !cb.draining {
+		cb.draining = true
+		cb.wakeHalfOpenWaiters()
+	}
+	if cb.inFlight == 0 {
+		cb.mutex.Unlock()
+		return nil
+	}
+	if cb.drained == nil {
+		cb.drained = make(chan struct{})
+	}
+	drained := cb.drained
+	cb.mutex.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetForcedState forces the CircuitBreaker into state until ClearForcedState is called,
+// calling OnStateChange if the state changes.
+// A CircuitBreaker forced open rejects every request with ErrOpenState and never becomes half-open.
+// A CircuitBreaker forced closed admits every request and never trips,
+// although Counts are still updated and cleared at the closed-state intervals.
+// Unlike Trip, the forced state persists across timeouts, and Trip and Reset cannot change it.
+// State must be StateOpen or StateClosed; otherwise ErrInvalidForcedState is returned.
+func (cb *CircuitBreaker) SetForcedState(state State) error {
+	if state != StateOpen && state != StateClosed {
+		return ErrInvalidForcedState
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// 先解除之前的强制状态，才能切换过去，强制状态不经过 beforeStateChange
+	cb.forced = false
+	cb.changeState(state, cb.clock.Now())
+	cb.forced = true
+	cb.forcedState = state
+	return nil
+}
+
+// ClearForcedState returns the CircuitBreaker to normal operation, starting from the forced state.
+// A CircuitBreaker that was forced open becomes half-open after the current open timeout from the call;
+// clearing does not count as another open attempt for BackoffExpiry.
+func (cb *CircuitBreaker) ClearForcedState() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if !cb.forced {
+		return
+	}
+	cb.forced = false
+	if cb.state == StateOpen {
+		// 从现在开始重新计算 timeout，解除强制状态不算作一次新的开启，
+		// 沿用 openAttempts 和 openTimeout，避免 BackoffExpiry 延长开启时间
+		cb.clearGeneration()
+		cb.expiry = cb.clock.Now().Add(cb.openTimeout)
+	}
+}
+
+// Pressure returns a value between 0 and 1 that tells upstream producers how stressed the CircuitBreaker is,
+// so that they can throttle proportionally before it trips.
+// In the open state the pressure is 1.
+// In the half-open state it is the fraction of the SuccessThreshold consecutive successes still needed to close,
+// or with HalfOpenSuccessRatio, the fraction of the rest of the sample that still has to succeed to close.
+// In the closed state it is 1 minus the margin returned by TripMargin,
+// which is exact for the default ReadyToTrip and the policies of ConsecutiveFailuresMargin and FailureRatioMargin.
+// If a custom ReadyToTrip is set without TripMargin, the failure ratio of Counts is used as an approximation.
+func (cb *CircuitBreaker) Pressure() float64 {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	state, _ := cb.currentState(cb.clock.Now())
+	switch state {
+	case StateOpen:
+		return 1
+	case StateHalfOpen:
+		if cb.halfOpenSuccessRatio > 0 {
+			return cb.halfOpenRatioPressure()
+		}
+		if cb.counts.ConsecutiveSuccesses >= cb.successThreshold {
+			return 0
+		}
+		return float64(cb.successThreshold-cb.counts.ConsecutiveSuccesses) / float64(cb.successThreshold)
+	}
+
+	if cb.tripMargin != nil {
+		return 1 - math.Max(0, math.Min(cb.tripMargin(cb.counts), 1))
+	}
+	if cb.counts.Requests == 0 {
+		return 0
+	}
+	return float64(cb.counts.TotalFailures) / float64(cb.counts.Requests)
+}
+
+// FailureRate returns the ratio of failures to completed requests, between 0 and 1,
+// in the current generation. It returns 0 if no request has completed.
+//
+// If HalfLife is set, every result is weighted by 0.5^(age/HalfLife), where age is the time since
+// the result was recorded, so a result counts half after one HalfLife, a quarter after two, and so on.
+// The rate is then decayed failures / (decayed successes + decayed failures), computed without rounding
+// at the time of the call. Since the weights of successes and failures fade alike,
+// the rate changes only when new results arrive, but a burst of old results
+// is outweighed by recent ones in proportion to their age.
+// Without HalfLife, the rate is TotalFailures / (TotalSuccesses + TotalFailures) of Counts.
+func (cb *CircuitBreaker) FailureRate() float64 {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.failureRate(cb.clock.Now())
+}
+
+func (cb *CircuitBreaker) failureRate(now time.Time) float64 {
+	if cb.halfLife > 0 {
+		// 复制一份再衰减，读取时不修改内部状态
+		decayed := cb.decayed
+		decayed.decay(now, cb.halfLife)
+		if total := decayed.successes + decayed.failures; total > 0 {
+			return decayed.failures / total
+		}
+		return 0
+	}
+
+	if cb.window != nil && cb.state == StateClosed {
+		cb.syncWindow(now)
+	}
+	if total := cb.counts.TotalSuccesses + cb.counts.TotalFailures; total > 0 {
+		return float64(cb.counts.TotalFailures) / float64(total)
+	}
+	return 0
+}
+
+// CountsAndResetConsecutive returns internal counters and resets
+// the consecutive successes and failures under a single lock.
+// It doesn't change the state or the generation of the CircuitBreaker.
+func (cb *CircuitBreaker) CountsAndResetConsecutive() Counts {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	counts := cb.counts
+	cb.counts.resetConsecutive()
+	return counts
+}
+
+// Execute runs the given request if the CircuitBreaker accepts it.
+// Execute returns an error instantly if the CircuitBreaker rejects the request.
+// Otherwise, Execute returns the result of the request.
+// If a panic occurs in the request, the CircuitBreaker handles it as an error
+// and causes the same panic again, or returns it as an error if Settings.RecoverPanic is true.
+// If Settings.Fallback is set, Execute returns the result of the fallback instead of
+// ErrOpenState or ErrTooManyRequests.
+// If Settings.Retry is set, a failed request is retried as described in RetrySettings.
+// If req is nil, Execute returns ErrNilRequest without consulting the CircuitBreaker.
+func (cb *CircuitBreaker) Execute(req func() (interface{}, error)) (interface{}, error) {
+	return executeRetry(cb, defaultCall(cb, cb.fallback), req)
+}
+
+// CallOptions overrides the Settings of a CircuitBreaker for a single call of ExecuteWith.
+//
+// IsSuccessful, if not nil, classifies the error of the request instead of
+// the IsSuccessfulResult, IsSuccessful and ClassifyError of the CircuitBreaker.
+// IgnoreContextCancellation still applies.
+//
+// Timeout, if greater than 0, is used instead of RequestTimeout.
+// If Timeout is less than 0, the request has no timeout.
+//
+// Fallback, if not nil, is used instead of the Fallback of the CircuitBreaker.
+type CallOptions struct {
+	IsSuccessful func(err error) bool
+	Timeout      time.Duration
+	Fallback     func(err error) (interface{}, error)
+}
+
+// ExecuteWith is like Execute, but the fields set in opts override the Settings
+// of the CircuitBreaker for this call only. ExecuteWith with zero CallOptions is the same as Execute.
+func (cb *CircuitBreaker) ExecuteWith(opts CallOptions, req func() (interface{}, error)) (interface{}, error) {
+	c := defaultCall(cb, cb.fallback)
+	if opts.IsSuccessful != nil {
+		c.classify = func(_ interface{}, err error) ErrorClass {
+			if cb.ignoresCancellation(err) {
+				return ErrorClassIgnore
+			}
+			if opts.IsSuccessful(err) {
+				return ErrorClassSuccess
+			}
+			return ErrorClassFailure
+		}
+	}
+	if opts.Timeout > 0 {
+		c.timeout = opts.Timeout
+	} else if opts.Timeout < 0 {
+		c.timeout = 0
+	}
+	if opts.Fallback != nil {
+		c.fallback = opts.Fallback
+	}
+	return executeRetry(cb, c, req)
+}
+
+// ExecuteContext is like Execute but passes ctx to the request.
+// If ctx is already done, ExecuteContext returns ctx.Err() without consulting the CircuitBreaker,
+// so it doesn't take a half-open slot.
+// The request is expected to return promptly when ctx is done;
+// how the CircuitBreaker counts such a request is controlled by Settings.IgnoreContextErrors.
+func (cb *CircuitBreaker) ExecuteContext(ctx context.Context, req func(context.Context) (interface{}, error)) (interface{}, error) {
+	return executeContextRetry(cb, ctx, defaultCall(cb, cb.fallback), req)
+}
+
+// Do is like Execute for a request that returns only an error, such as a write or a side-effecting call.
+// It returns the error of req or the rejection error of the CircuitBreaker.
+// If Settings.Fallback is set, a rejected call returns the error of the fallback and its result is discarded.
+// Settings.IsSuccessfulResult, if set, is called with a nil result.
+// If req is nil, Do returns ErrNilRequest without consulting the CircuitBreaker.
+func (cb *CircuitBreaker) Do(req func() error) error {
+	if req == nil {
+		return ErrNilRequest
+	}
+	_, err := cb.Execute(func() (interface{}, error) {
+		return nil, req()
+	})
+	return err
+}
+
+// call 是一次调用使用的设置，默认取自熔断器的设置，ExecuteWith 可以逐次覆盖
+type call[T any] struct {
+	classify func(result T, err error) ErrorClass
+	timeout  time.Duration
+	fallback func(err error) (T, error)
+}
+
+// defaultCall 返回使用熔断器设置的 call
+func defaultCall[T any](cb *CircuitBreaker, fallback func(err error) (T, error)) call[T] {
+	classify := func(_ T, err error) ErrorClass { return cb.classify(err) }
+	if cb.isSuccessfulResult != nil {
+		classify = func(result T, err error) ErrorClass {
+			if cb.ignoresCancellation(err) {
+				return ErrorClassIgnore
+			}
+			if cb.isSuccessfulResult(result, err) {
+				return ErrorClassSuccess
+			}
+			return ErrorClassFailure
+		}
+	}
+	return call[T]{classify: classify, timeout: cb.requestTimeout, fallback: fallback}
+}
+
+// rejectedWithFallback 在请求因开启状态或半开状态请求过多被拒绝、且设置了 fallback 时调用 fallback，
+// 否则返回零值和原来的错误
+func rejectedWithFallback[T any](err error, fallback func(err error) (T, error)) (T, error) {
+	if fallback != nil && (errors.Is(err, ErrOpenState) || errors.Is(err, ErrTooManyRequests)) {
+		return fallback(err)
+	}
+	var zero T
+	return zero, err
+}
+
+// execute 是 Execute 的实际逻辑，泛型版本的 TypedCircuitBreaker 也使用它，避免装箱
+func execute[T any](cb *CircuitBreaker, c call[T], req func() (T, error)) (result T, err error) {
+	// 执行请求前
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		return rejectedWithFallback(err, c.fallback)
+	}
+
+	if cb.hedgeDelay > 0 {
+		req = hedged(cb, c.classify, req)
+	}
+	if c.timeout > 0 {
+		return callWithTimeout(cb, generation, c.timeout, req, func(result T, err error, elapsed time.Duration) {
+			cb.afterClassified(generation, c.classify(result, err), err, elapsed)
+		})
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = cb.onPanic(generation, e, debug.Stack())
+		}
+	}()
+
+	start := time.Now()
+	result, err = req()
+	// 执行请求后
+	cb.afterClassified(generation, c.classify(result, err), err, time.Since(start))
+	return result, err
+}
+
+// executeContext 是 ExecuteContext 的实际逻辑
+func executeContext[T any](cb *CircuitBreaker, ctx context.Context, c call[T], req func(context.Context) (T, error)) (result T, err error) {
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		return rejectedWithFallback(err, c.fallback)
+	}
+
+	if cb.hedgeDelay > 0 {
+		req = hedgedContext(cb, c.classify, req)
+	}
+
+	// 只有调用方的 ctx 结束时才会忽略，RequestTimeout 导致的超时仍记为失败
+	record := func(result T, err error, elapsed time.Duration) {
+		if err != nil && ctx.Err() != nil && cb.ignoreContextErrors {
+			cb.ignoreRequest(generation)
+		} else if cb.ignoreContextCancellation && c.timeout > 0 && ctx.Err() == nil &&
+			errors.Is(err, context.DeadlineExceeded) {
+			// 调用方的 ctx 没有结束，超时来自 RequestTimeout，是下游太慢，仍记为失败
+			cb.observeLatency(elapsed)
+			cb.afterTimedRequest(generation, false, elapsed, err)
+		} else {
+			cb.afterClassified(generation, c.classify(result, err), err, elapsed)
+		}
+	}
+
+	if c.timeout > 0 {
+		tctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		return callWithTimeout(cb, generation, c.timeout, func() (T, error) { return req(tctx) }, record)
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = cb.onPanic(generation, e, debug.Stack())
+		}
+	}()
+
+	start := time.Now()
+	result, err = req(ctx)
+	record(result, err, time.Since(start))
+	return result, err
+}
+
+// callResult 是在单独 goroutine 中执行的请求的结果
+type callResult[T any] struct {
+	result   T
+	err      error
+	panicked bool
+	panicVal interface{}
+	stack    []byte
+}
+
+// callWithTimeout 在单独的 goroutine 中执行请求，超时后记为失败并返回 ErrRequestTimeout，
+// 超时后返回的结果会被丢弃，因此不会重复计数
+func callWithTimeout[T any](cb *CircuitBreaker, generation uint64, timeout time.Duration, req func() (T, error), record func(result T, err error, elapsed time.Duration)) (T, error) {
+	start := time.Now()
+	ch := make(chan callResult[T], 1) // 带缓冲，超时后请求返回时不会阻塞
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				ch <- callResult[T]{panicked: true, panicVal: e, stack: debug.Stack()}
+			}
+		}()
+		result, err := req()
+		ch <- callResult[T]{result: result, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		if r.panicked {
+			return r.result, cb.onPanic(generation, r.panicVal, r.stack)
+		}
+		record(r.result, r.err, time.Since(start))
+		return r.result, r.err
+	case <-timer.C:
+		cb.observeLatency(timeout)
+		cb.afterTimedRequest(generation, false, timeout, ErrRequestTimeout)
+		var zero T
+		return zero, ErrRequestTimeout
+	}
+}
+
+// onPanic 将 panic 的请求记为失败（ignorePanics 为 true 时不计数），
+// RecoverPanic 为 true 时返回包含 panic 值和调用栈的错误，否则再次 panic
+func (cb *CircuitBreaker) onPanic(generation uint64, e interface{}, stack []byte) error {
+	cb.countPanic(generation)
+	if !cb.recoverPanic {
+		panic(e)
+	}
+	return fmt.Errorf("panic: %v\n%s", e, stack)
+}
+
+// countPanic 将 panic 的请求记为失败，ignorePanics 为 true 时忽略该请求
+func (cb *CircuitBreaker) countPanic(generation uint64) {
+	if cb.ignorePanics {
+		cb.ignoreRequest(generation)
+		return
+	}
+	cb.afterRequest(generation, false)
+}
+
+// ExecutePartial runs the given batch request if the CircuitBreaker accepts it,
+// and returns an error instantly if the CircuitBreaker rejects the request.
+// Otherwise, it returns the error of the request.
+// The request reports how many items it processed and how many of them succeeded.
+// The request itself is counted as a success or a failure by IsSuccessful or ClassifyError, as in Execute,
+// and its items are added to TotalItems and SucceededItems of Counts.
+// In the closed state, ReadyToTrip is also called when a request succeeds with failed items,
+// so that a policy based on Counts.ItemSuccessRatio can trip the CircuitBreaker
+// even though every request succeeds.
+// A panic in the request is handled as in Execute.
+func (cb *CircuitBreaker) ExecutePartial(req func() (total, succeeded int, err error)) (err error) {
+	if req == nil {
+		return ErrNilRequest
+	}
+
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = cb.onPanic(generation, e, debug.Stack())
+		}
+	}()
+
+	total, succeeded, err := req()
+	if total < 0 {
+		total = 0
+	}
+	if succeeded < 0 {
+		succeeded = 0
+	} else if succeeded > total {
+		succeeded = total
+	}
+	switch cb.classify(err) {
+	case ErrorClassIgnore:
+		cb.ignoreRequest(generation)
+	case ErrorClassSuccess:
+		cb.afterPartialRequest(generation, true, uint32(total), uint32(succeeded), err)
+	default:
+		cb.afterPartialRequest(generation, false, uint32(total), uint32(succeeded), err)
+	}
+	return err
+}
+
+// Name returns the name of the TwoStepCircuitBreaker.
+func (tscb *TwoStepCircuitBreaker) Name() string {
+	return tscb.cb.Name()
+}
+
+// State returns the current state of the TwoStepCircuitBreaker.
+func (tscb *TwoStepCircuitBreaker) State() State {
+	return tscb.cb.State()
+}
+
+// Counts returns internal counters
+func (tscb *TwoStepCircuitBreaker) Counts() Counts {
+	return tscb.cb.Counts()
+}
+
+// Generation returns the current generation of the TwoStepCircuitBreaker.
+func (tscb *TwoStepCircuitBreaker) Generation() uint64 {
+	return tscb.cb.Generation()
+}
+
+// Trip places the TwoStepCircuitBreaker into the open state immediately.
+func (tscb *TwoStepCircuitBreaker) Trip() {
+	tscb.cb.Trip()
+}
+
+// Reset places the TwoStepCircuitBreaker into the closed state immediately and clears the internal Counts.
+func (tscb *TwoStepCircuitBreaker) Reset() {
+	tscb.cb.Reset()
+}
+
+// Close releases the background resources of the TwoStepCircuitBreaker, as CircuitBreaker.Close does.
+func (tscb *TwoStepCircuitBreaker) Close() error {
+	return tscb.cb.Close()
+}
+
+// Allow checks if a new request can proceed. It returns a callback that should be used to
+// register the success or failure in a separate step. If the circuit breaker doesn't allow
+// requests, it returns an error.
+func (tscb *TwoStepCircuitBreaker) Allow() (done func(success bool), err error) {
+	generation, err := tscb.cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	claim := tscb.cb.watchCallback(generation)
+	return func(success bool) {
+		if claim() {
+			tscb.cb.afterRequest(generation, success)
+		}
+	}, nil
+}
+
+// Drain stops admitting new requests and waits for the requests in flight, as CircuitBreaker.Drain does.
+// A request is in flight from Allow until its callback is called.
+func (tscb *TwoStepCircuitBreaker) Drain(ctx context.Context) error {
+	return tscb.cb.Drain(ctx)
+}
+
+// AllowContext is like Allow, but if ctx is already done, it returns ctx.Err()
+// without consulting the CircuitBreaker, so it doesn't take a half-open slot,
+// as ExecuteContext does.
+func (tscb *TwoStepCircuitBreaker) AllowContext(ctx context.Context) (done func(success bool), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return tscb.Allow()
+}
+
+// AllowErr is like Allow, but the returned callback takes the error of the request
+// and classifies it with IsSuccessful or ClassifyError, as Execute does.
+// The time from AllowErr to the callback is taken as the latency of the request
+// for SlowCallDuration and TrackLatency.
+func (tscb *TwoStepCircuitBreaker) AllowErr() (done func(err error), err error) {
+	generation, err := tscb.cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	claim := tscb.cb.watchCallback(generation)
+	return func(err error) {
+		if claim() {
+			tscb.cb.afterResult(generation, err, time.Since(start))
+		}
+	}, nil
+}
+
+// Execute runs req if the TwoStepCircuitBreaker accepts it and records its outcome,
+// as Allow followed by its callback does, so that callers don't have to report the outcome themselves.
+// The result and error of req are classified as in CircuitBreaker.Execute,
+// and the time req takes is taken as its latency.
+// Settings.Fallback and Settings.RecoverPanic apply as in CircuitBreaker.Execute,
+// but RequestTimeout, HedgeDelay and Retry don't. Allow remains available for requests
+// that can't be wrapped in a function, such as a response body read after returning.
+// If req is nil, Execute returns ErrNilRequest without consulting the CircuitBreaker.
+func (tscb *TwoStepCircuitBreaker) Execute(req func() (interface{}, error)) (interface{}, error) {
+	if req == nil {
+		return nil, ErrNilRequest
+	}
+
+	cb := tscb.cb
+	c := defaultCall(cb, cb.fallback)
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		return rejectedWithFallback(err, c.fallback)
+	}
+
+	start := time.Now()
+	result, panicVal, stack, err := callRecovered(req)
+	if stack != nil {
+		return nil, cb.onPanic(generation, panicVal, stack)
+	}
+	cb.afterClassified(generation, c.classify(result, err), err, time.Since(start))
+	return result, err
 }
 
-// Name returns the name of the CircuitBreaker.
-func (cb *CircuitBreaker) Name() string {
-	return cb.name
+// watchCallback 在设置了 twoStepCallbackTimeout 时启动定时器，回调超时未被调用时将请求记为失败。
+// 回调被调用时先调用返回的 claim，claim 返回 false 表示请求已经因超时记为失败，不应再记录
+func (cb *CircuitBreaker) watchCallback(generation uint64) (claim func() bool) {
+	timeout := cb.twoStepCallbackTimeout
+	if timeout <= 0 {
+		return func() bool { return true }
+	}
+
+	var claimed int32
+	timer := time.AfterFunc(timeout, func() {
+		if atomic.CompareAndSwapInt32(&claimed, 0, 1) {
+			cb.warnMissedCallback(timeout)
+			cb.afterTimedRequest(generation, false, timeout, ErrRequestTimeout)
+		}
+	})
+	return func() bool {
+		if !atomic.CompareAndSwapInt32(&claimed, 0, 1) {
+			return false
+		}
+		timer.Stop()
+		return true
+	}
 }
 
-// State returns the current state of the CircuitBreaker.
-func (cb *CircuitBreaker) State() State {
+// warnMissedCallback 记录 TwoStepCircuitBreaker 的回调超时未被调用
+func (cb *CircuitBreaker) warnMissedCallback(timeout time.Duration) {
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
-	now := time.Now()
-	state, _ := cb.currentState(now)
-	return state
+	cb.logger.Warnf("circuit breaker callback not called: name=%q timeout=%s", cb.name, timeout)
 }
 
-// Counts returns internal counters
-func (cb *CircuitBreaker) Counts() Counts {
+func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
+	var start time.Time
+	if cb.trackAdmissionLatency {
+		start = time.Now()
+	}
+
+	// 在加锁前读取共享状态，避免持有锁时等待存储
+	shared, sharedOK := cb.sharedState()
+
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
-	return cb.counts
-}
+	if cb.trackAdmissionLatency {
+		// defer 按后进先出执行，所以统计会在解锁前完成
+		defer cb.recordAdmissionLatency(start)
+	}
 
-// Execute runs the given request if the CircuitBreaker accepts it.
-// Execute returns an error instantly if the CircuitBreaker rejects the request.
-// Otherwise, Execute returns the result of the request.
-// If a panic occurs in the request, the CircuitBreaker handles it as an error
-// and causes the same panic again.
-func (cb *CircuitBreaker) Execute(req func() (interface{}, error)) (interface{}, error) {
-	// 执行请求前
-	generation, err := cb.beforeRequest()
-	if err != nil {
-		return nil, err
+	now := cb.clock.Now()
+	if sharedOK {
+		cb.applySharedState(shared, now)
+	}
+	var waiter *halfOpenWaiter
+	if cb.halfOpenWait > 0 {
+		var waited bool
+		if waited, waiter = cb.waitHalfOpenSlot(); waited {
+			now = cb.clock.Now()
+		}
+		// 排队的请求放行或被拒绝后离开队列
+		defer cb.dequeueHalfOpen(waiter)
 	}
+	return cb.beforeWaiterAt(now, waiter)
+}
 
-	defer func() {
-		e := recover()
-		if e != nil {
-			cb.afterRequest(generation, false)
-			panic(e)
+// waitHalfOpenSlot 在半开状态下名额已满时等待名额释放或状态变化，最多等待 halfOpenWait，
+// 返回是否等待过，以及设置了 fairHalfOpen 时请求在队列中的位置，调用方需要在请求放行或被拒绝后将其移出队列。
+// 调用方需要持有锁，等待期间会释放锁，返回时重新持有锁
+// 等待的时间按实际时间计算，不受 Clock 影响
+func (cb *CircuitBreaker) waitHalfOpenSlot() (waited bool, waiter *halfOpenWaiter) {
+	var timer *time.Timer
+	for {
+		state, _ := cb.currentState(cb.clock.Now())
+		if cb.closed || cb.draining || state != StateHalfOpen || (!cb.halfOpenFull() && cb.halfOpenTurn(waiter)) {
+			return timer != nil, waiter
+		}
+		if cb.fairHalfOpen && waiter == nil {
+			waiter = new(halfOpenWaiter)
+			cb.halfOpenQueue = append(cb.halfOpenQueue, waiter)
 		}
-	}()
 
-	result, err := req()
-	// 执行请求后
-	cb.afterRequest(generation, cb.isSuccessful(err))
-	return result, err
-}
+		if cb.halfOpenFreed == nil {
+			cb.halfOpenFreed = make(chan struct{})
+		}
+		freed := cb.halfOpenFreed
+		if timer == nil {
+			timer = time.NewTimer(cb.halfOpenWait)
+			defer timer.Stop()
+		}
 
-// Name returns the name of the TwoStepCircuitBreaker.
-func (tscb *TwoStepCircuitBreaker) Name() string {
-	return tscb.cb.Name()
+		cb.mutex.Unlock()
+		select {
+		case <-freed:
+			cb.mutex.Lock()
+		case <-timer.C:
+			cb.mutex.Lock()
+			return true, waiter
+		}
+	}
 }
 
-// State returns the current state of the TwoStepCircuitBreaker.
-func (tscb *TwoStepCircuitBreaker) State() State {
-	return tscb.cb.State()
+// halfOpenWaiter 是排队等待半开名额的请求，用指针区分。
+// 指向大小为 0 的值的指针可能相等，所以需要一个字段
+type halfOpenWaiter struct{ _ byte }
+
+// halfOpenTurn 判断 waiter 是否可以获得空闲的半开名额：没有请求在排队，或者 waiter 在队首
+func (cb *CircuitBreaker) halfOpenTurn(waiter *halfOpenWaiter) bool {
+	return len(cb.halfOpenQueue) == 0 || cb.halfOpenQueue[0] == waiter
 }
 
-// Counts returns internal counters
-func (tscb *TwoStepCircuitBreaker) Counts() Counts {
-	return tscb.cb.Counts()
+// dequeueHalfOpen 将 waiter 移出队列，并唤醒其余的请求检查新的队首
+func (cb *CircuitBreaker) dequeueHalfOpen(waiter *halfOpenWaiter) {
+	if waiter == nil {
+		return
+	}
+	for i, w := range cb.halfOpenQueue {
+		if w == waiter {
+			cb.halfOpenQueue = append(cb.halfOpenQueue[:i], cb.halfOpenQueue[i+1:]...)
+			break
+		}
+	}
+	cb.wakeHalfOpenWaiters()
 }
 
-// Allow checks if a new request can proceed. It returns a callback that should be used to
-// register the success or failure in a separate step. If the circuit breaker doesn't allow
-// requests, it returns an error.
-func (tscb *TwoStepCircuitBreaker) Allow() (done func(success bool), err error) {
-	generation, err := tscb.cb.beforeRequest()
-	if err != nil {
-		return nil, err
+// wakeHalfOpenWaiters 唤醒所有等待半开名额的请求，调用方需要持有锁
+func (cb *CircuitBreaker) wakeHalfOpenWaiters() {
+	if cb.halfOpenFreed != nil {
+		close(cb.halfOpenFreed)
+		cb.halfOpenFreed = nil
 	}
+}
 
-	return func(success bool) {
-		tscb.cb.afterRequest(generation, success)
-	}, nil
+// beforeRequestAt 是 beforeRequest 的实际逻辑，调用方需要持有锁
+func (cb *CircuitBreaker) beforeRequestAt(now time.Time) (uint64, error) {
+	return cb.beforeWaiterAt(now, nil)
 }
 
-func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// beforeWaiterAt 与 beforeRequestAt 相同，waiter 是请求在半开队列中的位置，没有排队时为 nil
+func (cb *CircuitBreaker) beforeWaiterAt(now time.Time, waiter *halfOpenWaiter) (uint64, error) {
+	// 已经关闭的熔断器拒绝所有请求，但不作为被拒绝的请求计数
+	if cb.closed {
+		return cb.generation, fmt.Errorf("circuit breaker %q: %w", cb.name, ErrBreakerClosed)
+	}
+	if cb.draining {
+		return cb.generation, fmt.Errorf("circuit breaker %q: %w", cb.name, ErrDraining)
+	}
 
-	now := time.Now()
 	state, generation := cb.currentState(now)
 
 	// 如果熔断器处于开启状态，直接返回错误，因为该方法在 Execute 中先于用户请求执行，
@@ -356,55 +2534,371 @@ func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
 	//	if err != nil {
 	//		return nil, err
 	//	}
+	// 返回的错误会带上熔断器的名称，可以用 errors.Is 判断
 	if state == StateOpen {
-		return generation, ErrOpenState
+		return generation, cb.reject(now, state, fmt.Errorf("circuit breaker %q is open: %w", cb.name, ErrOpenState))
 		// 请求前如果处于半开状态，会进行限流操作
-	} else if state == StateHalfOpen && cb.counts.Requests >= cb.maxRequests {
-		return generation, ErrTooManyRequests
+	} else if state == StateHalfOpen && (cb.halfOpenFull() || !cb.halfOpenTurn(waiter) || !cb.admitHalfOpen()) {
+		// 有请求在排队时不能插队
+		return generation, cb.reject(now, state, fmt.Errorf("circuit breaker %q: %w", cb.name, ErrTooManyRequests))
+	} else if cb.maxConcurrent > 0 && cb.inFlight >= cb.maxConcurrent {
+		return generation, cb.reject(now, state, fmt.Errorf("circuit breaker %q: %w", cb.name, ErrTooManyConcurrent))
 	}
 
-	cb.counts.onRequest() // 更新计数
+	// 内置检查通过后再交给自定义的准入判断
+	if cb.admissionFunc != nil {
+		admit, err := cb.admissionFunc(state, cb.counts)
+		if err != nil {
+			return generation, err
+		} else if !admit {
+			return generation, ErrNotAdmitted
+		}
+	}
+
+	cb.countRequest(now) // 更新计数
+	cb.inFlight++
+	if state == StateHalfOpen {
+		cb.halfOpenInFlight++
+	}
+	if cb.onAdmit != nil {
+		cb.onAdmit(cb.name, state)
+	}
 	return generation, nil
 }
 
+// halfOpenFull 判断半开状态下是否还能放行请求：设置了 SuccessThreshold 时限制同时进行的请求数，
+// 否则与原来一样限制请求总数
+func (cb *CircuitBreaker) halfOpenFull() bool {
+	if cb.halfOpenAdmitRatio > 0 {
+		return false // 按概率放行，不限制名额
+	}
+	if cb.limitInFlight {
+		return cb.halfOpenInFlight >= cb.maxRequests
+	}
+	return cb.counts.Requests >= cb.maxRequests
+}
+
+// admitHalfOpen 在设置了 halfOpenAdmitRatio 时按概率决定是否放行半开状态下的请求，
+// 概率随连续成功次数线性增加
+func (cb *CircuitBreaker) admitHalfOpen() bool {
+	if cb.halfOpenAdmitRatio <= 0 {
+		return true
+	}
+	p := math.Min(cb.halfOpenAdmitRatio*float64(1+cb.counts.ConsecutiveSuccesses), 1)
+	return cb.random() < p
+}
+
+// finishRequest 在请求结束时减少正在进行的请求数，不论周期是否变化，每个请求只调用一次
+func (cb *CircuitBreaker) finishRequest() {
+	if cb.inFlight > 0 {
+		cb.inFlight--
+	}
+	if cb.inFlight == 0 && cb.drained != nil {
+		close(cb.drained)
+		cb.drained = nil
+	}
+}
+
+// finishHalfOpenRequest 在半开状态下的请求结束时减少正在进行的请求数
+func (cb *CircuitBreaker) finishHalfOpenRequest(state State) {
+	if state == StateHalfOpen && cb.halfOpenInFlight > 0 {
+		cb.halfOpenInFlight--
+		cb.wakeHalfOpenWaiters()
+	}
+}
+
+// reject 记录被拒绝的请求及其时间并调用 onReject，返回原来的错误或 rejectionError 返回的错误
+func (cb *CircuitBreaker) reject(now time.Time, state State, err error) error {
+	cb.counts.onRejection()
+	cb.lastRejection = now
+	if cb.rejectionError != nil {
+		if custom := cb.rejectionError(state, cb.counts); custom != nil {
+			err = &rejection{err: custom, reason: err}
+		}
+	}
+	cb.logRejection(err)
+	if cb.onReject != nil {
+		cb.onReject(cb.name, err)
+	}
+	return err
+}
+
+// rejection 是 RejectionError 返回的错误，Error 和 Unwrap 使用自定义的错误，
+// 同时仍然可以用 errors.Is 匹配原来的 ErrOpenState 等错误，Fallback 和 Retry 依赖这一点判断请求被拒绝
+type rejection struct {
+	err    error
+	reason error
+}
+
+func (e *rejection) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejection) Unwrap() error {
+	return e.err
+}
+
+func (e *rejection) Is(target error) bool {
+	return errors.Is(e.reason, target)
+}
+
+func (cb *CircuitBreaker) recordAdmissionLatency(start time.Time) {
+	elapsed := time.Since(start)
+	cb.admissionCount++
+	cb.admissionTotal += elapsed
+	if elapsed > cb.admissionMax {
+		cb.admissionMax = elapsed
+	}
+}
+
+// afterResult 根据请求错误的分类更新计数，ErrorClassIgnore 的请求不计数
+func (cb *CircuitBreaker) afterResult(before uint64, err error, elapsed time.Duration) {
+	cb.afterClassified(before, cb.classify(err), err, elapsed)
+}
+
+// afterClassified 与 afterResult 相同，class 是已经分类好的请求结果
+func (cb *CircuitBreaker) afterClassified(before uint64, class ErrorClass, err error, elapsed time.Duration) {
+	cb.observeLatency(elapsed)
+	switch class {
+	case ErrorClassIgnore:
+		cb.ignoreRequest(before)
+	case ErrorClassSuccess:
+		cb.afterTimedRequest(before, true, elapsed, err)
+	default:
+		cb.afterTimedRequest(before, false, elapsed, err)
+	}
+}
+
 func (cb *CircuitBreaker) afterRequest(before uint64, success bool) {
+	cb.afterTimedRequest(before, success, 0, nil)
+}
+
+// afterTimedRequest 与 afterRequest 相同，elapsed 是请求的耗时，用来统计慢调用，
+// err 是请求返回的错误，传给 OnFailure
+func (cb *CircuitBreaker) afterTimedRequest(before uint64, success bool, elapsed time.Duration, err error) {
+	// 在加锁前更新共享计数
+	shared, sharedOK := cb.sharedCounts(success)
+
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
 
-	now := time.Now()
-	state, generation := cb.currentState(now)
-	if generation != before {
+	if cb.metricsObserver != nil {
+		cb.metricsObserver.ObserveResult(cb.name, success)
+	}
+	now := cb.clock.Now()
+	cb.afterRequestAt(before, success, elapsed, now, err)
+
+	// 共享计数满足熔断条件时，同样切换为开启状态
+	if sharedOK && !success && cb.state == StateClosed && cb.readyToTrip(shared) {
+		cb.setState(StateOpen, now)
+	}
+}
+
+// afterRequestAt 是 afterRequest 的实际逻辑，调用方需要持有锁
+func (cb *CircuitBreaker) afterRequestAt(before uint64, success bool, elapsed time.Duration, now time.Time, err error) {
+	state, ok := cb.outcomeState(before, now)
+	if !ok {
 		return
 	}
+	cb.recordOutcome(state, success, elapsed, now, err)
+}
+
+// recordOutcome 在 state 下记录一个已经计数的请求的结果，调用方需要持有锁
+func (cb *CircuitBreaker) recordOutcome(state State, success bool, elapsed time.Duration, now time.Time, err error) {
+	slow := cb.slowCallDuration > 0 && elapsed >= cb.slowCallDuration
+	if slow {
+		cb.counts.onSlowCall()
+	}
 
 	// 更新状态和计数
 	if success {
-		cb.onSuccess(state, now)
+		// 请求成功但耗时过长时，关闭状态下同样需要判断是否熔断
+		cb.onSuccess(state, now, slow)
+	} else {
+		cb.onFailure(state, now, err)
+	}
+}
+
+// ignoreRequest 撤销请求计数，该请求既不算成功也不算失败
+func (cb *CircuitBreaker) ignoreRequest(before uint64) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.finishRequest()
+	now := cb.clock.Now()
+	state, generation := cb.currentState(now)
+	if generation != before {
+		return
+	}
+
+	cb.finishHalfOpenRequest(state)
+	cb.uncountRequest(now)
+}
+
+// uncountRequest 撤销 countRequest 的计数，调用方需要持有锁
+func (cb *CircuitBreaker) uncountRequest(now time.Time) {
+	cb.counts.Requests--
+	if cb.halfLife > 0 {
+		cb.decayed.decay(now, cb.halfLife)
+		cb.decayed.requests = math.Max(0, cb.decayed.requests-1)
+		cb.decayed.copyTo(&cb.counts)
+	}
+}
+
+// outcomeState 返回请求结果应计入的状态，如果结果已过期需要丢弃，则返回 false
+func (cb *CircuitBreaker) outcomeState(before uint64, now time.Time) (State, bool) {
+	cb.finishRequest()
+	state, generation := cb.currentState(now)
+	if generation == before {
+		cb.finishHalfOpenRequest(state)
 	} else {
-		cb.onFailure(state, now)
+		// 请求开始后一直处于关闭状态，只是因为 Interval 清空了计数，此时可以计入当前周期
+		if !cb.attributeStaleToCurrentGeneration || state != StateClosed || before < cb.stateGeneration {
+			return state, false
+		}
+		cb.countRequest(now)
+	}
+	return state, true
+}
+
+func (cb *CircuitBreaker) afterPartialRequest(before uint64, success bool, total, succeeded uint32, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.metricsObserver != nil {
+		cb.metricsObserver.ObserveResult(cb.name, success)
+	}
+
+	now := cb.clock.Now()
+	state, ok := cb.outcomeState(before, now)
+	if !ok {
+		return
+	}
+
+	cb.counts.onItems(total, succeeded)
+	if !success {
+		cb.onFailure(state, now, err)
+		return
+	}
+
+	cb.onSuccess(state, now, false)
+	// 请求本身成功但有条目失败时，关闭状态下同样需要判断是否熔断
+	if state == StateClosed && succeeded < total && cb.shouldTrip() {
+		cb.setState(StateOpen, now)
+	}
+}
+
+// countRequest、countSuccess、countFailure 更新计数，开启衰减时总数使用衰减后的值
+func (cb *CircuitBreaker) countRequest(now time.Time) {
+	cb.counts.onRequest()
+	if cb.halfLife > 0 {
+		cb.decayed.decay(now, cb.halfLife)
+		cb.decayed.requests++
+		cb.decayed.copyTo(&cb.counts)
+	}
+}
+
+func (cb *CircuitBreaker) countSuccess(now time.Time) {
+	cb.counts.onSuccess()
+	if cb.window != nil && cb.state == StateClosed {
+		cb.window.record(true, now)
+		cb.syncWindow(now)
+	}
+	if cb.recent != nil && cb.state == StateClosed {
+		cb.recent.record(true, now)
+	}
+	if cb.halfLife > 0 {
+		cb.decayed.decay(now, cb.halfLife)
+		cb.decayed.successes++
+		cb.decayed.copyTo(&cb.counts)
+	}
+}
+
+func (cb *CircuitBreaker) countFailure(now time.Time) {
+	cb.counts.onFailure()
+	if cb.window != nil && cb.state == StateClosed {
+		cb.window.record(false, now)
+		cb.syncWindow(now)
+	}
+	if cb.recent != nil && cb.state == StateClosed {
+		cb.recent.record(false, now)
+	}
+	if cb.halfLife > 0 {
+		cb.decayed.decay(now, cb.halfLife)
+		cb.decayed.failures++
+		cb.decayed.copyTo(&cb.counts)
+	}
+}
+
+// syncWindow 用滑动窗口内的结果更新总数，Requests 为窗口内的结果数加上正在执行的请求数
+func (cb *CircuitBreaker) syncWindow(now time.Time) {
+	inFlight := cb.counts.Requests - cb.counts.TotalSuccesses - cb.counts.TotalFailures
+	successes, failures := cb.window.totals(now)
+	cb.counts.TotalSuccesses = successes
+	cb.counts.TotalFailures = failures
+	cb.counts.Requests = inFlight + successes + failures
+}
+
+// shouldTrip 调用 readyToTrip 判断关闭状态下是否需要熔断，
+// 设置了 MinimumSamples 时使用最近的结果代替当前周期的总数，结果数不足时不熔断
+func (cb *CircuitBreaker) shouldTrip() bool {
+	if cb.recent == nil {
+		return cb.readyToTrip(cb.counts)
+	}
+	if cb.recent.size < len(cb.recent.outcomes) {
+		return false
+	}
+
+	counts := cb.counts
+	counts.Requests = cb.recent.successes + cb.recent.failures
+	counts.TotalSuccesses = cb.recent.successes
+	counts.TotalFailures = cb.recent.failures
+	return cb.readyToTrip(counts)
+}
+
+// resetRecent 清空最近的结果
+func (cb *CircuitBreaker) resetRecent() {
+	if cb.recent != nil {
+		cb.recent.reset()
 	}
 }
 
 // 熔断器请求成功时调用该函数
-func (cb *CircuitBreaker) onSuccess(state State, now time.Time) {
+// evaluate 为 true 时，关闭状态下也会调用 readyToTrip 判断是否熔断
+func (cb *CircuitBreaker) onSuccess(state State, now time.Time, evaluate bool) {
 	switch state {
 	case StateClosed: // 如果此时是关闭状态，则更新计数
-		cb.counts.onSuccess()
+		cb.countSuccess(now)
+		cb.notifySuccess()
+		if (evaluate || cb.evaluateOnSuccess) && cb.shouldTrip() {
+			cb.setState(StateOpen, now)
+		} else if cb.clearAfterConsecutiveSuccesses > 0 &&
+			cb.counts.ConsecutiveSuccesses >= cb.clearAfterConsecutiveSuccesses {
+			// 连续成功足够多次后清空计数，与 interval 到期一样进入新周期
+			cb.toNewGeneration(now)
+		}
 	case StateHalfOpen: // 半开状态
-		cb.counts.onSuccess() // 更新计数
-		// 连续成功总数超过了设置的 maxRequests，变更为关闭状态
-		if cb.counts.ConsecutiveSuccesses >= cb.maxRequests {
+		cb.countSuccess(now) // 更新计数
+		cb.notifySuccess()
+		if cb.halfOpenSuccessRatio > 0 {
+			cb.halfOpenSuccesses++
+			cb.checkHalfOpenRatio(now)
+			return
+		}
+		// 连续成功总数超过了 successThreshold，变更为关闭状态
+		if cb.counts.ConsecutiveSuccesses >= cb.successThreshold {
 			cb.setState(StateClosed, now)
 		}
 	}
 }
 
 // 熔断器请求失败时调用该函数
-func (cb *CircuitBreaker) onFailure(state State, now time.Time) {
+func (cb *CircuitBreaker) onFailure(state State, now time.Time, err error) {
 	switch state {
 	// 关闭状态下请求失败了
 	case StateClosed:
-		cb.counts.onFailure() // 更新计数
+		cb.countFailure(now) // 更新计数
+		cb.notifyFailure(err)
 		// 如果回调函数 readyToTrip 返回 true
 		// 因为一次失败可能不足以直接判定为需要熔断，所以可能失败多次后才会返回 true
 		// 比如官方示例中设置的回调函数是：
@@ -413,14 +2907,78 @@ func (cb *CircuitBreaker) onFailure(state State, now time.Time) {
 		//		return counts.Requests >= 3 && failureRatio >= 0.6
 		//	}
 		// 可以看到这里需要请求次数大于3，且总失败率大于等于 60% 才会返回 true
-		if cb.readyToTrip(cb.counts) {
+		// tripImmediately 判断为严重错误时直接熔断，不再调用 readyToTrip
+		if (err != nil && cb.tripImmediately != nil && cb.tripImmediately(err)) || cb.shouldTrip() {
 			cb.setState(StateOpen, now) // 变更熔断器为开启状态
+		} else {
+			cb.checkNearTrip()
 		}
 	case StateHalfOpen: // 半开状态下失败了，变更为开启状态
+		if cb.halfOpenSuccessRatio > 0 {
+			// 按成功率关闭时，一次失败不会直接变更为开启状态
+			cb.countFailure(now)
+			cb.notifyFailure(err)
+			cb.halfOpenFailures++
+			cb.checkHalfOpenRatio(now)
+			return
+		}
+		cb.notifyFailure(err)
+		cb.setState(StateOpen, now)
+	}
+}
+
+// checkHalfOpenRatio 在成功次数达到样本的 halfOpenSuccessRatio 时变更为关闭状态，
+// 在剩余的样本全部成功也无法达到时变更为开启状态
+func (cb *CircuitBreaker) checkHalfOpenRatio(now time.Time) {
+	sample := float64(cb.halfOpenSampleSize)
+	need := cb.halfOpenSuccessRatio * sample
+	if float64(cb.halfOpenSuccesses) >= need {
+		cb.setState(StateClosed, now)
+	} else if sample-float64(cb.halfOpenFailures) < need {
 		cb.setState(StateOpen, now)
 	}
 }
 
+// halfOpenRatioPressure 返回按成功率关闭时还需要的成功次数占样本剩余次数的比例，
+// 剩余的样本必须全部成功时为 1，与 checkHalfOpenRatio 的判断一致。调用方需要持有锁
+func (cb *CircuitBreaker) halfOpenRatioPressure() float64 {
+	needed := math.Ceil(cb.halfOpenSuccessRatio*float64(cb.halfOpenSampleSize)) - float64(cb.halfOpenSuccesses)
+	if needed <= 0 {
+		return 0
+	}
+	remaining := float64(cb.halfOpenSampleSize) - float64(cb.halfOpenSuccesses) - float64(cb.halfOpenFailures)
+	if remaining <= needed {
+		return 1
+	}
+	return needed / remaining
+}
+
+// notifySuccess 在计数更新后、状态变更前调用 OnSuccess，调用方需要持有锁
+func (cb *CircuitBreaker) notifySuccess() {
+	if cb.onSuccessHook != nil {
+		cb.onSuccessHook(cb.name, cb.counts)
+	}
+}
+
+// notifyFailure 在计数更新后、状态变更前调用 OnFailure，调用方需要持有锁
+func (cb *CircuitBreaker) notifyFailure(err error) {
+	if cb.onFailureHook != nil {
+		cb.onFailureHook(cb.name, cb.counts, err)
+	}
+}
+
+// checkNearTrip 在未熔断时计算距离熔断条件的 margin，足够接近时调用 onNearTrip
+func (cb *CircuitBreaker) checkNearTrip() {
+	if cb.onNearTrip == nil || cb.tripMargin == nil {
+		return
+	}
+
+	margin := cb.tripMargin(cb.counts)
+	if margin <= cb.nearTripMargin {
+		cb.onNearTrip(cb.name, cb.counts, margin)
+	}
+}
+
 // currentState 返回熔断器当前的状态，now 用来判断是否需要执行某些操作，这些操作包括：
 // 1. 关闭状态下清空计数（如果设置了 interval 且达到了清空时间）
 // 2. 开启状态转换为半开启状态（到达了转换时间）
@@ -442,46 +3000,156 @@ func (cb *CircuitBreaker) currentState(now time.Time) (State, uint64) {
 			cb.toNewGeneration(now)
 		}
 	case StateOpen:
-		// 超过了 expiry 的时间，可以切换到半开状态了
-		if cb.expiry.Before(now) {
+		if cb.openTooLong(now) {
+			cb.setState(StateClosed, now)
+		} else if !cb.forced && cb.expiry.Before(now) {
+			// 超过了 expiry 的时间，可以切换到半开状态了（强制开启时不切换）
 			cb.setState(StateHalfOpen, now)
 		}
+	case StateHalfOpen:
+		if cb.openTooLong(now) {
+			cb.setState(StateClosed, now)
+		}
 	}
 	return cb.state, cb.generation
 }
 
+// openTooLong 判断离开关闭状态的时间是否已经达到 maxOpenDuration（强制状态下不切换）
+func (cb *CircuitBreaker) openTooLong(now time.Time) bool {
+	return cb.maxOpenDuration > 0 && !cb.forced && !now.Before(cb.openSince.Add(cb.maxOpenDuration))
+}
+
+// setState 在 beforeStateChange 允许时变更状态，否认时保持当前状态并重新开始计时
 func (cb *CircuitBreaker) setState(state State, now time.Time) {
+	// 强制状态下不允许变更为其他状态
+	if cb.state == state || (cb.forced && state != cb.forcedState) {
+		return
+	}
+
+	if cb.beforeStateChange != nil && !cb.beforeStateChange(cb.state, state) {
+		cb.vetoState(now)
+		return
+	}
+	cb.changeState(state, now)
+}
+
+// vetoState 在状态变更被否认后重新开始当前状态的计时，避免每个请求都再次触发同一个变更：
+// 开启状态在 expiry 已过时重新等待 openTimeout，半开状态进入新的周期重新探测，
+// 达到 maxOpenDuration 时从现在重新计算。关闭状态保留计数，下次满足条件时再次询问
+func (cb *CircuitBreaker) vetoState(now time.Time) {
+	if cb.openTooLong(now) {
+		cb.openSince = now
+	}
+	switch cb.state {
+	case StateOpen:
+		if !cb.expiry.After(now) {
+			cb.expiry = now.Add(cb.openTimeout)
+		}
+	case StateHalfOpen:
+		cb.toNewGeneration(now)
+	}
+}
+
+// changeState 无条件地变更状态，调用方需要持有锁
+func (cb *CircuitBreaker) changeState(state State, now time.Time) {
 	if cb.state == state {
 		return
 	}
 
 	prev := cb.state
 	cb.state = state
+	counts := cb.counts // 在清空前保存计数的快照
 
 	cb.toNewGeneration(now) // 设置新状态后更新计数
+	cb.resetRecent()
+	cb.stateGeneration = cb.generation
+	cb.stateSince = now
+	if prev == StateClosed {
+		cb.openSince = now
+		cb.stuckOpenSince = time.Now()
+	}
+	if state == StateOpen {
+		cb.trips++
+		cb.lastTrippedAt = now
+	}
+	// 开启和半开之间的切换不重新计时
+	if prev == StateClosed || state == StateClosed {
+		cb.armStuckOpen()
+	}
 
 	if cb.onStateChange != nil {
 		cb.onStateChange(cb.name, prev, state)
 	}
+	if cb.onStateChangeWithCounts != nil {
+		cb.onStateChangeWithCounts(cb.name, prev, state, counts)
+	}
+	if state == StateHalfOpen && cb.onHalfOpen != nil {
+		cb.onHalfOpen(cb.name, cb.maxRequests)
+	}
+	if cb.metricsObserver != nil {
+		cb.metricsObserver.ObserveStateChange(cb.name, prev, state)
+	}
+	cb.logStateChange(prev, state, counts)
+	cb.publishEvent(StateChange{Name: cb.name, From: prev, To: state, At: now, Counts: counts})
+	cb.publishState(state)
+	cb.propagateState(state)
+
+	if state == StateClosed {
+		cb.stopProbe()
+	} else {
+		cb.startProbe()
+	}
+}
+
+// nextInterval 返回下一个周期的长度，设置了 intervalJitter 时加上随机偏移
+func (cb *CircuitBreaker) nextInterval() time.Duration {
+	if cb.intervalJitter <= 0 {
+		return cb.interval
+	}
+	span := 2*int64(cb.intervalJitter) + 1
+	offset := int64(cb.random() * float64(span))
+	if offset >= span {
+		offset = span - 1 // 浮点误差
+	}
+	return cb.interval + time.Duration(offset-int64(cb.intervalJitter))
 }
 
 // 进入一个新周期，会清空计数，并对 cb.expiry 进行更新
 // 该函数会在 setState、currentState、NewCircuitBreaker 调用
 func (cb *CircuitBreaker) toNewGeneration(now time.Time) {
-	cb.generation++
-	cb.counts.clear()
+	cb.clearGeneration()
 
 	var zero time.Time
 	switch cb.state {
 	case StateClosed:
+		cb.openAttempts = 0
 		if cb.interval == 0 {
 			cb.expiry = zero
 		} else {
-			cb.expiry = now.Add(cb.interval)
+			cb.expiry = now.Add(cb.nextInterval())
 		}
 	case StateOpen:
-		cb.expiry = now.Add(cb.timeout) // 设置 open -> halfOpen 的绝对时间
+		cb.openAttempts++
+		cb.openTimeout = cb.nextTimeout()
+		cb.expiry = now.Add(cb.openTimeout) // 设置 open -> halfOpen 的绝对时间
 	default: // StateHalfOpen
 		cb.expiry = zero
 	}
 }
+
+// clearGeneration 进入一个新周期并清空计数，不更新 cb.expiry 和 openAttempts
+func (cb *CircuitBreaker) clearGeneration() {
+	cb.generation++
+	cb.counts.clear()
+	cb.halfOpenInFlight = 0
+	cb.halfOpenSuccesses = 0
+	cb.halfOpenFailures = 0
+	cb.wakeHalfOpenWaiters()
+	cb.decayed = decayedCounts{}
+	if cb.resetLatency {
+		cb.latency.reset()
+	}
+	if cb.window != nil {
+		cb.window.reset()
+	}
+}
@@ -1,8 +1,15 @@
 package gobreaker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,13 +20,23 @@ var defaultCB *CircuitBreaker
 var customCB *CircuitBreaker
 var negativeDurationCB *CircuitBreaker
 
-type StateChange struct {
+type stateTransition struct {
 	name string
 	from State
 	to   State
 }
 
-var stateChange StateChange
+var stateChange stateTransition
+
+func newCounts(requests, totalSuccesses, totalFailures, consecutiveSuccesses, consecutiveFailures uint32) Counts {
+	return Counts{
+		Requests:             requests,
+		TotalSuccesses:       totalSuccesses,
+		TotalFailures:        totalFailures,
+		ConsecutiveSuccesses: consecutiveSuccesses,
+		ConsecutiveFailures:  consecutiveFailures,
+	}
+}
 
 func pseudoSleep(cb *CircuitBreaker, period time.Duration) {
 	if !cb.expiry.IsZero() {
@@ -93,7 +110,7 @@ func newCustom() *CircuitBreaker {
 		return numReqs >= 3 && failureRatio >= 0.6
 	}
 	customSt.OnStateChange = func(name string, from State, to State) {
-		stateChange = StateChange{name, from, to}
+		stateChange = stateTransition{name, from, to}
 	}
 
 	return NewCircuitBreaker(customSt)
@@ -125,6 +142,33 @@ func TestStateConstants(t *testing.T) {
 	assert.Equal(t, State(100).String(), "unknown state: 100")
 }
 
+func TestStateJSON(t *testing.T) {
+	for _, state := range []State{StateClosed, StateHalfOpen, StateOpen} {
+		data, err := json.Marshal(state)
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.Quote(state.String()), string(data))
+
+		var decoded State
+		assert.Nil(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, state, decoded)
+
+		parsed, err := ParseState(state.String())
+		assert.Nil(t, err)
+		assert.Equal(t, state, parsed)
+	}
+
+	var state State
+	assert.Nil(t, json.Unmarshal([]byte("2"), &state))
+	assert.Equal(t, StateOpen, state)
+	assert.Error(t, json.Unmarshal([]byte(`"opened"`), &state))
+	assert.Error(t, json.Unmarshal([]byte("7"), &state))
+	assert.Error(t, json.Unmarshal([]byte("true"), &state))
+	_, err := json.Marshal(State(100))
+	assert.Error(t, err)
+	_, err = ParseState("Open")
+	assert.Error(t, err)
+}
+
 func TestNewCircuitBreaker(t *testing.T) {
 	defaultCB := NewCircuitBreaker(Settings{})
 	assert.Equal(t, "", defaultCB.name)
@@ -134,7 +178,7 @@ func TestNewCircuitBreaker(t *testing.T) {
 	assert.NotNil(t, defaultCB.readyToTrip)
 	assert.Nil(t, defaultCB.onStateChange)
 	assert.Equal(t, StateClosed, defaultCB.state)
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), defaultCB.counts)
 	assert.True(t, defaultCB.expiry.IsZero())
 
 	customCB := newCustom()
@@ -145,7 +189,7 @@ func TestNewCircuitBreaker(t *testing.T) {
 	assert.NotNil(t, customCB.readyToTrip)
 	assert.NotNil(t, customCB.onStateChange)
 	assert.Equal(t, StateClosed, customCB.state)
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, customCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), customCB.counts)
 	assert.False(t, customCB.expiry.IsZero())
 
 	negativeDurationCB := newNegativeDurationCB()
@@ -156,7 +200,7 @@ func TestNewCircuitBreaker(t *testing.T) {
 	assert.NotNil(t, negativeDurationCB.readyToTrip)
 	assert.Nil(t, negativeDurationCB.onStateChange)
 	assert.Equal(t, StateClosed, negativeDurationCB.state)
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, negativeDurationCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), negativeDurationCB.counts)
 	assert.True(t, negativeDurationCB.expiry.IsZero())
 }
 
@@ -167,27 +211,27 @@ func TestDefaultCircuitBreaker(t *testing.T) {
 		assert.Nil(t, fail(defaultCB))
 	}
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{5, 0, 5, 0, 5}, defaultCB.counts)
+	assert.Equal(t, newCounts(5, 0, 5, 0, 5), defaultCB.counts)
 
 	assert.Nil(t, succeed(defaultCB))
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{6, 1, 5, 1, 0}, defaultCB.counts)
+	assert.Equal(t, newCounts(6, 1, 5, 1, 0), defaultCB.counts)
 
 	assert.Nil(t, fail(defaultCB))
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{7, 1, 6, 0, 1}, defaultCB.counts)
+	assert.Equal(t, newCounts(7, 1, 6, 0, 1), defaultCB.counts)
 
 	// StateClosed to StateOpen
 	for i := 0; i < 5; i++ {
 		assert.Nil(t, fail(defaultCB)) // 6 consecutive failures
 	}
 	assert.Equal(t, StateOpen, defaultCB.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), defaultCB.counts)
 	assert.False(t, defaultCB.expiry.IsZero())
 
 	assert.Error(t, succeed(defaultCB))
 	assert.Error(t, fail(defaultCB))
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, Counts{Rejections: 2}, defaultCB.counts)
 
 	pseudoSleep(defaultCB, time.Duration(59)*time.Second)
 	assert.Equal(t, StateOpen, defaultCB.State())
@@ -200,7 +244,7 @@ func TestDefaultCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateOpen
 	assert.Nil(t, fail(defaultCB))
 	assert.Equal(t, StateOpen, defaultCB.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), defaultCB.counts)
 	assert.False(t, defaultCB.expiry.IsZero())
 
 	// StateOpen to StateHalfOpen
@@ -211,7 +255,7 @@ func TestDefaultCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateClosed
 	assert.Nil(t, succeed(defaultCB))
 	assert.Equal(t, StateClosed, defaultCB.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, defaultCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), defaultCB.counts)
 	assert.True(t, defaultCB.expiry.IsZero())
 }
 
@@ -223,47 +267,47 @@ func TestCustomCircuitBreaker(t *testing.T) {
 		assert.Nil(t, fail(customCB))
 	}
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{10, 5, 5, 0, 1}, customCB.counts)
+	assert.Equal(t, newCounts(10, 5, 5, 0, 1), customCB.counts)
 
 	pseudoSleep(customCB, time.Duration(29)*time.Second)
 	assert.Nil(t, succeed(customCB))
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{11, 6, 5, 1, 0}, customCB.counts)
+	assert.Equal(t, newCounts(11, 6, 5, 1, 0), customCB.counts)
 
 	pseudoSleep(customCB, time.Duration(1)*time.Second) // over Interval
 	assert.Nil(t, fail(customCB))
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{1, 0, 1, 0, 1}, customCB.counts)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), customCB.counts)
 
 	// StateClosed to StateOpen
 	assert.Nil(t, succeed(customCB))
 	assert.Nil(t, fail(customCB)) // failure ratio: 2/3 >= 0.6
 	assert.Equal(t, StateOpen, customCB.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, customCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), customCB.counts)
 	assert.False(t, customCB.expiry.IsZero())
-	assert.Equal(t, StateChange{"cb", StateClosed, StateOpen}, stateChange)
+	assert.Equal(t, stateTransition{"cb", StateClosed, StateOpen}, stateChange)
 
 	// StateOpen to StateHalfOpen
 	pseudoSleep(customCB, time.Duration(90)*time.Second)
 	assert.Equal(t, StateHalfOpen, customCB.State())
 	assert.True(t, defaultCB.expiry.IsZero())
-	assert.Equal(t, StateChange{"cb", StateOpen, StateHalfOpen}, stateChange)
+	assert.Equal(t, stateTransition{"cb", StateOpen, StateHalfOpen}, stateChange)
 
 	assert.Nil(t, succeed(customCB))
 	assert.Nil(t, succeed(customCB))
 	assert.Equal(t, StateHalfOpen, customCB.State())
-	assert.Equal(t, Counts{2, 2, 0, 2, 0}, customCB.counts)
+	assert.Equal(t, newCounts(2, 2, 0, 2, 0), customCB.counts)
 
 	// StateHalfOpen to StateClosed
 	ch := succeedLater(customCB, time.Duration(100)*time.Millisecond) // 3 consecutive successes
 	time.Sleep(time.Duration(50) * time.Millisecond)
-	assert.Equal(t, Counts{3, 2, 0, 2, 0}, customCB.counts)
+	assert.Equal(t, newCounts(3, 2, 0, 2, 0), customCB.counts)
 	assert.Error(t, succeed(customCB)) // over MaxRequests
 	assert.Nil(t, <-ch)
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, customCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), customCB.counts)
 	assert.False(t, customCB.expiry.IsZero())
-	assert.Equal(t, StateChange{"cb", StateHalfOpen, StateClosed}, stateChange)
+	assert.Equal(t, stateTransition{"cb", StateHalfOpen, StateClosed}, stateChange)
 }
 
 func TestTwoStepCircuitBreaker(t *testing.T) {
@@ -275,27 +319,27 @@ func TestTwoStepCircuitBreaker(t *testing.T) {
 	}
 
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{5, 0, 5, 0, 5}, tscb.cb.counts)
+	assert.Equal(t, newCounts(5, 0, 5, 0, 5), tscb.cb.counts)
 
 	assert.Nil(t, succeed2Step(tscb))
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{6, 1, 5, 1, 0}, tscb.cb.counts)
+	assert.Equal(t, newCounts(6, 1, 5, 1, 0), tscb.cb.counts)
 
 	assert.Nil(t, fail2Step(tscb))
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{7, 1, 6, 0, 1}, tscb.cb.counts)
+	assert.Equal(t, newCounts(7, 1, 6, 0, 1), tscb.cb.counts)
 
 	// StateClosed to StateOpen
 	for i := 0; i < 5; i++ {
 		assert.Nil(t, fail2Step(tscb)) // 6 consecutive failures
 	}
 	assert.Equal(t, StateOpen, tscb.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), tscb.cb.counts)
 	assert.False(t, tscb.cb.expiry.IsZero())
 
 	assert.Error(t, succeed2Step(tscb))
 	assert.Error(t, fail2Step(tscb))
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, Counts{Rejections: 2}, tscb.cb.counts)
 
 	pseudoSleep(tscb.cb, time.Duration(59)*time.Second)
 	assert.Equal(t, StateOpen, tscb.State())
@@ -308,7 +352,7 @@ func TestTwoStepCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateOpen
 	assert.Nil(t, fail2Step(tscb))
 	assert.Equal(t, StateOpen, tscb.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), tscb.cb.counts)
 	assert.False(t, tscb.cb.expiry.IsZero())
 
 	// StateOpen to StateHalfOpen
@@ -319,13 +363,13 @@ func TestTwoStepCircuitBreaker(t *testing.T) {
 	// StateHalfOpen to StateClosed
 	assert.Nil(t, succeed2Step(tscb))
 	assert.Equal(t, StateClosed, tscb.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, tscb.cb.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), tscb.cb.counts)
 	assert.True(t, tscb.cb.expiry.IsZero())
 }
 
 func TestPanicInRequest(t *testing.T) {
 	assert.Panics(t, func() { causePanic(defaultCB) })
-	assert.Equal(t, Counts{1, 0, 1, 0, 1}, defaultCB.counts)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), defaultCB.counts)
 }
 
 func TestGeneration(t *testing.T) {
@@ -333,15 +377,39 @@ func TestGeneration(t *testing.T) {
 	assert.Nil(t, succeed(customCB))
 	ch := succeedLater(customCB, time.Duration(1500)*time.Millisecond)
 	time.Sleep(time.Duration(500) * time.Millisecond)
-	assert.Equal(t, Counts{2, 1, 0, 1, 0}, customCB.counts)
+	assert.Equal(t, newCounts(2, 1, 0, 1, 0), customCB.counts)
 
 	time.Sleep(time.Duration(500) * time.Millisecond) // over Interval
 	assert.Equal(t, StateClosed, customCB.State())
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, customCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), customCB.counts)
 
 	// the request from the previous generation has no effect on customCB.counts
 	assert.Nil(t, <-ch)
-	assert.Equal(t, Counts{0, 0, 0, 0, 0}, customCB.counts)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), customCB.counts)
+}
+
+func TestConsecutiveFailuresThreshold(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{ConsecutiveFailuresThreshold: 3})
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 1.0/3, cb.tripMargin(cb.counts))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	cb = New(WithConsecutiveFailuresThreshold(1))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// ReadyToTrip takes precedence
+	cb = NewCircuitBreaker(Settings{
+		ConsecutiveFailuresThreshold: 1,
+		ReadyToTrip:                  func(counts Counts) bool { return counts.ConsecutiveFailures >= 2 },
+	})
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, cb.tripMargin)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
 }
 
 func TestCustomIsSuccessful(t *testing.T) {
@@ -354,7 +422,7 @@ func TestCustomIsSuccessful(t *testing.T) {
 		assert.Nil(t, fail(cb))
 	}
 	assert.Equal(t, StateClosed, cb.State())
-	assert.Equal(t, Counts{5, 5, 0, 5, 0}, cb.counts)
+	assert.Equal(t, newCounts(5, 5, 0, 5, 0), cb.counts)
 
 	cb.counts.clear()
 
@@ -368,6 +436,48 @@ func TestCustomIsSuccessful(t *testing.T) {
 
 }
 
+func TestIsSuccessfulResult(t *testing.T) {
+	type response struct{ status int }
+	cb := NewCircuitBreaker(Settings{
+		IsSuccessful: func(error) bool { return false }, // overridden
+		IsSuccessfulResult: func(result interface{}, err error) bool {
+			resp, ok := result.(*response)
+			return err == nil && ok && resp.status < 500
+		},
+	})
+	respond := func(status int) error {
+		_, err := cb.Execute(func() (interface{}, error) { return &response{status: status}, nil })
+		return err
+	}
+
+	assert.Nil(t, respond(200))
+	assert.Nil(t, respond(503))
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	_, err := cb.ExecuteContext(context.Background(), func(context.Context) (interface{}, error) {
+		return &response{status: 404}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, newCounts(3, 2, 1, 1, 0), cb.Counts())
+
+	// the options of ExecuteWith take precedence
+	_, err = cb.ExecuteWith(CallOptions{IsSuccessful: func(error) bool { return true }},
+		func() (interface{}, error) { return &response{status: 500}, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, newCounts(4, 3, 1, 2, 0), cb.Counts())
+
+	// requests without a result still use IsSuccessful
+	assert.Nil(t, cb.ExecutePartial(func() (int, int, error) { return 1, 1, nil }))
+	assert.Equal(t, uint32(2), cb.Counts().TotalFailures)
+
+	tcb := NewTypedCircuitBreaker[int](Settings{
+		IsSuccessfulResult: func(result interface{}, err error) bool { return result.(int) > 0 },
+	})
+	_, err = tcb.Execute(func() (int, error) { return 0, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), tcb.Counts())
+}
+
 func TestCircuitBreakerInParallel(t *testing.T) {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -390,5 +500,2206 @@ func TestCircuitBreakerInParallel(t *testing.T) {
 		err := <-ch
 		assert.Nil(t, err)
 	}
-	assert.Equal(t, Counts{total, total, 0, total, 0}, customCB.counts)
+	assert.Equal(t, newCounts(total, total, 0, total, 0), customCB.counts)
+}
+
+func TestOnNearTrip(t *testing.T) {
+	var margins []float64
+	cb := NewCircuitBreaker(Settings{
+		OnNearTrip: func(name string, counts Counts, margin float64) {
+			margins = append(margins, margin)
+		},
+	})
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Empty(t, margins) // 2/6 > 0.2
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Len(t, margins, 1)
+	assert.InDelta(t, 1.0/6, margins[0], 1e-9)
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Len(t, margins, 1) // tripped, not a near trip
+
+	// custom ReadyToTrip without TripMargin never reports near trips
+	margins = nil
+	cb = NewCircuitBreaker(Settings{
+		ReadyToTrip: func(counts Counts) bool { return false },
+		OnNearTrip: func(name string, counts Counts, margin float64) {
+			margins = append(margins, margin)
+		},
+	})
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Empty(t, margins)
+}
+
+func TestFailureRatioMargin(t *testing.T) {
+	margin := FailureRatioMargin(10, 0.5)
+	assert.Equal(t, 1.0, margin(Counts{}))
+	assert.InDelta(t, 0.2, margin(Counts{Requests: 10, TotalFailures: 4}), 1e-9)
+	assert.InDelta(t, 0.1, margin(Counts{Requests: 9, TotalFailures: 5}), 1e-9)
+	assert.Equal(t, 0.0, margin(Counts{Requests: 10, TotalFailures: 5}))
+
+	cb := NewCircuitBreaker(Settings{
+		Name:           "ratio",
+		NearTripMargin: 0.25,
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.Requests >= 10 && float64(counts.TotalFailures)/float64(counts.Requests) >= 0.5
+		},
+		TripMargin: margin,
+		OnNearTrip: func(name string, counts Counts, margin float64) {
+			assert.Equal(t, "ratio", name)
+			stateChange = stateTransition{name, StateClosed, StateClosed}
+		},
+	})
+	stateChange = stateTransition{}
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, stateTransition{"ratio", StateClosed, StateClosed}, stateChange)
+}
+
+func TestConsecutiveFailuresMargin(t *testing.T) {
+	margin := ConsecutiveFailuresMargin(4)
+	assert.Equal(t, 1.0, margin(Counts{}))
+	assert.Equal(t, 0.25, margin(Counts{ConsecutiveFailures: 3}))
+	assert.Equal(t, 0.0, margin(Counts{ConsecutiveFailures: 4}))
+	assert.Equal(t, 0.0, ConsecutiveFailuresMargin(0)(Counts{}))
+}
+
+func TestCountsAndResetConsecutive(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{ReadyToTrip: func(Counts) bool { return false }})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, newCounts(3, 0, 3, 0, 3), cb.CountsAndResetConsecutive())
+	assert.Equal(t, newCounts(3, 0, 3, 0, 0), cb.Counts())
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, uint64(1), cb.generation)
+
+	// every failure is observed by exactly one snapshot
+	const numRoutines = 10
+	const numReqs = 1000
+	done := make(chan struct{})
+	for i := 0; i < numRoutines; i++ {
+		go func() {
+			for j := 0; j < numReqs; j++ {
+				fail(cb)
+			}
+			done <- struct{}{}
+		}()
+	}
+
+	var observed uint32
+	for finished := 0; finished < numRoutines; {
+		select {
+		case <-done:
+			finished++
+		default:
+			observed += cb.CountsAndResetConsecutive().ConsecutiveFailures
+		}
+	}
+	observed += cb.CountsAndResetConsecutive().ConsecutiveFailures
+	assert.Equal(t, uint32(numRoutines*numReqs), observed)
+	assert.Equal(t, uint32(numRoutines*numReqs+3), cb.Counts().TotalFailures)
+}
+
+func TestAdmissionFunc(t *testing.T) {
+	errQuota := fmt.Errorf("quota exceeded")
+	var gateErr error
+	admit := true
+	var called int
+	cb := NewCircuitBreaker(Settings{
+		AdmissionFunc: func(state State, counts Counts) (bool, error) {
+			called++
+			return admit, gateErr
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	admit = false
+	assert.Equal(t, ErrNotAdmitted, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	gateErr = errQuota
+	assert.Equal(t, errQuota, succeed(cb))
+
+	// the gate cannot loosen the built-in checks
+	admit, gateErr = true, nil
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	called = 0
+	assert.True(t, errors.Is(succeed(cb), ErrOpenState))
+	assert.Equal(t, 0, called)
+}
+
+func TestAdmissionLatencyStats(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.Nil(t, succeed(cb))
+	avg, max := cb.AdmissionLatencyStats()
+	assert.Equal(t, time.Duration(0), avg)
+	assert.Equal(t, time.Duration(0), max)
+
+	cb = NewCircuitBreaker(Settings{TrackAdmissionLatency: true})
+	ch := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				ch <- succeed(cb)
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, <-ch)
+	}
+
+	avg, max = cb.AdmissionLatencyStats()
+	assert.Equal(t, uint64(1000), cb.admissionCount)
+	assert.True(t, avg > 0)
+	assert.True(t, max >= avg)
+}
+
+func BenchmarkAdmissionLatency(b *testing.B) {
+	cb := NewCircuitBreaker(Settings{TrackAdmissionLatency: true})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			succeed(cb)
+		}
+	})
+	avg, max := cb.AdmissionLatencyStats()
+	b.ReportMetric(float64(avg.Nanoseconds()), "admit-avg-ns")
+	b.ReportMetric(float64(max.Nanoseconds()), "admit-max-ns")
+}
+
+func TestAttributeStaleToCurrentGeneration(t *testing.T) {
+	for _, attribute := range []bool{false, true} {
+		cb := NewCircuitBreaker(Settings{
+			Interval:                          time.Duration(30) * time.Second,
+			AttributeStaleToCurrentGeneration: attribute,
+		})
+
+		generation, err := cb.beforeRequest()
+		assert.Nil(t, err)
+		pseudoSleep(cb, time.Duration(31)*time.Second) // over Interval
+		assert.Equal(t, StateClosed, cb.State())
+		assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+		cb.afterRequest(generation, false)
+		if attribute {
+			assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+		} else {
+			assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+		}
+	}
+
+	// requests longer than Interval can still trip the CircuitBreaker
+	cb := NewCircuitBreaker(Settings{
+		Interval:                          time.Duration(30) * time.Second,
+		AttributeStaleToCurrentGeneration: true,
+	})
+	var generations []uint64
+	for i := 0; i < 6; i++ {
+		generation, err := cb.beforeRequest()
+		assert.Nil(t, err)
+		generations = append(generations, generation)
+	}
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	for _, generation := range generations {
+		cb.afterRequest(generation, false)
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// outcomes from before a state change are always dropped
+	cb = NewCircuitBreaker(Settings{AttributeStaleToCurrentGeneration: true})
+	generation, err := cb.beforeRequest()
+	assert.Nil(t, err)
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	cb.afterRequest(generation, false)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+}
+
+func TestHalfLife(t *testing.T) {
+	readyToTrip := func(counts Counts) bool { return counts.TotalFailures >= 3 }
+	cb := NewCircuitBreaker(Settings{HalfLife: time.Duration(10) * time.Second, ReadyToTrip: readyToTrip})
+	start := time.Now()
+
+	cb.replay(false, start)
+	cb.replay(false, start)
+	assert.Equal(t, newCounts(2, 0, 2, 0, 2), cb.Counts())
+
+	// one half-life later the old outcomes count half
+	cb.replay(true, start.Add(time.Duration(10)*time.Second))
+	assert.Equal(t, newCounts(2, 1, 1, 1, 0), cb.Counts())
+	assert.InDelta(t, 2.0, cb.decayed.requests, 1e-9)
+	assert.InDelta(t, 1.0, cb.decayed.failures, 1e-9)
+
+	// old failures fade out and don't trip the breaker
+	state, _ := cb.replay(false, start.Add(time.Duration(100)*time.Second))
+	assert.Equal(t, StateClosed, state)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+
+	// recent failures still trip it
+	for i := 0; i < 2; i++ {
+		state, _ = cb.replay(false, start.Add(time.Duration(100)*time.Second))
+	}
+	assert.Equal(t, StateOpen, state)
+
+	// without HalfLife the same trace trips on the 3rd failure
+	cb = NewCircuitBreaker(Settings{ReadyToTrip: readyToTrip})
+	cb.replay(false, start)
+	cb.replay(false, start)
+	cb.replay(true, start.Add(time.Duration(10)*time.Second))
+	state, _ = cb.replay(false, start.Add(time.Duration(100)*time.Second))
+	assert.Equal(t, StateOpen, state)
+}
+
+func TestDetailedState(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.Equal(t, DetailedState{State: StateClosed}, cb.DetailedState())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, DetailedState{State: StateOpen}, cb.DetailedState())
+	assert.Equal(t, cb.State(), cb.DetailedState().State)
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, DetailedState{State: StateHalfOpen}, cb.DetailedState())
+}
+
+func TestExecutePartial(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.TotalItems >= 30 && counts.ItemSuccessRatio() < 0.5
+		},
+	})
+	batch := func(total, succeeded int) func() (int, int, error) {
+		return func() (int, int, error) { return total, succeeded, nil }
+	}
+
+	assert.Equal(t, 1.0, cb.Counts().ItemSuccessRatio())
+	assert.Nil(t, cb.ExecutePartial(batch(10, 8)))
+	assert.Nil(t, cb.ExecutePartial(batch(10, 2)))
+	counts := cb.Counts()
+	assert.Equal(t, uint32(2), counts.TotalSuccesses)
+	assert.Equal(t, uint32(20), counts.TotalItems)
+	assert.Equal(t, uint32(10), counts.SucceededItems)
+	assert.Equal(t, StateClosed, cb.State())
+
+	// requests keep succeeding but the item-level success rate is too low
+	assert.Nil(t, cb.ExecutePartial(batch(10, 1)))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.True(t, errors.Is(cb.ExecutePartial(batch(10, 10)), ErrOpenState))
+
+	cb = NewCircuitBreaker(Settings{})
+	errBatch := fmt.Errorf("batch failed")
+	assert.Equal(t, errBatch, cb.ExecutePartial(func() (int, int, error) { return 5, 7, errBatch }))
+	counts = cb.Counts()
+	assert.Equal(t, uint32(1), counts.TotalFailures)
+	assert.Equal(t, uint32(5), counts.SucceededItems) // clamped to total
+	assert.Panics(t, func() { cb.ExecutePartial(func() (int, int, error) { panic("oops") }) })
+	assert.Equal(t, uint32(2), cb.Counts().TotalFailures)
+}
+
+func TestPressure(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{MaxRequests: 2})
+	assert.Equal(t, 0.0, cb.Pressure())
+
+	var prev float64
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+		assert.True(t, cb.Pressure() > prev)
+		prev = cb.Pressure()
+	}
+	assert.InDelta(t, 5.0/6, cb.Pressure(), 1e-9)
+
+	assert.Nil(t, succeed(cb)) // recovery resets consecutive failures
+	assert.Equal(t, 0.0, cb.Pressure())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, 1.0, cb.Pressure())
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, 1.0, cb.Pressure())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, 0.5, cb.Pressure())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, 0.0, cb.Pressure())
+
+	// custom ReadyToTrip without TripMargin falls back to the failure ratio
+	cb = NewCircuitBreaker(Settings{ReadyToTrip: func(Counts) bool { return false }})
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 0.5, cb.Pressure())
+
+	// with HalfOpenSuccessRatio a failure doesn't push the half-open pressure to 1
+	cb = NewCircuitBreaker(Settings{HalfOpenSuccessRatio: 0.6, HalfOpenSampleSize: 5})
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.InDelta(t, 3.0/5, cb.Pressure(), 1e-9)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.InDelta(t, 3.0/4, cb.Pressure(), 1e-9)
+	assert.Nil(t, succeed(cb))
+	assert.InDelta(t, 2.0/3, cb.Pressure(), 1e-9)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 1.0, cb.Pressure()) // both remaining outcomes have to succeed
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, 0.0, cb.Pressure())
+}
+
+func TestExecuteContext(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	result, err := cb.ExecuteContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return "ok", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", result)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	// an already cancelled context doesn't reach the breaker
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	_, err = cb.ExecuteContext(ctx, func(ctx context.Context) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, called)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	// by default a request cancelled in flight is a failure
+	waitCancel := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(10)*time.Millisecond)
+	defer cancel()
+	_, err = cb.ExecuteContext(ctx, waitCancel)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+}
+
+func TestExecuteContextIgnoreContextErrors(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{IgnoreContextErrors: true})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := cb.ExecuteContext(ctx, func(ctx context.Context) (interface{}, error) {
+		cancel()
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	// the half-open slot is free again
+	_, err = cb.ExecuteContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestRequestTimeout(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{RequestTimeout: time.Duration(50) * time.Millisecond})
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	returned := make(chan struct{})
+	_, err := cb.Execute(func() (interface{}, error) {
+		defer close(returned)
+		time.Sleep(time.Duration(100) * time.Millisecond)
+		return nil, nil
+	})
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	// the late result is not counted again
+	<-returned
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	assert.Panics(t, func() { causePanic(cb) })
+	assert.Equal(t, newCounts(3, 1, 2, 0, 2), cb.Counts())
+
+	// slow requests trip the breaker
+	for i := 0; i < 4; i++ {
+		_, err = cb.Execute(func() (interface{}, error) {
+			time.Sleep(time.Duration(100) * time.Millisecond)
+			return nil, nil
+		})
+		assert.Equal(t, ErrRequestTimeout, err)
+	}
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestRequestTimeoutContext(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		RequestTimeout:      time.Duration(20) * time.Millisecond,
+		IgnoreContextErrors: true,
+	})
+	_, err := cb.ExecuteContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+
+	result, err := cb.ExecuteContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return "ok", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", result)
+}
+
+func TestWrappedErrors(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "wrapped"})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	err := succeed(cb)
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.Equal(t, `circuit breaker "wrapped" is open: circuit breaker is open`, err.Error())
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	err = succeed(cb)
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	assert.Contains(t, err.Error(), `"wrapped"`)
+	assert.Nil(t, <-ch)
+
+	tscb := NewTwoStepCircuitBreaker(Settings{Name: "tscb"})
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail2Step(tscb))
+	}
+	_, err = tscb.Allow()
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.Contains(t, err.Error(), `"tscb"`)
+}
+
+func TestTripAndReset(t *testing.T) {
+	var changes []stateTransition
+	cb := NewCircuitBreaker(Settings{
+		Name: "manual",
+		OnStateChange: func(name string, from State, to State) {
+			changes = append(changes, stateTransition{name, from, to})
+		},
+	})
+	assert.Nil(t, fail(cb))
+
+	cb.Trip()
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.True(t, errors.Is(succeed(cb), ErrOpenState))
+	assert.Equal(t, []stateTransition{{"manual", StateClosed, StateOpen}}, changes)
+
+	cb.Trip()
+	assert.Len(t, changes, 1)
+
+	// a manually tripped breaker still becomes half-open after Timeout
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	cb.Reset()
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, stateTransition{"manual", StateHalfOpen, StateClosed}, changes[len(changes)-1])
+
+	assert.Nil(t, fail(cb))
+	cb.Reset()
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Len(t, changes, 3)
+
+	tscb := NewTwoStepCircuitBreaker(Settings{})
+	tscb.Trip()
+	assert.Equal(t, StateOpen, tscb.State())
+	tscb.Reset()
+	assert.Equal(t, StateClosed, tscb.State())
+	assert.Nil(t, succeed2Step(tscb))
+}
+
+func TestForcedState(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.Equal(t, ErrInvalidForcedState, cb.SetForcedState(StateHalfOpen))
+
+	assert.Nil(t, cb.SetForcedState(StateOpen))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, DetailedState{State: StateOpen, Forced: true}, cb.DetailedState())
+	assert.True(t, errors.Is(succeed(cb), ErrOpenState))
+
+	// sticky across timeouts and manual transitions
+	pseudoSleep(cb, time.Duration(120)*time.Second)
+	assert.Equal(t, StateOpen, cb.State())
+	cb.Reset()
+	assert.Equal(t, StateOpen, cb.State())
+
+	assert.Nil(t, cb.SetForcedState(StateClosed))
+	assert.Equal(t, DetailedState{State: StateClosed, Forced: true}, cb.DetailedState())
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, newCounts(10, 0, 10, 0, 10), cb.Counts())
+	cb.Trip()
+	assert.Equal(t, StateClosed, cb.State())
+
+	cb.ClearForcedState()
+	assert.Equal(t, DetailedState{State: StateClosed}, cb.DetailedState())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// clearing a forced open state restarts the timeout
+	assert.Nil(t, cb.SetForcedState(StateOpen))
+	pseudoSleep(cb, time.Duration(120)*time.Second)
+	cb.ClearForcedState()
+	assert.Equal(t, StateOpen, cb.State())
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+}
+
+type testObserver struct {
+	results map[bool]int
+	changes []stateTransition
+}
+
+func (o *testObserver) ObserveResult(name string, success bool) {
+	o.results[success]++
+}
+
+func (o *testObserver) ObserveStateChange(name string, from State, to State) {
+	o.changes = append(o.changes, stateTransition{name, from, to})
+}
+
+func TestMetricsObserver(t *testing.T) {
+	o := &testObserver{results: map[bool]int{}}
+	cb := NewCircuitBreaker(Settings{
+		Name:            "observed",
+		Interval:        time.Duration(30) * time.Second,
+		MetricsObserver: o,
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, map[bool]int{true: 1, false: 1}, o.results)
+
+	// results of a previous generation are still observed once
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	cb.mutex.Lock()
+	pseudoSleep(cb, time.Duration(31)*time.Second)
+	cb.mutex.Unlock()
+	assert.Nil(t, <-ch)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Equal(t, map[bool]int{true: 2, false: 1}, o.results)
+
+	cb.Trip()
+	assert.Equal(t, []stateTransition{{"observed", StateClosed, StateOpen}}, o.changes)
+	assert.Error(t, succeed(cb))
+	assert.Equal(t, 3, o.results[true]+o.results[false])
+}
+
+func TestSlowCalls(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		SlowCallDuration: time.Duration(20) * time.Millisecond,
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.SlowCalls >= 2
+		},
+	})
+	slow := func() (interface{}, error) {
+		time.Sleep(time.Duration(30) * time.Millisecond)
+		return nil, nil
+	}
+
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, uint32(0), cb.Counts().SlowCalls)
+
+	_, err := cb.Execute(slow)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), cb.Counts().SlowCalls)
+	assert.Equal(t, StateClosed, cb.State())
+
+	// successful but slow calls trip the breaker
+	_, err = cb.ExecuteContext(context.Background(), func(context.Context) (interface{}, error) { return slow() })
+	assert.Nil(t, err)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// timeouts are slow calls too
+	cb = NewCircuitBreaker(Settings{
+		SlowCallDuration: time.Duration(10) * time.Millisecond,
+		RequestTimeout:   time.Duration(10) * time.Millisecond,
+	})
+	_, err = cb.Execute(slow)
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Equal(t, uint32(1), cb.Counts().SlowCalls)
+
+	cb = NewCircuitBreaker(Settings{})
+	_, err = cb.Execute(slow)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), cb.Counts().SlowCalls)
+}
+
+func TestOnStateChangeWithCounts(t *testing.T) {
+	var got []Counts
+	cb := NewCircuitBreaker(Settings{
+		OnStateChangeWithCounts: func(name string, from State, to State, counts Counts) {
+			got = append(got, counts)
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, []Counts{newCounts(7, 1, 6, 0, 6)}, got)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, []Counts{newCounts(7, 1, 6, 0, 6), newCounts(0, 0, 0, 0, 0), newCounts(1, 1, 0, 1, 0)}, got)
+}
+
+func TestOnReject(t *testing.T) {
+	var rejected []error
+	cb := NewCircuitBreaker(Settings{
+		Name: "reject",
+		OnReject: func(name string, err error) {
+			assert.Equal(t, "reject", name)
+			rejected = append(rejected, err)
+		},
+	})
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Error(t, succeed(cb))
+	assert.Error(t, fail(cb))
+	assert.Equal(t, uint32(2), cb.Counts().Rejections)
+	assert.Len(t, rejected, 2)
+	assert.True(t, errors.Is(rejected[0], ErrOpenState))
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, uint32(0), cb.Counts().Rejections) // reset with the generation
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Error(t, succeed(cb))
+	assert.Equal(t, uint32(1), cb.Counts().Rejections)
+	assert.True(t, errors.Is(rejected[2], ErrTooManyRequests))
+	assert.Nil(t, <-ch)
+}
+
+func TestClearConsecutive(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.Nil(t, succeed(cb))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	generation := cb.Generation()
+	ch := succeedLater(cb, time.Duration(20)*time.Millisecond)
+	time.Sleep(time.Duration(5) * time.Millisecond)
+
+	cb.ClearConsecutive()
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, generation, cb.Generation())
+	assert.Equal(t, newCounts(7, 1, 5, 0, 0), cb.Counts())
+
+	// the request in flight is still counted, and it takes 6 new consecutive failures to trip
+	assert.Nil(t, <-ch)
+	assert.Equal(t, newCounts(7, 2, 5, 1, 0), cb.Counts())
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestOnAdmit(t *testing.T) {
+	var admitted []State
+	var requests []uint32
+	var cb *CircuitBreaker
+	cb = NewCircuitBreaker(Settings{
+		Name: "admit",
+		OnAdmit: func(name string, state State) {
+			assert.Equal(t, "admit", name)
+			admitted = append(admitted, state)
+			requests = append(requests, cb.counts.Requests)
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Error(t, succeed(cb)) // rejected
+	assert.Len(t, admitted, 7)
+	assert.Equal(t, uint32(1), requests[0]) // counted before the callback
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Len(t, admitted, 8)
+	assert.Equal(t, StateClosed, admitted[0])
+	assert.Equal(t, StateHalfOpen, admitted[7])
+}
+
+func TestFallback(t *testing.T) {
+	var fallbackErr error
+	cb := NewCircuitBreaker(Settings{
+		Fallback: func(err error) (interface{}, error) {
+			fallbackErr = err
+			return "cached", nil
+		},
+	})
+
+	// errors of the request are not replaced
+	errReq := fmt.Errorf("fail")
+	for i := 0; i < 6; i++ {
+		_, err := cb.Execute(func() (interface{}, error) { return nil, errReq })
+		assert.Equal(t, errReq, err)
+	}
+	assert.Nil(t, fallbackErr)
+	assert.Equal(t, StateOpen, cb.State())
+
+	result, err := cb.Execute(func() (interface{}, error) { return "fresh", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	assert.True(t, errors.Is(fallbackErr, ErrOpenState))
+
+	fallbackErr = nil
+	result, err = cb.ExecuteContext(context.Background(), func(context.Context) (interface{}, error) { return "fresh", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	assert.True(t, errors.Is(fallbackErr, ErrOpenState))
+}
+
+func TestRejectionError(t *testing.T) {
+	errRejected := errors.New("rejected")
+	var states []State
+	var rejected []error
+	cb := NewCircuitBreaker(Settings{
+		RejectionError: func(state State, counts Counts) error {
+			states = append(states, state)
+			if counts.Rejections > 2 {
+				return nil // the default error
+			}
+			return errRejected
+		},
+		OnReject: func(name string, err error) {
+			rejected = append(rejected, err)
+		},
+	})
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	err := succeed(cb)
+	assert.Equal(t, "rejected", err.Error())
+	assert.True(t, errors.Is(err, errRejected))
+	assert.True(t, errors.Is(err, ErrOpenState)) // still recognized as a rejection
+	assert.Equal(t, err, rejected[0])
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	err = succeed(cb)
+	assert.True(t, errors.Is(err, errRejected))
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	assert.Nil(t, <-ch)
+	assert.Equal(t, []State{StateOpen, StateHalfOpen}, states)
+
+	cb.Trip()
+	for i := 0; i < 2; i++ {
+		assert.True(t, errors.Is(succeed(cb), errRejected))
+	}
+	err = succeed(cb)
+	assert.Equal(t, "circuit breaker \"\" is open: circuit breaker is open", err.Error())
+	assert.False(t, errors.Is(err, errRejected))
+
+	// Fallback still replaces the rejection
+	cb = NewCircuitBreaker(Settings{
+		InitialState:   StateOpen,
+		RejectionError: func(State, Counts) error { return errRejected },
+		Fallback:       func(err error) (interface{}, error) { return "cached", nil },
+	})
+	result, err := cb.Execute(func() (interface{}, error) { return "fresh", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+}
+
+func TestExpiresAt(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Interval: time.Duration(30) * time.Second})
+	closedExpiry := cb.ExpiresAt()
+	assert.False(t, closedExpiry.IsZero())
+	assert.True(t, closedExpiry.After(time.Now().Add(time.Duration(29)*time.Second)))
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	openExpiry := cb.ExpiresAt()
+	assert.True(t, openExpiry.After(time.Now().Add(time.Duration(59)*time.Second)))
+
+	// the deadline has passed, but ExpiresAt does not make the breaker half-open
+	pseudoSleep(cb, time.Duration(61)*time.Second)
+	assert.True(t, cb.ExpiresAt().Before(time.Now()))
+	assert.Equal(t, StateOpen, cb.state)
+
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.True(t, cb.ExpiresAt().IsZero())
+
+	assert.True(t, NewCircuitBreaker(Settings{}).ExpiresAt().IsZero())
+}
+
+func TestRecoverPanic(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{RecoverPanic: true})
+	err := causePanic(cb)
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "panic: oops\n"))
+	assert.Contains(t, err.Error(), "causePanic") // the stack trace of the panic
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+
+	_, err = cb.ExecuteContext(context.Background(), func(context.Context) (interface{}, error) { panic("oops") })
+	assert.True(t, strings.HasPrefix(err.Error(), "panic: oops\n"))
+	assert.Equal(t, newCounts(2, 0, 2, 0, 2), cb.Counts())
+
+	err = cb.ExecutePartial(func() (int, int, error) { panic("oops") })
+	assert.True(t, strings.HasPrefix(err.Error(), "panic: oops\n"))
+	assert.Equal(t, newCounts(3, 0, 3, 0, 3), cb.Counts())
+
+	cb = NewCircuitBreaker(Settings{RecoverPanic: true, RequestTimeout: time.Second})
+	err = causePanic(cb)
+	assert.True(t, strings.HasPrefix(err.Error(), "panic: oops\n"))
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+}
+
+func TestPanicCountsAsFailure(t *testing.T) {
+	panicCountsAsFailure := false
+	cb := NewCircuitBreaker(Settings{MaxRequests: 1, PanicCountsAsFailure: &panicCountsAsFailure})
+	assert.Panics(t, func() { causePanic(cb) })
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	// an ignored panic doesn't hold the half-open slot
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Panics(t, func() { causePanic(cb) })
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	cb = New(WithPanicCountsAsFailure(false))
+	cb.recoverPanic = true
+	err := cb.ExecutePartial(func() (int, int, error) { panic("oops") })
+	assert.True(t, strings.HasPrefix(err.Error(), "panic: oops\n"))
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	cb = New(WithPanicCountsAsFailure(true))
+	assert.Panics(t, func() { causePanic(cb) })
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+}
+
+func TestSuccessThreshold(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Settings{MaxRequests: 1, SuccessThreshold: 3})
+	tscb.Trip()
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, tscb.State())
+
+	// MaxRequests limits the requests in flight, not the requests in total
+	for i := 0; i < 2; i++ {
+		done, err := tscb.Allow()
+		assert.Nil(t, err)
+		_, err = tscb.Allow()
+		assert.True(t, errors.Is(err, ErrTooManyRequests))
+		done(true)
+		assert.Equal(t, StateHalfOpen, tscb.State())
+	}
+	assert.Nil(t, succeed2Step(tscb))
+	assert.Equal(t, StateClosed, tscb.State())
+
+	cb := NewCircuitBreaker(Settings{MaxRequests: 3, SuccessThreshold: 1})
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, 1.0, cb.Pressure())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestClassifyError(t *testing.T) {
+	errNotFound := errors.New("not found")
+	cb := NewCircuitBreaker(Settings{
+		MaxRequests: 1,
+		ClassifyError: func(err error) ErrorClass {
+			switch err {
+			case nil:
+				return ErrorClassSuccess
+			case errNotFound:
+				return ErrorClassIgnore
+			default:
+				return ErrorClassFailure
+			}
+		},
+	})
+	notFound := func() error {
+		_, err := cb.Execute(func() (interface{}, error) { return nil, errNotFound })
+		return err
+	}
+
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, errNotFound, notFound())
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, errNotFound, notFound())
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+	assert.Equal(t, errNotFound, cb.ExecutePartial(func() (int, int, error) { return 1, 0, errNotFound }))
+	assert.Equal(t, uint32(0), cb.Counts().TotalItems)
+
+	// an ignored request doesn't hold the half-open slot
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, errNotFound, notFound())
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	assert.Equal(t, "ignore", ErrorClassIgnore.String())
+}
+
+func TestBackoffExpiry(t *testing.T) {
+	var attempts []int
+	cb := NewCircuitBreaker(Settings{
+		BackoffExpiry: func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return ExponentialBackoff(time.Second, time.Duration(4)*time.Second, 0)(attempt)
+		},
+	})
+	openFor := func() time.Duration {
+		return cb.expiry.Sub(time.Now()).Round(time.Second)
+	}
+	reopen := func() {
+		pseudoSleep(cb, cb.expiry.Sub(time.Now())+time.Millisecond)
+		assert.Equal(t, StateHalfOpen, cb.State())
+		assert.Nil(t, fail(cb))
+	}
+
+	cb.Trip()
+	assert.Equal(t, time.Second, openFor())
+	reopen()
+	assert.Equal(t, time.Duration(2)*time.Second, openFor())
+	reopen()
+	assert.Equal(t, time.Duration(4)*time.Second, openFor())
+	reopen()
+	assert.Equal(t, time.Duration(4)*time.Second, openFor()) // capped
+	assert.Equal(t, []int{1, 2, 3, 4}, attempts)
+
+	// a successful close resets the backoff
+	pseudoSleep(cb, cb.expiry.Sub(time.Now())+time.Millisecond)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	cb.Trip()
+	assert.Equal(t, time.Second, openFor())
+	assert.Equal(t, []int{1, 2, 3, 4, 1}, attempts)
+
+	// clearing a forced open state restarts the same timeout instead of backing off
+	assert.Nil(t, cb.SetForcedState(StateOpen))
+	assert.Equal(t, time.Second, openFor())
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	cb.ClearForcedState()
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, time.Second, openFor())
+	assert.Equal(t, 1, cb.openAttempts)
+	assert.Equal(t, []int{1, 2, 3, 4, 1}, attempts)
+
+	// Timeout is used when BackoffExpiry returns 0
+	cb = NewCircuitBreaker(Settings{BackoffExpiry: func(int) time.Duration { return 0 }})
+	cb.Trip()
+	assert.Equal(t, defaultTimeout, openFor())
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 0, 0)
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, time.Duration(8)*time.Second, backoff(4))
+	assert.True(t, backoff(100) > 0) // no overflow without a cap
+
+	backoff = ExponentialBackoff(time.Second, time.Duration(10)*time.Second, 0.5)
+	for attempt := 1; attempt < 10; attempt++ {
+		d := backoff(attempt)
+		assert.True(t, d <= time.Duration(10)*time.Second)
+		assert.True(t, d >= time.Duration(500)*time.Millisecond)
+	}
+	d := backoff(10)
+	assert.True(t, d >= time.Duration(5)*time.Second && d <= time.Duration(10)*time.Second)
+}
+
+func TestFailureRate(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{HalfLife: time.Duration(10) * time.Second})
+	start := time.Now()
+	assert.Equal(t, 0.0, cb.failureRate(start))
+
+	for i := 0; i < 3; i++ {
+		cb.replay(false, start)
+	}
+	cb.replay(true, start)
+	assert.InDelta(t, 0.75, cb.failureRate(start), 1e-9)
+	// decay alone doesn't change the rate
+	assert.InDelta(t, 0.75, cb.failureRate(start.Add(time.Minute)), 1e-9)
+
+	// one half-life later the old failures weigh 1.5 against 1 + 0.5 successes
+	cb.replay(true, start.Add(time.Duration(10)*time.Second))
+	assert.InDelta(t, 1.5/3, cb.failureRate(start.Add(time.Duration(10)*time.Second)), 1e-9)
+
+	// without HalfLife every result weighs the same
+	cb = NewCircuitBreaker(Settings{})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Nil(t, succeed(cb))
+	assert.InDelta(t, 0.75, cb.FailureRate(), 1e-9)
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Settings{MaxConcurrent: 2})
+	done1, err := tscb.Allow()
+	assert.Nil(t, err)
+	done2, err := tscb.Allow()
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.True(t, errors.Is(err, ErrTooManyConcurrent))
+	assert.Equal(t, uint32(1), tscb.Counts().Rejections)
+
+	// a request finishing after the generation changed still frees its slot, once
+	tscb.Trip()
+	tscb.Reset()
+	done1(true)
+	done2(false)
+	assert.Equal(t, uint32(0), tscb.cb.inFlight)
+	assert.Nil(t, succeed2Step(tscb))
+	assert.Nil(t, succeed2Step(tscb))
+
+	// a panic frees the slot too
+	cb := NewCircuitBreaker(Settings{MaxConcurrent: 1})
+	assert.Panics(t, func() { causePanic(cb) })
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, uint32(0), cb.inFlight)
+
+	called := false
+	block := make(chan struct{})
+	go cb.Execute(func() (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	for {
+		cb.mutex.Lock()
+		n := cb.inFlight
+		cb.mutex.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err = cb.Execute(func() (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	assert.True(t, errors.Is(err, ErrTooManyConcurrent))
+	assert.False(t, called)
+	close(block)
+}
+
+func TestStateSince(t *testing.T) {
+	start := time.Now()
+	cb := NewCircuitBreaker(Settings{})
+	created := cb.StateSince()
+	assert.False(t, created.Before(start))
+	assert.True(t, cb.StateDuration() >= 0)
+
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.True(t, cb.StateDuration() >= time.Duration(10)*time.Millisecond)
+	assert.Nil(t, fail(cb)) // requests don't change it
+	assert.Equal(t, created, cb.StateSince())
+
+	cb.Trip()
+	opened := cb.StateSince()
+	assert.True(t, opened.After(created))
+	assert.True(t, cb.StateDuration() < time.Duration(10)*time.Millisecond)
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.True(t, cb.StateSince().After(opened))
+	assert.Equal(t, StateHalfOpen, cb.state)
+}
+
+func TestWouldSucceed(t *testing.T) {
+	errNotFound := errors.New("not found")
+	cb := NewCircuitBreaker(Settings{IsSuccessful: func(err error) bool { return err == nil || err == errNotFound }})
+	assert.True(t, cb.WouldSucceed(nil))
+	assert.True(t, cb.WouldSucceed(errNotFound))
+	assert.False(t, cb.WouldSucceed(errors.New("fail")))
+	assert.Equal(t, ErrorClassFailure, cb.Classify(errors.New("fail")))
+
+	cb = NewCircuitBreaker(Settings{
+		IgnoreContextCancellation: true,
+		ClassifyError: func(err error) ErrorClass {
+			switch err {
+			case nil:
+				return ErrorClassSuccess
+			case errNotFound:
+				return ErrorClassIgnore
+			default:
+				return ErrorClassFailure
+			}
+		},
+	})
+	assert.Equal(t, ErrorClassSuccess, cb.Classify(nil))
+	assert.Equal(t, ErrorClassIgnore, cb.Classify(errNotFound))
+	assert.Equal(t, ErrorClassIgnore, cb.Classify(context.Canceled))
+	assert.Equal(t, ErrorClassFailure, cb.Classify(errors.New("fail")))
+	assert.False(t, cb.WouldSucceed(errNotFound))
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+}
+
+func TestTwoStepAllowContext(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Settings{InitialState: StateHalfOpen})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done, err := tscb.AllowContext(ctx)
+	assert.Nil(t, done)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), tscb.Counts())
+
+	// the half-open slot is still free
+	done, err = tscb.AllowContext(context.Background())
+	assert.Nil(t, err)
+	_, err = tscb.AllowContext(context.Background())
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	done(true)
+	assert.Equal(t, StateClosed, tscb.State())
+}
+
+func TestTwoStepAllowErr(t *testing.T) {
+	errNotFound := errors.New("not found")
+	tscb := NewTwoStepCircuitBreaker(Settings{
+		ClassifyError: func(err error) ErrorClass {
+			switch err {
+			case nil:
+				return ErrorClassSuccess
+			case errNotFound:
+				return ErrorClassIgnore
+			default:
+				return ErrorClassFailure
+			}
+		},
+		SlowCallDuration: time.Duration(5) * time.Millisecond,
+	})
+
+	done, err := tscb.AllowErr()
+	assert.Nil(t, err)
+	done(nil)
+	done, _ = tscb.AllowErr()
+	done(errNotFound)
+	done, _ = tscb.AllowErr()
+	time.Sleep(time.Duration(5) * time.Millisecond)
+	done(errors.New("fail"))
+	assert.Equal(t, Counts{Requests: 2, TotalSuccesses: 1, TotalFailures: 1, ConsecutiveFailures: 1, SlowCalls: 1}, tscb.Counts())
+
+	tscb.Trip()
+	_, err = tscb.AllowErr()
+	assert.True(t, errors.Is(err, ErrOpenState))
+}
+
+func TestTwoStepExecute(t *testing.T) {
+	errFailed := errors.New("fail")
+	tscb := NewTwoStepCircuitBreaker(Settings{
+		IsSuccessfulResult: func(result interface{}, err error) bool {
+			return err == nil && result != "bad"
+		},
+		Fallback: func(err error) (interface{}, error) {
+			return "cached", nil
+		},
+	})
+
+	result, err := tscb.Execute(func() (interface{}, error) { return "ok", nil })
+	assert.Equal(t, "ok", result)
+	assert.Nil(t, err)
+	result, err = tscb.Execute(func() (interface{}, error) { return "bad", nil })
+	assert.Equal(t, "bad", result)
+	assert.Nil(t, err)
+	_, err = tscb.Execute(func() (interface{}, error) { return nil, errFailed })
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, newCounts(3, 1, 2, 0, 2), tscb.Counts())
+
+	_, err = tscb.Execute(nil)
+	assert.Equal(t, ErrNilRequest, err)
+	assert.Equal(t, newCounts(3, 1, 2, 0, 2), tscb.Counts())
+
+	assert.Panics(t, func() {
+		tscb.Execute(func() (interface{}, error) { panic("oops") })
+	})
+	assert.Equal(t, newCounts(4, 1, 3, 0, 3), tscb.Counts())
+
+	// 被拒绝时与 CircuitBreaker.Execute 一样使用 Fallback
+	tscb.Trip()
+	result, err = tscb.Execute(func() (interface{}, error) { return "ok", nil })
+	assert.Equal(t, "cached", result)
+	assert.Nil(t, err)
+}
+
+func TestIgnoreContextCancellation(t *testing.T) {
+	errNotFound := errors.New("not found")
+	cb := NewCircuitBreaker(Settings{
+		IgnoreContextCancellation: true,
+		ClassifyError: func(err error) ErrorClass {
+			if err == errNotFound {
+				return ErrorClassSuccess
+			}
+			return ErrorClassFailure
+		},
+	})
+	for _, err := range []error{context.Canceled, fmt.Errorf("query: %w", context.DeadlineExceeded)} {
+		_, got := cb.Execute(func() (interface{}, error) { return nil, err })
+		assert.Equal(t, err, got)
+	}
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	// other errors are still classified by ClassifyError
+	_, err := cb.Execute(func() (interface{}, error) { return nil, errNotFound })
+	assert.Equal(t, errNotFound, err)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	// the deadline of RequestTimeout is counted as a failure
+	cb = NewCircuitBreaker(Settings{IgnoreContextCancellation: true, RequestTimeout: time.Duration(10) * time.Millisecond})
+	_, err = cb.ExecuteContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.Error(t, err)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+}
+
+func TestOnSuccessOnFailure(t *testing.T) {
+	var successes []Counts
+	var failures []Counts
+	var errs []error
+	cb := NewCircuitBreaker(Settings{
+		Name: "hooks",
+		OnSuccess: func(name string, counts Counts) {
+			assert.Equal(t, "hooks", name)
+			successes = append(successes, counts)
+		},
+		OnFailure: func(name string, counts Counts, err error) {
+			assert.Equal(t, "hooks", name)
+			failures = append(failures, counts)
+			errs = append(errs, err)
+		},
+	})
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, []Counts{newCounts(1, 1, 0, 1, 0)}, successes)
+	assert.Equal(t, []Counts{newCounts(2, 1, 1, 0, 1)}, failures)
+	assert.EqualError(t, errs[0], "fail")
+
+	// the failure that trips the breaker is reported with the counts before they are cleared
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, newCounts(7, 1, 6, 0, 6), failures[len(failures)-1])
+
+	// rejections are not reported
+	assert.Error(t, succeed(cb))
+	assert.Len(t, successes, 1)
+	assert.Len(t, failures, 6)
+
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Len(t, failures, 7)
+	assert.Equal(t, newCounts(1, 0, 0, 0, 0), failures[6])
+
+	// outcomes of a stale generation are not reported
+	var reported int
+	tscb := NewTwoStepCircuitBreaker(Settings{OnSuccess: func(string, Counts) { reported++ }})
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	tscb.cb.Trip()
+	done(true)
+	assert.Equal(t, 0, reported)
+}
+
+func TestInitialState(t *testing.T) {
+	var changes int
+	cb := NewCircuitBreaker(Settings{
+		InitialState:  StateOpen,
+		OnStateChange: func(string, State, State) { changes++ },
+	})
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, 1, cb.Snapshot().OpenAttempts)
+	assert.True(t, errors.Is(succeed(cb), ErrOpenState))
+
+	pseudoSleep(cb, time.Duration(59)*time.Second)
+	assert.Equal(t, StateOpen, cb.State())
+	pseudoSleep(cb, time.Duration(1)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, 2, changes)
+
+	cb = NewCircuitBreaker(Settings{InitialState: StateHalfOpen})
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.True(t, cb.ExpiresAt().IsZero())
+
+	assert.Equal(t, StateClosed, NewCircuitBreaker(Settings{}).State())
+	assert.Panics(t, func() { NewCircuitBreaker(Settings{InitialState: State(3)}) })
+}
+
+func TestDrain(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "draining"})
+	assert.Nil(t, cb.Drain(context.Background()))
+	err := succeed(cb)
+	assert.True(t, errors.Is(err, ErrDraining))
+	assert.Equal(t, `circuit breaker "draining": circuit breaker is draining`, err.Error())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	cb = NewCircuitBreaker(Settings{})
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+
+	// the request in flight outlives a short wait
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, cb.Drain(ctx))
+	assert.True(t, errors.Is(succeed(cb), ErrDraining))
+	assert.Equal(t, DetailedState{State: StateClosed, Draining: true}, cb.DetailedState())
+
+	done := make(chan error, 1)
+	go func() { done <- cb.Drain(context.Background()) }()
+	assert.Nil(t, <-ch)
+	assert.Nil(t, <-done)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+	assert.Equal(t, DetailedState{State: StateClosed, Draining: true, Drained: true}, cb.DetailedState())
+
+	// a draining CircuitBreaker keeps its own state
+	cb.Trip()
+	assert.Equal(t, DetailedState{State: StateOpen, Draining: true, Drained: true}, cb.DetailedState())
+
+	tscb := NewTwoStepCircuitBreaker(Settings{})
+	callback, err := tscb.Allow()
+	assert.Nil(t, err)
+	go func() { done <- tscb.Drain(context.Background()) }()
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	_, err = tscb.Allow()
+	assert.True(t, errors.Is(err, ErrDraining))
+	callback(true)
+	assert.Nil(t, <-done)
+}
+
+func TestClose(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "closing"})
+	ch := cb.Subscribe()
+	assert.Nil(t, succeed(cb))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, cb.Close())
+		}()
+	}
+	wg.Wait()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, ok = <-cb.Subscribe()
+	assert.False(t, ok)
+
+	err := succeed(cb)
+	assert.True(t, errors.Is(err, ErrBreakerClosed))
+	assert.EqualError(t, err, `circuit breaker "closing": circuit breaker is closed`)
+	_, err = cb.ExecuteContext(context.Background(), func(context.Context) (interface{}, error) { return nil, nil })
+	assert.True(t, errors.Is(err, ErrBreakerClosed))
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	tscb := NewTwoStepCircuitBreaker(Settings{})
+	assert.Nil(t, tscb.Close())
+	_, err = tscb.Allow()
+	assert.True(t, errors.Is(err, ErrBreakerClosed))
+}
+
+func TestGenerationAccessor(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Interval: time.Duration(30) * time.Second})
+	assert.Equal(t, uint64(1), cb.Generation())
+
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, uint64(1), cb.Generation())
+
+	pseudoSleep(cb, time.Duration(30)*time.Second)
+	assert.Equal(t, uint64(2), cb.Generation()) // counts cleared by Interval
+
+	cb.Trip()
+	assert.Equal(t, uint64(3), cb.Generation())
+
+	// the outcome of a request started in an earlier generation is dropped
+	tscb := NewTwoStepCircuitBreaker(Settings{})
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+	before := tscb.Generation()
+	tscb.Trip()
+	tscb.Reset()
+	assert.Equal(t, before+2, tscb.Generation())
+	done(false)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), tscb.Counts())
+}
+
+func TestHalfOpenWait(t *testing.T) {
+	// a waiting request takes the slot freed by the request in flight
+	cb := NewCircuitBreaker(Settings{SuccessThreshold: 2, HalfOpenWait: time.Second})
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, <-ch)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, uint32(0), cb.Counts().Rejections)
+
+	// a waiting request is rejected when the request in flight opens the CircuitBreaker
+	cb = NewCircuitBreaker(Settings{HalfOpenWait: time.Second})
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	failed := make(chan error)
+	go func() {
+		_, err := cb.Execute(func() (interface{}, error) {
+			time.Sleep(time.Duration(50) * time.Millisecond)
+			return nil, errors.New("fail")
+		})
+		failed <- err
+	}()
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.True(t, errors.Is(succeed(cb), ErrOpenState))
+	assert.Error(t, <-failed)
+
+	// a waiting request is rejected after HalfOpenWait
+	cb = NewCircuitBreaker(Settings{HalfOpenWait: time.Duration(20) * time.Millisecond})
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	ch = succeedLater(cb, time.Duration(200)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	start := time.Now()
+	assert.True(t, errors.Is(succeed(cb), ErrTooManyRequests))
+	assert.True(t, time.Since(start) >= time.Duration(10)*time.Millisecond)
+	assert.Nil(t, <-ch)
+}
+
+func TestFairHalfOpen(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Settings{SuccessThreshold: 10, HalfOpenWait: time.Second, FairHalfOpen: true})
+	cb := tscb.cb
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	queued := func(n int) {
+		for {
+			cb.mutex.Lock()
+			l := len(cb.halfOpenQueue)
+			cb.mutex.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	done, err := tscb.Allow()
+	assert.Nil(t, err)
+
+	// the waiting requests get the slot in the order in which they arrived
+	order := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		i := i
+		go func() {
+			done, err := tscb.Allow()
+			assert.Nil(t, err)
+			order <- i
+			done(true)
+		}()
+		queued(i + 1)
+	}
+	done(true)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, i, <-order)
+	}
+	queued(0)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// a request whose wait runs out leaves the queue
+	cb.Reconfigure(Settings{SuccessThreshold: 10, HalfOpenWait: time.Duration(20) * time.Millisecond, FairHalfOpen: true})
+	done, err = tscb.Allow()
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	queued(0)
+	done(true)
+}
+
+func TestLastRejection(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.True(t, cb.LastRejection().IsZero())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	before := time.Now()
+	assert.Error(t, succeed(cb))
+	assert.Error(t, succeed(cb))
+	last := cb.LastRejection()
+	assert.False(t, last.Before(before))
+	assert.Equal(t, uint32(2), cb.Counts().Rejections)
+
+	// each open period reports its own rejections, but the time of the last one is kept
+	cb.Reset()
+	cb.Trip()
+	assert.Equal(t, uint32(0), cb.Counts().Rejections)
+	assert.Equal(t, last, cb.LastRejection())
+	assert.Error(t, succeed(cb))
+	assert.Equal(t, uint32(1), cb.Counts().Rejections)
+	assert.False(t, cb.LastRejection().Before(last))
+}
+
+func TestBeforeStateChange(t *testing.T) {
+	clock := &testClock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	maintenance := true
+	var asked, changes []stateTransition
+	cb := NewCircuitBreaker(Settings{
+		Clock: clock,
+		BeforeStateChange: func(from State, to State) bool {
+			asked = append(asked, stateTransition{"", from, to})
+			return !maintenance || to != StateClosed
+		},
+		OnStateChange: func(name string, from State, to State) {
+			changes = append(changes, stateTransition{name, from, to})
+		},
+	})
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// closing is vetoed: the half-open state starts over with new probes
+	clock.advance(61 * time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	generation := cb.Generation()
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, generation+1, cb.Generation())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Nil(t, succeed(cb)) // a new probe is admitted
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// manual changes are vetoed too, but SetForcedState is not
+	cb.Reset()
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, cb.SetForcedState(StateClosed))
+	assert.Equal(t, StateClosed, cb.State())
+	cb.ClearForcedState()
+
+	maintenance = false
+	cb.Trip()
+	clock.advance(61 * time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	assert.Equal(t, []stateTransition{
+		{"", StateClosed, StateOpen},
+		{"", StateOpen, StateHalfOpen},
+		{"", StateHalfOpen, StateClosed},
+		{"", StateHalfOpen, StateClosed},
+		{"", StateHalfOpen, StateClosed},
+		{"", StateClosed, StateOpen},
+		{"", StateOpen, StateHalfOpen},
+		{"", StateHalfOpen, StateClosed},
+	}, asked)
+	assert.Equal(t, []stateTransition{
+		{"", StateClosed, StateOpen},
+		{"", StateOpen, StateHalfOpen},
+		{"", StateHalfOpen, StateClosed}, // forced
+		{"", StateClosed, StateOpen},
+		{"", StateOpen, StateHalfOpen},
+		{"", StateHalfOpen, StateClosed},
+	}, changes)
+
+	// a vetoed transition to half-open waits another Timeout
+	cb = NewCircuitBreaker(Settings{
+		Clock:             clock,
+		InitialState:      StateOpen,
+		BeforeStateChange: func(from State, to State) bool { return to != StateHalfOpen },
+	})
+	clock.advance(61 * time.Second)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, clock.Now().Add(60*time.Second), cb.ExpiresAt())
+	assert.Equal(t, 1, cb.Snapshot().OpenAttempts)
+}
+
+func TestTripCount(t *testing.T) {
+	clock := &testClock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cb := NewCircuitBreaker(Settings{Clock: clock})
+	assert.Equal(t, uint64(0), cb.TripCount())
+	assert.True(t, cb.LastTrippedAt().IsZero())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, uint64(1), cb.TripCount())
+	assert.Equal(t, clock.Now(), cb.LastTrippedAt())
+
+	// a failure in the half-open state trips again
+	clock.advance(61 * time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, uint64(2), cb.TripCount())
+	assert.Equal(t, clock.Now(), cb.LastTrippedAt())
+
+	// the count survives Reset and new generations
+	cb.Reset()
+	assert.Equal(t, uint64(2), cb.TripCount())
+	cb.Trip()
+	cb.Trip() // already open
+	assert.Nil(t, cb.SetForcedState(StateOpen))
+	assert.Equal(t, uint64(3), cb.TripCount())
+
+	assert.Equal(t, uint64(0), NewCircuitBreaker(Settings{InitialState: StateOpen}).TripCount())
+}
+
+func TestEvaluateOnSuccess(t *testing.T) {
+	// ReadyToTrip is only called on failures by default, so successful requests never trip
+	st := Settings{
+		SlowCallDuration: time.Hour,
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.Requests >= 3
+		},
+	}
+	cb := NewCircuitBreaker(st)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.Equal(t, StateClosed, cb.State())
+
+	st.EvaluateOnSuccess = true
+	cb = NewCircuitBreaker(st)
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestHalfOpenAdmitRatio(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{InitialState: StateHalfOpen, SuccessThreshold: 20, HalfOpenAdmitRatio: 0.1, RandFunc: SeededRand(1)})
+	admitted := func() int {
+		cb.mutex.Lock()
+		defer cb.mutex.Unlock()
+
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if cb.admitHalfOpen() {
+				n++
+			}
+		}
+		return n
+	}
+
+	n := admitted()
+	assert.True(t, n > 50 && n < 150, n)
+	cb.counts.ConsecutiveSuccesses = 4
+	n = admitted()
+	assert.True(t, n > 400 && n < 600, n)
+	cb.counts.ConsecutiveSuccesses = 9
+	assert.Equal(t, 1000, admitted())
+	cb.counts.ConsecutiveSuccesses = 0
+
+	// rejected requests are not failures, and the ramp ends in the closed state
+	var rejected int
+	for i := 0; i < 1000 && cb.State() == StateHalfOpen; i++ {
+		if err := succeed(cb); err != nil {
+			assert.True(t, errors.Is(err, ErrTooManyRequests))
+			rejected++
+			assert.Equal(t, uint32(0), cb.Counts().TotalFailures)
+		}
+	}
+	assert.Equal(t, StateClosed, cb.State())
+	assert.True(t, rejected > 0)
+
+	// a failure opens the CircuitBreaker as usual
+	cb = NewCircuitBreaker(Settings{InitialState: StateHalfOpen, HalfOpenAdmitRatio: 1})
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestHalfOpenSuccessRatio(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{HalfOpenSuccessRatio: 0.8, HalfOpenSampleSize: 10})
+	halfOpen := func() {
+		cb.Trip()
+		pseudoSleep(cb, time.Duration(60)*time.Second)
+		assert.Equal(t, StateHalfOpen, cb.State())
+	}
+
+	// 2 failures out of 10 are tolerated
+	halfOpen()
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, succeed(cb))
+		assert.Equal(t, StateHalfOpen, cb.State())
+	}
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// the third failure makes 8 successes out of 10 impossible
+	halfOpen()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// the default sample size is 10
+	cb = NewCircuitBreaker(Settings{HalfOpenSuccessRatio: 1})
+	halfOpen()
+	for i := 0; i < 9; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestClearAfterConsecutiveSuccesses(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		Interval:                       time.Duration(30) * time.Second,
+		ClearAfterConsecutiveSuccesses: 3,
+	})
+
+	// cleared by consecutive successes, which restarts the interval
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, newCounts(3, 2, 1, 2, 0), cb.Counts())
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Equal(t, uint64(2), cb.Generation())
+
+	pseudoSleep(cb, time.Duration(29)*time.Second)
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+
+	// cleared by the interval before reaching the consecutive successes
+	assert.Nil(t, succeed(cb))
+	pseudoSleep(cb, time.Duration(1)*time.Second)
+	assert.Equal(t, uint64(3), cb.Generation())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	// not in the half-open state
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestCountsRatios(t *testing.T) {
+	assert.Equal(t, 0.0, Counts{}.FailureRatio())
+	assert.Equal(t, 0.0, Counts{}.SuccessRatio())
+
+	c := newCounts(4, 1, 2, 0, 2) // 1 request in flight
+	assert.Equal(t, 0.5, c.FailureRatio())
+	assert.Equal(t, 0.25, c.SuccessRatio())
+}
+
+func TestCountsAdd(t *testing.T) {
+	a := Counts{Requests: 3, TotalSuccesses: 1, TotalFailures: 2, ConsecutiveFailures: 2, SlowCalls: 1, Rejections: 4}
+	b := Counts{Requests: 5, TotalSuccesses: 5, ConsecutiveSuccesses: 5, TotalItems: 10, SucceededItems: 9}
+	assert.Equal(t, Counts{
+		Requests:             8,
+		TotalSuccesses:       6,
+		TotalFailures:        2,
+		ConsecutiveSuccesses: 5,
+		ConsecutiveFailures:  2,
+		TotalItems:           10,
+		SucceededItems:       9,
+		SlowCalls:            1,
+		Rejections:           4,
+	}, a.Add(b))
+	assert.Equal(t, uint32(3), a.Requests) // unchanged
+
+	// saturates instead of wrapping around
+	big := Counts{Requests: math.MaxUint32 - 1}
+	assert.Equal(t, uint32(math.MaxUint32), big.Add(a).Requests)
+
+	assert.Equal(t, Counts{}, MergeCounts())
+	assert.Equal(t, a.Add(b).Add(a), MergeCounts(a, b, a))
+}
+
+func TestStats(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Interval: time.Duration(30) * time.Second})
+	assert.Equal(t, Stats{Counts: newCounts(0, 0, 0, 0, 0), Expiry: cb.ExpiresAt()}, cb.Stats())
+
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	stats := cb.Stats()
+	assert.Equal(t, StateClosed, stats.State)
+	assert.Equal(t, newCounts(4, 1, 3, 0, 3), stats.Counts)
+	assert.Equal(t, 0.75, stats.FailureRatio)
+	assert.Equal(t, 0.25, stats.SuccessRatio)
+
+	cb.Trip()
+	stats = cb.Stats()
+	assert.Equal(t, StateOpen, stats.State)
+	assert.Equal(t, 0.0, stats.FailureRatio)
+	assert.True(t, stats.Expiry.Equal(cb.ExpiresAt()))
+}
+
+func TestIntervalJitter(t *testing.T) {
+	interval := time.Duration(30) * time.Second
+	jitter := time.Duration(5) * time.Second
+	cb := NewCircuitBreaker(Settings{Interval: interval, IntervalJitter: jitter})
+
+	expiries := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		before := time.Now()
+		cb.Reset()
+		d := cb.ExpiresAt().Sub(before)
+		assert.True(t, d >= interval-jitter && d <= interval+jitter+time.Second, d)
+		expiries[d.Round(time.Millisecond)] = true
+	}
+	assert.True(t, len(expiries) > 1)
+
+	// limited to half of Interval
+	cb = NewCircuitBreaker(Settings{Interval: interval, IntervalJitter: time.Hour})
+	assert.Equal(t, interval/2, cb.intervalJitter)
+
+	// ignored without Interval
+	cb = NewCircuitBreaker(Settings{IntervalJitter: jitter})
+	assert.True(t, cb.ExpiresAt().IsZero())
+}
+
+func TestRandFunc(t *testing.T) {
+	clock := &testClock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	interval := time.Duration(30) * time.Second
+	jitter := time.Duration(5) * time.Second
+	expiry := func(r float64) time.Duration {
+		cb := NewCircuitBreaker(Settings{Clock: clock, Interval: interval, IntervalJitter: jitter, RandFunc: func() float64 { return r }})
+		return cb.ExpiresAt().Sub(clock.Now())
+	}
+	assert.Equal(t, interval-jitter, expiry(0))
+	assert.Equal(t, interval, expiry(0.5))
+	assert.Equal(t, interval+jitter, expiry(math.Nextafter(1, 0)))
+
+	// the same seed gives the same sequence
+	a, b := SeededRand(42), SeededRand(42)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, a(), b())
+	}
+	seeded := func() []time.Duration {
+		cb := NewCircuitBreaker(Settings{Clock: clock, Interval: interval, IntervalJitter: jitter, RandFunc: SeededRand(7)})
+		var expiries []time.Duration
+		for i := 0; i < 5; i++ {
+			cb.Reset()
+			expiries = append(expiries, cb.ExpiresAt().Sub(clock.Now()))
+		}
+		return expiries
+	}
+	assert.Equal(t, seeded(), seeded())
+
+	backoff := ExponentialBackoffRand(time.Second, 0, 0.5, func() float64 { return 0.5 })
+	assert.Equal(t, time.Duration(750)*time.Millisecond, backoff(1))
+	assert.Equal(t, time.Duration(3)*time.Second, backoff(3))
+}
+
+func TestExecuteWith(t *testing.T) {
+	errNotFound := errors.New("not found")
+	cb := NewCircuitBreaker(Settings{RequestTimeout: time.Second})
+
+	// zero CallOptions behave like Execute
+	_, err := cb.ExecuteWith(CallOptions{}, func() (interface{}, error) { return nil, errNotFound })
+	assert.Equal(t, errNotFound, err)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+
+	// per-call IsSuccessful
+	notFoundOK := CallOptions{IsSuccessful: func(err error) bool { return err == nil || err == errNotFound }}
+	_, err = cb.ExecuteWith(notFoundOK, func() (interface{}, error) { return nil, errNotFound })
+	assert.Equal(t, errNotFound, err)
+	assert.Equal(t, newCounts(2, 1, 1, 1, 0), cb.Counts())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, newCounts(3, 1, 2, 0, 1), cb.Counts())
+
+	// per-call timeout
+	_, err = cb.ExecuteWith(CallOptions{Timeout: time.Duration(10) * time.Millisecond}, func() (interface{}, error) {
+		time.Sleep(time.Duration(100) * time.Millisecond)
+		return nil, nil
+	})
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Equal(t, newCounts(4, 1, 3, 0, 2), cb.Counts())
+
+	// no timeout
+	result, err := cb.ExecuteWith(CallOptions{Timeout: -1}, func() (interface{}, error) { return "done", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "done", result)
+
+	// per-call fallback
+	cb.Trip()
+	result, err = cb.ExecuteWith(CallOptions{Fallback: func(err error) (interface{}, error) {
+		return "cached", nil
+	}}, func() (interface{}, error) { return "fresh", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", result)
+	_, err = cb.ExecuteWith(CallOptions{}, func() (interface{}, error) { return "fresh", nil })
+	assert.True(t, errors.Is(err, ErrOpenState))
+}
+
+func TestOnHalfOpen(t *testing.T) {
+	var events []string
+	cb := NewCircuitBreaker(Settings{
+		Name:        "probing",
+		MaxRequests: 3,
+		OnStateChange: func(name string, from State, to State) {
+			events = append(events, from.String()+"->"+to.String())
+		},
+		OnHalfOpen: func(name string, probeSlots uint32) {
+			events = append(events, fmt.Sprintf("%s probing with %d slots", name, probeSlots))
+		},
+	})
+
+	cb.Trip()
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, []string{"closed->open", "open->half-open", "probing probing with 3 slots", "half-open->open"}, events)
+
+	cb = NewCircuitBreaker(Settings{OnHalfOpen: func(string, uint32) { t.Fatal("unexpected call") }})
+	assert.Nil(t, succeed(cb))
+}
+
+func TestNilRequest(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{InitialState: StateHalfOpen})
+
+	_, err := cb.Execute(nil)
+	assert.Equal(t, ErrNilRequest, err)
+	_, err = cb.ExecuteContext(context.Background(), nil)
+	assert.Equal(t, ErrNilRequest, err)
+	_, err = cb.ExecuteWith(CallOptions{}, nil)
+	assert.Equal(t, ErrNilRequest, err)
+	assert.Equal(t, ErrNilRequest, cb.ExecutePartial(nil))
+	assert.Equal(t, ErrNilRequest, cb.Do(nil))
+
+	// the half-open slot is still free
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestDo(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	errReq := errors.New("write failed")
+	calls := 0
+
+	assert.Nil(t, cb.Do(func() error { calls++; return nil }))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, errReq, cb.Do(func() error { calls++; return errReq }))
+	}
+	assert.Equal(t, newCounts(6, 1, 5, 0, 5), cb.Counts())
+	assert.Equal(t, errReq, cb.Do(func() error { calls++; return errReq }))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// rejected without calling req
+	assert.True(t, errors.Is(cb.Do(func() error { calls++; return nil }), ErrOpenState))
+	assert.Equal(t, 7, calls)
+
+	cb = NewCircuitBreaker(Settings{
+		InitialState: StateOpen,
+		Fallback:     func(err error) (interface{}, error) { return "ignored", nil },
+	})
+	assert.Nil(t, cb.Do(func() error { return errReq }))
+}
+
+func TestTwoStepCallbackTimeout(t *testing.T) {
+	logger := &recordingLogger{}
+	tscb := NewTwoStepCircuitBreaker(Settings{
+		Name:                   "forgetful",
+		InitialState:           StateHalfOpen,
+		TwoStepCallbackTimeout: time.Duration(20) * time.Millisecond,
+		Logger:                 logger,
+	})
+
+	// done is never called: the request is counted as a failure and the slot is freed
+	_, err := tscb.Allow()
+	assert.Nil(t, err)
+	_, err = tscb.Allow()
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	assert.Equal(t, StateOpen, tscb.State())
+	tscb.cb.mutex.Lock()
+	assert.Equal(t, `WARN circuit breaker callback not called: name="forgetful" timeout=20ms`, logger.entries[1])
+	tscb.cb.mutex.Unlock()
+
+	// done called in time cancels the timer, and a second call has no effect
+	tscb.Reset()
+	done, err := tscb.AllowErr()
+	assert.Nil(t, err)
+	done(nil)
+	done(errors.New("fail"))
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), tscb.Counts())
+
+	// done called too late has no effect
+	late, err := tscb.Allow()
+	assert.Nil(t, err)
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	late(true)
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), tscb.Counts())
+}
+
+func TestTripImmediately(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	cb := NewCircuitBreaker(Settings{
+		HalfOpenSuccessRatio: 0.5,
+		TripImmediately: func(err error) bool {
+			return errors.Is(err, errRefused)
+		},
+	})
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateClosed, cb.State())
+	_, err := cb.Execute(func() (interface{}, error) { return nil, fmt.Errorf("dial: %w", errRefused) })
+	assert.True(t, errors.Is(err, errRefused))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// not in the half-open state, where the success ratio decides
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	_, err = cb.Execute(func() (interface{}, error) { return nil, errRefused })
+	assert.Equal(t, errRefused, err)
+	assert.Equal(t, StateHalfOpen, cb.State())
+}
+
+// fakeBreaker 是调用方测试中代替 CircuitBreaker 的 Breaker
+type fakeBreaker struct {
+	err error
+}
+
+func (fakeBreaker) Name() string   { return "fake" }
+func (fakeBreaker) State() State   { return StateOpen }
+func (fakeBreaker) Counts() Counts { return Counts{} }
+func (f fakeBreaker) Execute(req func() (interface{}, error)) (interface{}, error) {
+	return nil, f.err
+}
+
+func TestBreaker(t *testing.T) {
+	call := func(b Breaker) error {
+		_, err := b.Execute(func() (interface{}, error) { return "ok", nil })
+		return err
+	}
+
+	assert.Nil(t, call(NewCircuitBreaker(Settings{})))
+	assert.Equal(t, ErrOpenState, call(fakeBreaker{err: ErrOpenState}))
+}
+
+// testClock 是测试中手动推进的 Clock
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMaxOpenDuration(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	var changes []stateTransition
+	cb := NewCircuitBreaker(Settings{
+		Name:            "cb",
+		Timeout:         time.Duration(10) * time.Second,
+		MaxOpenDuration: time.Duration(25) * time.Second,
+		Clock:           clock,
+		OnStateChange: func(name string, from State, to State) {
+			changes = append(changes, stateTransition{name, from, to})
+		},
+	})
+
+	cb.Trip()
+	clock.advance(time.Duration(11) * time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+
+	// 22s after the trip the Timeout has passed again, but not MaxOpenDuration
+	clock.advance(time.Duration(11) * time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	clock.advance(time.Duration(3) * time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Equal(t, []stateTransition{
+		{"cb", StateClosed, StateOpen},
+		{"cb", StateOpen, StateHalfOpen},
+		{"cb", StateHalfOpen, StateOpen},
+		{"cb", StateOpen, StateHalfOpen},
+		{"cb", StateHalfOpen, StateClosed},
+	}, changes)
+
+	// MaxOpenDuration counts from the latest trip and takes precedence over Timeout
+	cb.timeout = time.Duration(25) * time.Second
+	cb.Trip()
+	clock.advance(time.Duration(26) * time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+
+	// a forced state is kept
+	assert.Nil(t, cb.SetForcedState(StateOpen))
+	clock.advance(time.Duration(26) * time.Second)
+	assert.Equal(t, StateOpen, cb.State())
 }
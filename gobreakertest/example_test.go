@@ -0,0 +1,61 @@
+package gobreakertest_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/sony/gobreaker/gobreakertest"
+)
+
+func Example() {
+	cb, clock := gobreakertest.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "example",
+		Timeout: time.Minute,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 3
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		cb.Execute(func() (interface{}, error) { return nil, errors.New("down") })
+	}
+	fmt.Println(cb.State())
+
+	_, err := cb.Execute(func() (interface{}, error) { return "ok", nil })
+	fmt.Println(errors.Is(err, gobreaker.ErrOpenState))
+
+	clock.Advance(time.Minute + time.Second)
+	fmt.Println(cb.State())
+
+	result, err := cb.Execute(func() (interface{}, error) { return "ok", nil })
+	fmt.Println(result, err)
+	fmt.Println(cb.State())
+	// Output:
+	// open
+	// true
+	// half-open
+	// ok <nil>
+	// closed
+}
+
+func ExampleManualClock() {
+	clock := gobreakertest.NewManualClock(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Timeout: time.Minute,
+		Clock:   clock,
+	})
+
+	cb.Trip()
+	fmt.Println(cb.ExpiresAt())
+
+	clock.Advance(time.Minute)
+	fmt.Println(cb.State())
+	clock.Advance(time.Nanosecond)
+	fmt.Println(cb.State())
+	// Output:
+	// 2000-01-01 00:01:00 +0000 UTC
+	// open
+	// half-open
+}
@@ -0,0 +1,56 @@
+// Package gobreakertest provides utilities for testing code that uses gobreaker.
+package gobreakertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// ManualClock is a gobreaker.Clock whose time only moves when the test moves it.
+// It is safe for concurrent use.
+type ManualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewManualClock returns a ManualClock set to start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the current time of the clock.
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Set sets the clock to t, which may be earlier than the current time of the clock.
+func (c *ManualClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = t
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker configured with st
+// and driven by a new ManualClock, which is also returned.
+// The ManualClock starts at a fixed time, so that the times reported by the CircuitBreaker
+// are the same in every run. Any Clock in st is replaced.
+func NewCircuitBreaker(st gobreaker.Settings) (*gobreaker.CircuitBreaker, *ManualClock) {
+	// 固定的起始时间，让测试的结果可以重复
+	clock := NewManualClock(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	st.Clock = clock
+	return gobreaker.NewCircuitBreaker(st), clock
+}
@@ -0,0 +1,37 @@
+package gobreakertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), clock.Now())
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
+
+func TestNewCircuitBreakerInterval(t *testing.T) {
+	cb, clock := NewCircuitBreaker(gobreaker.Settings{Interval: time.Minute})
+	generation := cb.Generation()
+
+	cb.Execute(func() (interface{}, error) { return nil, nil })
+	assert.Equal(t, uint32(1), cb.Counts().Requests)
+	assert.Equal(t, clock.Now().Add(time.Minute), cb.ExpiresAt())
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, generation, cb.Generation())
+	clock.Advance(time.Nanosecond)
+	assert.Equal(t, generation+1, cb.Generation())
+	assert.Equal(t, uint32(0), cb.Counts().Requests)
+	assert.Equal(t, time.Minute+time.Nanosecond, cb.StateDuration())
+}
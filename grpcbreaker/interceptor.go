@@ -0,0 +1,50 @@
+// Package grpcbreaker guards gRPC client calls with gobreaker.CircuitBreaker.
+package grpcbreaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sony/gobreaker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FailureCodes are the status codes that IsSuccessful counts as failures.
+// They indicate that the server is unavailable or overloaded, rather than that the request was bad.
+var FailureCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Internal:          true,
+	codes.Unknown:           true,
+}
+
+// IsSuccessful classifies the error of a gRPC call by status.Code(err):
+// it returns false for the codes in FailureCodes and true otherwise.
+// Use it as gobreaker.Settings.IsSuccessful, or replace it with your own classification.
+func IsSuccessful(err error) bool {
+	return !FailureCodes[status.Code(err)]
+}
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that runs each call through cb.
+// Calls are classified by the IsSuccessful of cb's Settings.
+// If cb rejects a call, the interceptor returns a status error with codes.Unavailable,
+// unless the rejection error set by Settings.RejectionError is already a status error.
+func UnaryClientInterceptor(cb *gobreaker.CircuitBreaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		_, err := cb.Execute(func() (interface{}, error) {
+			return nil, invoker(ctx, method, req, reply, cc, opts...)
+		})
+		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+			// Settings.RejectionError 已经返回了 gRPC 状态时保留它
+			var se interface{ GRPCStatus() *status.Status }
+			if errors.As(err, &se) {
+				return se.GRPCStatus().Err()
+			}
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return err
+	}
+}
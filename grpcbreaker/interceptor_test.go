@@ -0,0 +1,68 @@
+package grpcbreaker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsSuccessful(t *testing.T) {
+	assert.True(t, IsSuccessful(nil))
+	assert.True(t, IsSuccessful(status.Error(codes.NotFound, "not found")))
+	assert.True(t, IsSuccessful(status.Error(codes.InvalidArgument, "bad")))
+	assert.False(t, IsSuccessful(status.Error(codes.Unavailable, "down")))
+	assert.False(t, IsSuccessful(status.Error(codes.DeadlineExceeded, "slow")))
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{Name: "grpc", IsSuccessful: IsSuccessful})
+	interceptor := UnaryClientInterceptor(cb)
+
+	code := codes.NotFound
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(code, "error")
+	}
+	call := func() error {
+		return interceptor(context.Background(), "/svc/Method", nil, nil, nil, invoker)
+	}
+
+	assert.Equal(t, codes.NotFound, status.Code(call()))
+	assert.Equal(t, uint32(1), cb.Counts().TotalSuccesses)
+
+	code = codes.Unavailable
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, codes.Unavailable, status.Code(call()))
+	}
+	assert.Equal(t, gobreaker.StateOpen, cb.State())
+	assert.Equal(t, 7, calls)
+
+	// rejected without calling the invoker
+	err := call()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "circuit breaker is open")
+	assert.Equal(t, 7, calls)
+}
+
+func TestUnaryClientInterceptorRejectionError(t *testing.T) {
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		InitialState: gobreaker.StateOpen,
+		RejectionError: func(state gobreaker.State, counts gobreaker.Counts) error {
+			return status.Error(codes.ResourceExhausted, "shed")
+		},
+	})
+	interceptor := UnaryClientInterceptor(cb)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	err := interceptor(context.Background(), "/svc/Method", nil, nil, nil, invoker)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, "shed", status.Convert(err).Message())
+}
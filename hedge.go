@@ -0,0 +1,98 @@
+package gobreaker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// hedgeResult 是一次对冲尝试的结果
+type hedgeResult[T any] struct {
+	result   T
+	err      error
+	panicked bool
+	panicVal interface{}
+}
+
+// hedged 返回带对冲的请求：第一次尝试超过 hedgeDelay 仍未返回时再发起一次，
+// 返回第一个没有被分类为失败的结果，两次都失败时返回后完成的那次
+func hedged[T any](cb *CircuitBreaker, classify func(result T, err error) ErrorClass, req func() (T, error)) func() (T, error) {
+	return func() (T, error) {
+		ch := make(chan hedgeResult[T], 2) // 带缓冲，输掉的尝试返回时不会阻塞
+		// 对冲的尝试额外占用一个并发名额，直到两次尝试都返回才释放，
+		// 这样输掉的尝试在请求计数之后仍在运行时也占用名额
+		var running int32 = 1
+		var slots int32
+		run := func() {
+			go func() {
+				defer func() {
+					// Swap 保证名额只释放一次
+					if atomic.AddInt32(&running, -1) == 0 && atomic.SwapInt32(&slots, 0) > 0 {
+						cb.releaseHedgeSlot()
+					}
+				}()
+				defer func() {
+					if e := recover(); e != nil {
+						ch <- hedgeResult[T]{panicked: true, panicVal: e}
+					}
+				}()
+				result, err := req()
+				ch <- hedgeResult[T]{result: result, err: err}
+			}()
+		}
+
+		run()
+		pending := 1
+		timer := time.NewTimer(cb.hedgeDelay)
+		defer timer.Stop()
+		for {
+			select {
+			case r := <-ch:
+				pending--
+				if r.panicked {
+					panic(r.panicVal)
+				}
+				if pending == 0 || classify(r.result, r.err) != ErrorClassFailure {
+					return r.result, r.err
+				}
+			case <-timer.C:
+				// 第一次尝试已经失败时不会走到这里；并发数达到上限时不再对冲
+				if cb.acquireHedgeSlot() {
+					atomic.AddInt32(&running, 1)
+					atomic.StoreInt32(&slots, 1)
+					pending++
+					run()
+				}
+			}
+		}
+	}
+}
+
+// hedgedContext 与 hedged 相同，返回后取消仍在进行的尝试
+func hedgedContext[T any](cb *CircuitBreaker, classify func(result T, err error) ErrorClass, req func(context.Context) (T, error)) func(context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		hctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		return hedged(cb, classify, func() (T, error) { return req(hctx) })()
+	}
+}
+
+// acquireHedgeSlot 为对冲的尝试占用一个并发名额，达到 MaxConcurrent 或正在 Drain 时返回 false
+func (cb *CircuitBreaker) acquireHedgeSlot() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.draining || (cb.maxConcurrent > 0 && cb.inFlight >= cb.maxConcurrent) {
+		return false
+	}
+	cb.inFlight++
+	return true
+}
+
+// releaseHedgeSlot 在对冲的尝试返回时释放它占用的并发名额
+func (cb *CircuitBreaker) releaseHedgeSlot() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.finishRequest()
+}
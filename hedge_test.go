@@ -0,0 +1,122 @@
+package gobreaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgeBothSucceed(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{HedgeDelay: time.Duration(10) * time.Millisecond})
+	var attempts int32
+	result, err := cb.Execute(func() (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(time.Duration(100) * time.Millisecond)
+			return "first", nil
+		}
+		return "second", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "second", result)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts()) // counted once
+
+	// a fast request is not hedged
+	atomic.StoreInt32(&attempts, 0)
+	result, err = cb.Execute(func() (interface{}, error) {
+		atomic.AddInt32(&attempts, 1)
+		return "fast", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fast", result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestHedgeFirstErrors(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{HedgeDelay: time.Duration(10) * time.Millisecond})
+	errFail := errors.New("fail")
+	var attempts int32
+	result, err := cb.Execute(func() (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(time.Duration(20) * time.Millisecond)
+			return nil, errFail
+		}
+		time.Sleep(time.Duration(30) * time.Millisecond)
+		return "second", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "second", result)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	// both fail: the later failure is returned and counted once
+	atomic.StoreInt32(&attempts, 0)
+	errLater := errors.New("later")
+	_, err = cb.Execute(func() (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(time.Duration(20) * time.Millisecond)
+			return nil, errFail
+		}
+		time.Sleep(time.Duration(30) * time.Millisecond)
+		return nil, errLater
+	})
+	assert.Equal(t, errLater, err)
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	// a failure before HedgeDelay is returned without hedging
+	atomic.StoreInt32(&attempts, 0)
+	_, err = cb.Execute(func() (interface{}, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, errFail
+	})
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestHedgeMaxConcurrent(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{HedgeDelay: time.Duration(5) * time.Millisecond, MaxConcurrent: 1})
+	var attempts int32
+	_, err := cb.Execute(func() (interface{}, error) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(time.Duration(30) * time.Millisecond)
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts)) // no slot for the hedge
+
+	// the hedge holds a slot until it returns
+	cb = NewCircuitBreaker(Settings{HedgeDelay: time.Duration(5) * time.Millisecond, MaxConcurrent: 2})
+	atomic.StoreInt32(&attempts, 0)
+	release := make(chan struct{})
+	_, err = cb.Execute(func() (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-release
+		}
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	cb.mutex.Lock()
+	assert.Equal(t, uint32(1), cb.inFlight)
+	cb.mutex.Unlock()
+	close(release)
+}
+
+func TestHedgeContext(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{HedgeDelay: time.Duration(5) * time.Millisecond})
+	var attempts int32
+	canceled := make(chan struct{})
+	result, err := cb.ExecuteContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}
+		return "second", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "second", result)
+	<-canceled // the losing attempt is canceled
+}
@@ -0,0 +1,162 @@
+package gobreaker
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyedBreaker holds an independent CircuitBreaker for each key, such as a tenant ID,
+// so that failures for one key don't reject requests for the others.
+// CircuitBreakers are created lazily from a Settings template and are safe for concurrent use.
+//
+// MaxKeys, if greater than 0, is the maximum number of CircuitBreakers kept.
+// When a new key would exceed it, the least recently used CircuitBreaker is evicted.
+//
+// IdleTimeout, if greater than 0, evicts CircuitBreakers that have not been used for IdleTimeout.
+//
+// An evicted CircuitBreaker loses its state, even if it is open, and is created again
+// in the closed state the next time its key is used. It is closed with CircuitBreaker.Close,
+// so that the background resources of the template Settings, such as ProbeFunc and OnStuckOpen,
+// are released. A CircuitBreaker evicted while Execute is running requests through it
+// is closed only once the last of those requests has returned, so they are not refused,
+// but a caller still holding it from Get is refused with ErrBreakerClosed.
+// Set MaxKeys and IdleTimeout before calling Execute.
+type KeyedBreaker struct {
+	MaxKeys     int
+	IdleTimeout time.Duration
+
+	st Settings
+
+	mutex    sync.Mutex
+	lru      *list.List // 按最近使用排序，最前面是最近使用的
+	breakers map[string]*list.Element
+}
+
+// keyedEntry 是 KeyedBreaker 中的一个熔断器及其最后使用时间。
+// users 是正在通过 Execute 使用它的请求数，evicted 为 true 时在 users 变为 0 后关闭熔断器
+type keyedEntry struct {
+	key      string
+	cb       *CircuitBreaker
+	lastUsed time.Time
+	users    int
+	evicted  bool
+}
+
+// NewKeyedBreaker returns a new KeyedBreaker whose CircuitBreakers are configured with st.
+// Each CircuitBreaker is named after its key, prefixed with st.Name and ":" if st.Name is not empty.
+func NewKeyedBreaker(st Settings) *KeyedBreaker {
+	return &KeyedBreaker{
+		st:       st,
+		lru:      list.New(),
+		breakers: make(map[string]*list.Element),
+	}
+}
+
+// Execute runs the given request through the CircuitBreaker of key, creating it if needed.
+// See CircuitBreaker.Execute.
+func (kb *KeyedBreaker) Execute(key string, req func() (interface{}, error)) (interface{}, error) {
+	entry := kb.acquire(key)
+	defer kb.release(entry)
+	return entry.cb.Execute(req)
+}
+
+// Get returns the CircuitBreaker of key without creating it or marking it as used.
+func (kb *KeyedBreaker) Get(key string) (*CircuitBreaker, bool) {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	kb.evictIdle(time.Now())
+	if e, ok := kb.breakers[key]; ok {
+		return e.Value.(*keyedEntry).cb, true
+	}
+	return nil, false
+}
+
+// Len returns the number of CircuitBreakers currently kept.
+func (kb *KeyedBreaker) Len() int {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	kb.evictIdle(time.Now())
+	return kb.lru.Len()
+}
+
+// Keys returns the keys of the CircuitBreakers currently kept, sorted.
+func (kb *KeyedBreaker) Keys() []string {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	kb.evictIdle(time.Now())
+	keys := make([]string, 0, kb.lru.Len())
+	for key := range kb.breakers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// acquire 返回 key 对应的熔断器，不存在时创建，标记为最近使用并增加使用者的计数，
+// 使用结束后需要调用 release
+func (kb *KeyedBreaker) acquire(key string) *keyedEntry {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	now := time.Now()
+	kb.evictIdle(now)
+	if e, ok := kb.breakers[key]; ok {
+		entry := e.Value.(*keyedEntry)
+		entry.lastUsed = now
+		entry.users++
+		kb.lru.MoveToFront(e)
+		return entry
+	}
+
+	st := kb.st
+	if st.Name == "" {
+		st.Name = key
+	} else {
+		st.Name += ":" + key
+	}
+	entry := &keyedEntry{key: key, cb: NewCircuitBreaker(st), lastUsed: now, users: 1}
+	kb.breakers[key] = kb.lru.PushFront(entry)
+
+	for kb.MaxKeys > 0 && kb.lru.Len() > kb.MaxKeys {
+		kb.remove(kb.lru.Back())
+	}
+	return entry
+}
+
+// release 减少使用者的计数，已经被移除的熔断器在最后一个使用者结束后关闭
+func (kb *KeyedBreaker) release(entry *keyedEntry) {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	entry.users--
+	if entry.evicted && entry.users == 0 {
+		entry.cb.Close()
+	}
+}
+
+// evictIdle 从最久未使用的一端开始移除空闲超过 IdleTimeout 的熔断器，调用方需要持有锁
+func (kb *KeyedBreaker) evictIdle(now time.Time) {
+	if kb.IdleTimeout <= 0 {
+		return
+	}
+	for e := kb.lru.Back(); e != nil && now.Sub(e.Value.(*keyedEntry).lastUsed) >= kb.IdleTimeout; e = kb.lru.Back() {
+		kb.remove(e)
+	}
+}
+
+// remove 移除熔断器，没有使用者时立即关闭以释放探测 goroutine 等后台资源，
+// 否则由最后一个使用者在 release 时关闭。调用方需要持有锁
+func (kb *KeyedBreaker) remove(e *list.Element) {
+	entry := e.Value.(*keyedEntry)
+	kb.lru.Remove(e)
+	delete(kb.breakers, entry.key)
+	entry.evicted = true
+	if entry.users == 0 {
+		entry.cb.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package gobreaker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedBreaker(t *testing.T) {
+	kb := NewKeyedBreaker(Settings{Name: "api"})
+	failKey := func(key string) {
+		kb.Execute(key, func() (interface{}, error) { return nil, fmt.Errorf("fail") })
+	}
+
+	for i := 0; i < 6; i++ {
+		failKey("noisy")
+	}
+	_, err := kb.Execute("noisy", func() (interface{}, error) { return nil, nil })
+	assert.True(t, errors.Is(err, ErrOpenState))
+
+	result, err := kb.Execute("quiet", func() (interface{}, error) { return "ok", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", result)
+
+	assert.Equal(t, 2, kb.Len())
+	assert.Equal(t, []string{"noisy", "quiet"}, kb.Keys())
+	cb, ok := kb.Get("noisy")
+	assert.True(t, ok)
+	assert.Equal(t, "api:noisy", cb.Name())
+	assert.Equal(t, StateOpen, cb.State())
+	_, ok = kb.Get("other")
+	assert.False(t, ok)
+}
+
+func TestKeyedBreakerEviction(t *testing.T) {
+	kb := NewKeyedBreaker(Settings{})
+	kb.MaxKeys = 2
+	var b *CircuitBreaker
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if key == "c" {
+			b, _ = kb.Get("b")
+		}
+		_, err := kb.Execute(key, func() (interface{}, error) { return nil, nil })
+		assert.Nil(t, err)
+	}
+	// b is the least recently used
+	assert.Equal(t, []string{"a", "c"}, kb.Keys())
+	cb, _ := kb.Get("a")
+	assert.Equal(t, "a", cb.Name())
+	// the evicted CircuitBreaker is closed
+	assert.True(t, errors.Is(succeed(b), ErrBreakerClosed))
+
+	kb = NewKeyedBreaker(Settings{})
+	kb.IdleTimeout = time.Duration(20) * time.Millisecond
+	kb.Execute("a", func() (interface{}, error) { return nil, nil })
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	kb.Execute("b", func() (interface{}, error) { return nil, nil })
+	assert.Equal(t, []string{"b"}, kb.Keys())
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	assert.Equal(t, 0, kb.Len())
+}
+
+func TestKeyedBreakerEvictionInUse(t *testing.T) {
+	kb := NewKeyedBreaker(Settings{})
+	kb.MaxKeys = 1
+
+	// a is evicted by b between its lookup and its request
+	entry := kb.acquire("a")
+	_, err := kb.Execute("b", func() (interface{}, error) { return nil, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, kb.Keys())
+	assert.Nil(t, succeed(entry.cb))
+	kb.release(entry)
+	assert.True(t, errors.Is(succeed(entry.cb), ErrBreakerClosed))
+
+	// a request that outlives IdleTimeout runs to completion
+	kb = NewKeyedBreaker(Settings{})
+	kb.IdleTimeout = time.Duration(10) * time.Millisecond
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := kb.Execute("slow", func() (interface{}, error) {
+			<-release
+			return nil, nil
+		})
+		done <- err
+	}()
+	time.Sleep(time.Duration(5) * time.Millisecond)
+	slow, ok := kb.Get("slow")
+	assert.True(t, ok)
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	assert.Equal(t, 0, kb.Len())
+	assert.Nil(t, succeed(slow)) // evicted but still in use, so not closed yet
+	close(release)
+	assert.Nil(t, <-done)
+	assert.Equal(t, newCounts(2, 2, 0, 2, 0), slow.Counts())
+	assert.True(t, errors.Is(succeed(slow), ErrBreakerClosed))
+}
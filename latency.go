@@ -0,0 +1,113 @@
+package gobreaker
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram used when
+// Settings.LatencyBuckets is empty.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	time.Duration(2) * time.Millisecond,
+	time.Duration(5) * time.Millisecond,
+	time.Duration(10) * time.Millisecond,
+	time.Duration(20) * time.Millisecond,
+	time.Duration(50) * time.Millisecond,
+	time.Duration(100) * time.Millisecond,
+	time.Duration(200) * time.Millisecond,
+	time.Duration(500) * time.Millisecond,
+	time.Second,
+	time.Duration(2) * time.Second,
+	time.Duration(5) * time.Second,
+	time.Duration(10) * time.Second,
+}
+
+// latencyHistogram 是请求耗时的直方图，counts 比 bounds 多一个桶，用于超过最大上界的耗时。
+// 所有字段都用原子操作读写，记录耗时不需要持有熔断器的锁，避免每个请求多一次加锁。
+// 并发读取时各个桶之间可能不完全一致，对统计结果来说可以接受
+type latencyHistogram struct {
+	bounds []time.Duration
+	counts []uint64
+	total  uint64
+	max    int64 // time.Duration
+}
+
+func newLatencyHistogram(bounds []time.Duration) *latencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	sorted := append([]time.Duration(nil), bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &latencyHistogram{
+		bounds: sorted,
+		counts: make([]uint64, len(sorted)+1),
+	}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.total, 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			return
+		}
+	}
+}
+
+// percentile 返回第 p 百分位所在桶的上界，不超过观测到的最大值
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	total := atomic.LoadUint64(&h.total)
+	max := time.Duration(atomic.LoadInt64(&h.max))
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(math.Min(math.Max(p, 0), 100) / 100 * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		if cumulative >= rank {
+			if i < len(h.bounds) && h.bounds[i] < max {
+				return h.bounds[i]
+			}
+			return max
+		}
+	}
+	return max
+}
+
+func (h *latencyHistogram) reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+	atomic.StoreUint64(&h.total, 0)
+	atomic.StoreInt64(&h.max, 0)
+}
+
+// LatencyPercentile returns the p-th percentile, between 0 and 100, of the latency of the requests
+// run by Execute, ExecuteContext and RoundTripper, e.g. LatencyPercentile(99) for p99.
+// The result is the upper bound of the histogram bucket that holds the percentile,
+// capped at the largest latency observed, so its precision depends on Settings.LatencyBuckets.
+// It returns 0 if no latency has been observed or Settings.TrackLatency is false.
+func (cb *CircuitBreaker) LatencyPercentile(p float64) time.Duration {
+	if cb.latency == nil {
+		return 0
+	}
+	return cb.latency.percentile(p)
+}
+
+// observeLatency 记录请求耗时，直方图使用原子操作，不需要加锁
+func (cb *CircuitBreaker) observeLatency(elapsed time.Duration) {
+	if cb.latency == nil {
+		return
+	}
+	cb.latency.observe(elapsed)
+}
@@ -0,0 +1,107 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	ms := time.Millisecond
+	h := newLatencyHistogram([]time.Duration{10 * ms, 1 * ms, 100 * ms})
+	assert.Equal(t, time.Duration(0), h.percentile(50))
+
+	for i := 0; i < 90; i++ {
+		h.observe(500 * time.Microsecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.observe(50 * ms)
+	}
+	h.observe(300 * ms)
+
+	assert.Equal(t, 1*ms, h.percentile(50)) // the upper bound of the bucket
+	assert.Equal(t, 1*ms, h.percentile(90))
+	assert.Equal(t, 100*ms, h.percentile(95))
+	assert.Equal(t, 100*ms, h.percentile(99))
+	assert.Equal(t, 300*ms, h.percentile(100)) // over the largest bound
+	assert.Equal(t, 1*ms, h.percentile(0))
+
+	h.reset()
+	h.observe(3 * ms)
+	assert.Equal(t, 3*ms, h.percentile(50)) // capped at the largest latency observed
+
+	h.reset()
+	assert.Equal(t, time.Duration(0), h.percentile(99))
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, time.Duration(0), cb.LatencyPercentile(99))
+
+	cb = NewCircuitBreaker(Settings{TrackLatency: true})
+	_, err := cb.Execute(func() (interface{}, error) {
+		time.Sleep(time.Duration(3) * time.Millisecond)
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	p99 := cb.LatencyPercentile(99)
+	assert.True(t, p99 >= time.Duration(3)*time.Millisecond && p99 <= time.Duration(5)*time.Millisecond)
+
+	// the histogram is kept across generations unless ResetLatency is set
+	cb.Trip()
+	assert.Equal(t, p99, cb.LatencyPercentile(99))
+
+	cb = NewCircuitBreaker(Settings{TrackLatency: true, ResetLatency: true})
+	assert.Nil(t, succeed(cb))
+	cb.Trip()
+	assert.Equal(t, time.Duration(0), cb.LatencyPercentile(99))
+}
+
+// BenchmarkExecuteParallel 比较并发调用 Execute 的开销：NoBreaker 是不经过熔断器的基准，
+// Batch 用 ExecuteBatch 每 100 个请求准入一次，ns/op 按单个请求计算
+func BenchmarkExecuteParallel(b *testing.B) {
+	req := func() (interface{}, error) { return nil, nil }
+	for _, bm := range []struct {
+		name string
+		st   Settings
+	}{
+		{"Default", Settings{}},
+		{"TrackLatency", Settings{TrackLatency: true}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			cb := NewCircuitBreaker(bm.st)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					cb.Execute(req)
+				}
+			})
+		})
+	}
+
+	b.Run("NoBreaker", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				req()
+			}
+		})
+	})
+
+	b.Run("Batch", func(b *testing.B) {
+		cb := NewCircuitBreaker(Settings{})
+		reqs := make([]func() (interface{}, error), 100)
+		for i := range reqs {
+			reqs[i] = req
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			n := 0
+			for pb.Next() {
+				if n++; n == len(reqs) {
+					cb.ExecuteBatch(reqs)
+					n = 0
+				}
+			}
+		})
+	})
+}
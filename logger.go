@@ -0,0 +1,31 @@
+package gobreaker
+
+// Logger is the logger used by a CircuitBreaker, configured by Settings.Logger.
+// Its methods take a format and arguments as fmt.Printf does.
+// They are called while the CircuitBreaker holds its lock and must not call back into it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// nopLogger 是默认的 Logger，不输出任何日志
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+
+// logStateChange 记录状态变更，变更为开启状态时使用 Warn 级别，其他使用 Info 级别
+func (cb *CircuitBreaker) logStateChange(from State, to State, counts Counts) {
+	if to == StateOpen {
+		cb.logger.Warnf("circuit breaker tripped: name=%q from=%s to=%s counts=%+v", cb.name, from, to, counts)
+		return
+	}
+	cb.logger.Infof("circuit breaker state changed: name=%q from=%s to=%s counts=%+v", cb.name, from, to, counts)
+}
+
+// logRejection 以 Debug 级别记录被拒绝的请求
+func (cb *CircuitBreaker) logRejection(err error) {
+	cb.logger.Debugf("circuit breaker rejected request: name=%q state=%s counts=%+v err=%q", cb.name, cb.state, cb.counts, err)
+}
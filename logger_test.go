@@ -0,0 +1,49 @@
+package gobreaker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	entries []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.entries = append(l.entries, "DEBUG "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.entries = append(l.entries, "INFO "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.entries = append(l.entries, "WARN "+fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	cb := NewCircuitBreaker(Settings{Name: "logged", Logger: logger})
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Error(t, succeed(cb))
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+
+	assert.Equal(t, []string{
+		`WARN circuit breaker tripped: name="logged" from=closed to=open counts={Requests:6 TotalSuccesses:0 TotalFailures:6 ConsecutiveSuccesses:0 ConsecutiveFailures:6 TotalItems:0 SucceededItems:0 SlowCalls:0 Rejections:0}`,
+		`DEBUG circuit breaker rejected request: name="logged" state=open counts={Requests:0 TotalSuccesses:0 TotalFailures:0 ConsecutiveSuccesses:0 ConsecutiveFailures:0 TotalItems:0 SucceededItems:0 SlowCalls:0 Rejections:1} err="circuit breaker \"logged\" is open: circuit breaker is open"`,
+		`INFO circuit breaker state changed: name="logged" from=open to=half-open counts={Requests:0 TotalSuccesses:0 TotalFailures:0 ConsecutiveSuccesses:0 ConsecutiveFailures:0 TotalItems:0 SucceededItems:0 SlowCalls:0 Rejections:1}`,
+		`INFO circuit breaker state changed: name="logged" from=half-open to=closed counts={Requests:1 TotalSuccesses:1 TotalFailures:0 ConsecutiveSuccesses:1 ConsecutiveFailures:0 TotalItems:0 SucceededItems:0 SlowCalls:0 Rejections:0}`,
+	}, logger.entries)
+
+	// the replay of Diff doesn't log
+	events := make([]Event, 6)
+	Diff(cb, cb, events)
+	assert.Len(t, logger.entries, 4)
+}
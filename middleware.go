@@ -0,0 +1,107 @@
+package gobreaker
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware returns an HTTP server middleware that sends requests through cb.
+//
+// If cb rejects a request, the middleware responds with 503 Service Unavailable
+// without calling the handler. When cb is open, the response has a Retry-After header
+// with the number of seconds until cb becomes half-open, as given by ExpiresAt.
+//
+// Otherwise the handler is called and its response is classified by its status code:
+// a status code in failureStatus is a failure, and any other is a success.
+// If no failureStatus is given, status codes of 500 and above are failures.
+// A handler that panics is counted as a failure, unless Settings.PanicCountsAsFailure
+// points to false, and the panic is propagated.
+func Middleware(cb *CircuitBreaker, failureStatus ...int) func(http.Handler) http.Handler {
+	isFailure := func(status int) bool {
+		return status >= http.StatusInternalServerError
+	}
+	if len(failureStatus) > 0 {
+		failures := make(map[int]bool, len(failureStatus))
+		for _, status := range failureStatus {
+			failures[status] = true
+		}
+		isFailure = func(status int) bool {
+			return failures[status]
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			generation, err := cb.beforeRequest()
+			if err != nil {
+				if expiry := cb.ExpiresAt(); cb.State() == StateOpen && !expiry.IsZero() {
+					w.Header().Set("Retry-After", retryAfter(expiry.Sub(cb.clock.Now())))
+				}
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			defer func() {
+				if e := recover(); e != nil {
+					if cb.ignorePanics {
+						cb.ignoreRequest(generation)
+					} else {
+						cb.afterTimedRequest(generation, false, time.Since(start), nil)
+					}
+					panic(e)
+				}
+			}()
+
+			next.ServeHTTP(sw, r)
+			elapsed := time.Since(start)
+			cb.observeLatency(elapsed)
+			cb.afterTimedRequest(generation, !isFailure(sw.status), elapsed, nil)
+		})
+	}
+}
+
+// retryAfter 返回 Retry-After 头的秒数，向上取整且至少为 1 秒
+func retryAfter(d time.Duration) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// statusWriter 记录 handler 写入的状态码，没有调用 WriteHeader 时为 200
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush 在底层 ResponseWriter 支持时刷新缓冲
+func (w *statusWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 返回底层的 ResponseWriter，供 http.ResponseController 使用
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,90 @@
+package gobreaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	status := http.StatusOK
+	var calls int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte("ok"))
+	})
+
+	cb := NewCircuitBreaker(Settings{})
+	h := Middleware(cb)(handler)
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+	status = http.StatusNotFound
+	assert.Equal(t, http.StatusNotFound, serve().Code)
+	assert.Equal(t, newCounts(2, 2, 0, 2, 0), cb.Counts())
+
+	status = http.StatusInternalServerError
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, http.StatusInternalServerError, serve().Code)
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	w := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 8, calls)
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.Nil(t, err)
+	assert.True(t, retry > 55 && retry <= 60)
+
+	// half-open with its only slot taken: no Retry-After
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	generation, err := cb.beforeRequest()
+	assert.Nil(t, err)
+	w = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "", w.Header().Get("Retry-After"))
+	cb.afterRequest(generation, true)
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestMiddlewareFailureStatus(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	h := Middleware(cb, http.StatusTooManyRequests)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/panic":
+			panic(http.ErrAbortHandler)
+		}
+	}))
+	serve := func(path string) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	serve("/error")
+	serve("/limited")
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	assert.Panics(t, func() { serve("/panic") })
+	assert.Equal(t, newCounts(3, 1, 2, 0, 2), cb.Counts())
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", retryAfter(0))
+	assert.Equal(t, "1", retryAfter(time.Duration(-1)*time.Second))
+	assert.Equal(t, "2", retryAfter(time.Duration(1500)*time.Millisecond))
+	assert.Equal(t, "60", retryAfter(time.Duration(60)*time.Second))
+}
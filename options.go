@@ -0,0 +1,79 @@
+package gobreaker
+
+import "time"
+
+// Option sets a field of the Settings used by New.
+type Option func(st *Settings)
+
+// New returns a new CircuitBreaker configured with the given options.
+// Fields not set by any option take their defaults as in NewCircuitBreaker.
+func New(opts ...Option) *CircuitBreaker {
+	var st Settings
+	for _, opt := range opts {
+		opt(&st)
+	}
+	return NewCircuitBreaker(st)
+}
+
+// WithName sets Settings.Name.
+func WithName(name string) Option {
+	return func(st *Settings) {
+		st.Name = name
+	}
+}
+
+// WithMaxRequests sets Settings.MaxRequests.
+func WithMaxRequests(maxRequests uint32) Option {
+	return func(st *Settings) {
+		st.MaxRequests = maxRequests
+	}
+}
+
+// WithInterval sets Settings.Interval.
+func WithInterval(interval time.Duration) Option {
+	return func(st *Settings) {
+		st.Interval = interval
+	}
+}
+
+// WithTimeout sets Settings.Timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(st *Settings) {
+		st.Timeout = timeout
+	}
+}
+
+// WithReadyToTrip sets Settings.ReadyToTrip.
+func WithReadyToTrip(readyToTrip func(counts Counts) bool) Option {
+	return func(st *Settings) {
+		st.ReadyToTrip = readyToTrip
+	}
+}
+
+// WithConsecutiveFailuresThreshold sets Settings.ConsecutiveFailuresThreshold.
+func WithConsecutiveFailuresThreshold(threshold uint32) Option {
+	return func(st *Settings) {
+		st.ConsecutiveFailuresThreshold = threshold
+	}
+}
+
+// WithIsSuccessful sets Settings.IsSuccessful.
+func WithIsSuccessful(isSuccessful func(err error) bool) Option {
+	return func(st *Settings) {
+		st.IsSuccessful = isSuccessful
+	}
+}
+
+// WithOnStateChange sets Settings.OnStateChange.
+func WithOnStateChange(onStateChange func(name string, from State, to State)) Option {
+	return func(st *Settings) {
+		st.OnStateChange = onStateChange
+	}
+}
+
+// WithPanicCountsAsFailure sets Settings.PanicCountsAsFailure.
+func WithPanicCountsAsFailure(panicCountsAsFailure bool) Option {
+	return func(st *Settings) {
+		st.PanicCountsAsFailure = &panicCountsAsFailure
+	}
+}
@@ -0,0 +1,42 @@
+package gobreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	var changes []stateTransition
+	errIgnored := errors.New("ignored")
+	cb := New(
+		WithName("cb"),
+		WithMaxRequests(2),
+		WithInterval(time.Duration(30)*time.Second),
+		WithTimeout(time.Duration(90)*time.Second),
+		WithReadyToTrip(func(counts Counts) bool { return counts.ConsecutiveFailures >= 2 }),
+		WithIsSuccessful(func(err error) bool { return err == nil || err == errIgnored }),
+		WithOnStateChange(func(name string, from State, to State) {
+			changes = append(changes, stateTransition{name, from, to})
+		}),
+	)
+	assert.Equal(t, "cb", cb.Name())
+	assert.Equal(t, uint32(2), cb.maxRequests)
+	assert.Equal(t, time.Duration(30)*time.Second, cb.interval)
+	assert.Equal(t, time.Duration(90)*time.Second, cb.timeout)
+
+	_, err := cb.Execute(func() (interface{}, error) { return nil, errIgnored })
+	assert.Equal(t, errIgnored, err)
+	assert.Equal(t, uint32(1), cb.Counts().TotalSuccesses)
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, []stateTransition{{"cb", StateClosed, StateOpen}}, changes)
+
+	// without options the defaults are used
+	cb = New()
+	assert.Equal(t, uint32(1), cb.maxRequests)
+	assert.Equal(t, defaultTimeout, cb.timeout)
+}
@@ -0,0 +1,108 @@
+// Package otelbreaker traces the calls of gobreaker.CircuitBreaker with OpenTelemetry.
+// It is a separate module, so that gobreaker itself doesn't depend on OpenTelemetry.
+package otelbreaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the Tracer used when Settings.Tracer is nil.
+const instrumentationName = "github.com/sony/gobreaker/otelbreaker"
+
+// The attributes set on every span.
+const (
+	// NameKey is the name of the CircuitBreaker.
+	NameKey = attribute.Key("gobreaker.name")
+	// StateKey is the state of the CircuitBreaker after the call.
+	StateKey = attribute.Key("gobreaker.state")
+	// RejectedKey is true if the CircuitBreaker rejected the call without running the request.
+	RejectedKey = attribute.Key("gobreaker.rejected")
+)
+
+// Settings configures a Breaker.
+//
+// Tracer creates the spans. If Tracer is nil, the Tracer of the global TracerProvider is used.
+type Settings struct {
+	Tracer trace.Tracer
+}
+
+// Breaker runs requests through a CircuitBreaker and records a span for each call,
+// named after the CircuitBreaker.
+type Breaker struct {
+	cb     *gobreaker.CircuitBreaker
+	tracer trace.Tracer
+}
+
+// New returns a Breaker that traces the calls of cb.
+func New(cb *gobreaker.CircuitBreaker, st Settings) *Breaker {
+	tracer := st.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer(instrumentationName)
+	}
+	return &Breaker{cb: cb, tracer: tracer}
+}
+
+// CircuitBreaker returns the CircuitBreaker of b.
+func (b *Breaker) CircuitBreaker() *gobreaker.CircuitBreaker {
+	return b.cb
+}
+
+// Execute runs req through the CircuitBreaker with CircuitBreaker.ExecuteContext
+// inside a new span, which is passed to req in its context.
+// The span records the state of the CircuitBreaker after the call and whether the call was rejected.
+// A call is taken as rejected if its error wraps one of the rejection errors of gobreaker,
+// such as ErrOpenState or ErrTooManyRequests, so a request whose own error wraps them,
+// e.g. from a nested CircuitBreaker, is reported as rejected too.
+// A rejected call ends its span right away with an error status of "circuit open"
+// if the CircuitBreaker is open, or the rejection error otherwise.
+// A request that returns an error records it on the span with an error status.
+func (b *Breaker) Execute(ctx context.Context, req func(context.Context) (interface{}, error)) (interface{}, error) {
+	name := b.cb.Name()
+	if name == "" {
+		name = "gobreaker"
+	}
+	ctx, span := b.tracer.Start(ctx, name, trace.WithAttributes(NameKey.String(b.cb.Name())))
+	defer span.End()
+
+	// 不在请求中记录是否执行：设置了 RequestTimeout 或 HedgeDelay 时请求在其他 goroutine 中执行，
+	// 超时返回后仍可能在运行
+	result, err := b.cb.ExecuteContext(ctx, req)
+
+	rejected := isRejection(err)
+	span.SetAttributes(StateKey.String(b.cb.State().String()), RejectedKey.Bool(rejected))
+	switch {
+	case err == nil:
+	case rejected && errors.Is(err, gobreaker.ErrOpenState):
+		span.SetStatus(codes.Error, "circuit open")
+	case rejected:
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
+}
+
+// isRejection 判断 err 是否为熔断器拒绝请求时返回的错误
+func isRejection(err error) bool {
+	for _, target := range []error{
+		gobreaker.ErrOpenState,
+		gobreaker.ErrTooManyRequests,
+		gobreaker.ErrTooManyConcurrent,
+		gobreaker.ErrNotAdmitted,
+		gobreaker.ErrBreakerClosed,
+		gobreaker.ErrDraining,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package otelbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attributes 返回 span 的属性
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestExecute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{Name: "api"})
+	b := New(cb, Settings{Tracer: provider.Tracer("test")})
+
+	result, err := b.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+		assert.True(t, trace.SpanFromContext(ctx).SpanContext().IsValid())
+		return "ok", nil
+	})
+	assert.Equal(t, "ok", result)
+	assert.Nil(t, err)
+
+	errDown := errors.New("down")
+	for i := 0; i < 6; i++ {
+		b.Execute(context.Background(), func(context.Context) (interface{}, error) { return nil, errDown })
+	}
+	_, err = b.Execute(context.Background(), func(context.Context) (interface{}, error) { return "ok", nil })
+	assert.True(t, errors.Is(err, gobreaker.ErrOpenState))
+
+	spans := recorder.Ended()
+	assert.Equal(t, 8, len(spans))
+
+	ok := spans[0]
+	assert.Equal(t, "api", ok.Name())
+	assert.Equal(t, codes.Unset, ok.Status().Code)
+	assert.Equal(t, "api", attributes(ok)[NameKey].AsString())
+	assert.Equal(t, "closed", attributes(ok)[StateKey].AsString())
+	assert.False(t, attributes(ok)[RejectedKey].AsBool())
+
+	failed := spans[1]
+	assert.Equal(t, codes.Error, failed.Status().Code)
+	assert.Equal(t, "down", failed.Status().Description)
+	assert.Equal(t, 1, len(failed.Events())) // the recorded error
+
+	tripped := spans[6]
+	assert.Equal(t, "open", attributes(tripped)[StateKey].AsString())
+	assert.False(t, attributes(tripped)[RejectedKey].AsBool())
+
+	rejected := spans[7]
+	assert.Equal(t, codes.Error, rejected.Status().Code)
+	assert.Equal(t, "circuit open", rejected.Status().Description)
+	assert.True(t, attributes(rejected)[RejectedKey].AsBool())
+	assert.Equal(t, 0, len(rejected.Events()))
+}
+
+func TestNewDefaultTracer(t *testing.T) {
+	b := New(gobreaker.NewCircuitBreaker(gobreaker.Settings{}), Settings{})
+	assert.NotNil(t, b.tracer)
+
+	result, err := b.Execute(context.Background(), func(context.Context) (interface{}, error) { return 1, nil })
+	assert.Equal(t, 1, result)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), b.CircuitBreaker().Counts().TotalSuccesses)
+}
+
+func TestExecuteRequestTimeout(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{Name: "api", RequestTimeout: time.Duration(10) * time.Millisecond})
+	b := New(cb, Settings{Tracer: provider.Tracer("test")})
+
+	// 请求在超时返回后仍在运行，不能被当作被拒绝的请求
+	release := make(chan struct{})
+	_, err := b.Execute(context.Background(), func(context.Context) (interface{}, error) {
+		<-release
+		return "late", nil
+	})
+	assert.True(t, errors.Is(err, gobreaker.ErrRequestTimeout))
+	close(release)
+
+	spans := recorder.Ended()
+	assert.Equal(t, 1, len(spans))
+	assert.False(t, attributes(spans[0])[RejectedKey].AsBool())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, 1, len(spans[0].Events()))
+}
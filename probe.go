@@ -0,0 +1,116 @@
+package gobreaker
+
+import "time"
+
+// defaultProbeInterval 是 ProbeInterval 的默认值
+const defaultProbeInterval = time.Second
+
+// startProbe 在熔断器离开关闭状态时启动探测的 goroutine，已经在运行时不重复启动。
+// 调用方需要持有锁
+func (cb *CircuitBreaker) startProbe() {
+	if cb.probeFunc == nil || cb.probeStop != nil || cb.closed {
+		return
+	}
+
+	cb.probeStop = make(chan struct{})
+	go cb.runProbe(cb.probeStop)
+}
+
+// stopProbe 停止探测的 goroutine，调用方需要持有锁
+func (cb *CircuitBreaker) stopProbe() {
+	if cb.probeStop != nil {
+		close(cb.probeStop)
+		cb.probeStop = nil
+	}
+}
+
+// runProbe 每隔 probeInterval 检查一次状态，直到 stop 被关闭
+func (cb *CircuitBreaker) runProbe(stop chan struct{}) {
+	timer := time.NewTimer(cb.probeInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		if !cb.probe(stop) {
+			return
+		}
+		timer.Reset(cb.probeInterval)
+	}
+}
+
+// probe 在半开状态下没有请求正在进行时执行一次 probeFunc，结果与普通请求一样计数。
+// 开启状态下只检查是否已到期，到期后 currentState 会变更为半开状态。
+// 返回 false 表示探测已经停止
+func (cb *CircuitBreaker) probe(stop chan struct{}) bool {
+	cb.mutex.Lock()
+	if cb.probeStop != stop {
+		// 已经停止，或者熔断器关闭后又开启，由新的 goroutine 负责探测
+		cb.mutex.Unlock()
+		return false
+	}
+
+	now := cb.clock.Now()
+	state, _ := cb.currentState(now)
+	if state != StateHalfOpen || cb.halfOpenInFlight > 0 || cb.halfOpenFull() {
+		cb.mutex.Unlock()
+		return true
+	}
+	generation, err := cb.beforeRequestAt(now)
+	cb.mutex.Unlock()
+	if err != nil {
+		return true
+	}
+
+	cb.runProbeFunc(generation)
+	return true
+}
+
+// probeTimeout 返回一次探测的最长时间，没有设置 requestTimeout 时使用 probeInterval
+func (cb *CircuitBreaker) probeTimeout() time.Duration {
+	if cb.requestTimeout > 0 {
+		return cb.requestTimeout
+	}
+	return cb.probeInterval
+}
+
+// runProbeFunc 在单独的 goroutine 中执行 probeFunc 并记录结果。
+// panic 不论 recoverPanic 如何都会恢复，避免后台 goroutine 让进程崩溃；
+// 超时记为失败，避免一直占用半开名额，超时后返回的结果会被丢弃
+func (cb *CircuitBreaker) runProbeFunc(generation uint64) {
+	timeout := cb.probeTimeout()
+	start := time.Now()
+	ch := make(chan callResult[struct{}], 1) // 带缓冲，超时后探测返回时不会阻塞
+	go func() {
+		_, panicVal, stack, err := callRecovered(func() (interface{}, error) { return nil, cb.probeFunc() })
+		ch <- callResult[struct{}]{err: err, panicked: stack != nil, panicVal: panicVal, stack: stack}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		if r.panicked {
+			cb.warnProbePanic(r.panicVal, r.stack)
+			cb.countPanic(generation)
+			return
+		}
+		cb.afterResult(generation, r.err, time.Since(start))
+	case <-timer.C:
+		cb.observeLatency(timeout)
+		cb.afterTimedRequest(generation, false, timeout, ErrRequestTimeout)
+	}
+}
+
+// warnProbePanic 记录 probeFunc 中发生的 panic
+func (cb *CircuitBreaker) warnProbePanic(panicVal interface{}, stack []byte) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.logger.Warnf("circuit breaker probe panicked: name=%q panic=%v\n%s", cb.name, panicVal, stack)
+}
@@ -0,0 +1,154 @@
+package gobreaker
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitState 等待熔断器变为 state，超时返回 false
+func waitState(cb *CircuitBreaker, state State) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cb.State() == state {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestProbeCloses(t *testing.T) {
+	var probes int32
+	cb := NewCircuitBreaker(Settings{
+		Timeout:       time.Duration(20) * time.Millisecond,
+		ProbeInterval: time.Duration(5) * time.Millisecond,
+		ProbeFunc: func() error {
+			atomic.AddInt32(&probes, 1)
+			return nil
+		},
+	})
+	defer cb.Close()
+
+	cb.Trip()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&probes))
+	assert.True(t, waitState(cb, StateClosed))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+
+	// closed: no more probes
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&probes))
+	cb.mutex.Lock()
+	assert.Nil(t, cb.probeStop)
+	cb.mutex.Unlock()
+}
+
+func TestProbeFails(t *testing.T) {
+	var healthy int32
+	var changes int32
+	cb := NewCircuitBreaker(Settings{
+		MaxRequests:   2,
+		Timeout:       time.Duration(20) * time.Millisecond,
+		ProbeInterval: time.Duration(5) * time.Millisecond,
+		ProbeFunc: func() error {
+			if atomic.LoadInt32(&healthy) == 0 {
+				return errors.New("down")
+			}
+			return nil
+		},
+		OnStateChange: func(string, State, State) { atomic.AddInt32(&changes, 1) },
+	})
+	defer cb.Close()
+
+	cb.Trip()
+	// open -> half-open -> open by the failed probe
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&changes) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, atomic.LoadInt32(&changes) >= 3)
+
+	// two successful probes are needed to close
+	atomic.StoreInt32(&healthy, 1)
+	assert.True(t, waitState(cb, StateClosed))
+}
+
+func TestProbeClose(t *testing.T) {
+	var probes int32
+	cb := NewCircuitBreaker(Settings{
+		InitialState:  StateHalfOpen,
+		MaxRequests:   1000,
+		ProbeInterval: time.Duration(5) * time.Millisecond,
+		ProbeFunc: func() error {
+			atomic.AddInt32(&probes, 1)
+			return nil
+		},
+	})
+
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	assert.Nil(t, cb.Close())
+	assert.Nil(t, cb.Close())
+	n := atomic.LoadInt32(&probes)
+	assert.True(t, n > 0)
+
+	time.Sleep(time.Duration(30) * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&probes))
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// a closed CircuitBreaker no longer starts probing
+	cb.Trip()
+	cb.mutex.Lock()
+	assert.Nil(t, cb.probeStop)
+	cb.mutex.Unlock()
+}
+
+// waitTrips 等待熔断器变为开启状态的次数达到 trips，超时返回 false
+func waitTrips(cb *CircuitBreaker, trips uint64) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cb.TripCount() >= trips {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestProbePanic(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		Timeout:       time.Duration(20) * time.Millisecond,
+		ProbeInterval: time.Duration(5) * time.Millisecond,
+		ProbeFunc:     func() error { panic("oops") },
+	})
+	defer cb.Close()
+
+	// the panic doesn't crash the process and counts as a failed probe
+	cb.Trip()
+	assert.True(t, waitTrips(cb, 2))
+}
+
+func TestProbeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	cb := NewCircuitBreaker(Settings{
+		Timeout:        time.Duration(20) * time.Millisecond,
+		ProbeInterval:  time.Duration(5) * time.Millisecond,
+		RequestTimeout: time.Duration(10) * time.Millisecond,
+		ProbeFunc: func() error {
+			<-release
+			return nil
+		},
+	})
+	defer cb.Close()
+
+	// a hung probe counts as a failure and gives its half-open slot back
+	cb.Trip()
+	assert.True(t, waitTrips(cb, 2))
+	assert.Equal(t, StateOpen, cb.State())
+	cb.mutex.Lock()
+	assert.Equal(t, uint32(0), cb.halfOpenInFlight)
+	cb.mutex.Unlock()
+}
@@ -0,0 +1,119 @@
+// Package prometheus exports metrics of gobreaker.CircuitBreaker to Prometheus.
+//
+// A Collector observes the results and state changes of breakers through
+// gobreaker.Settings.MetricsObserver, and reads the current state and Counts
+// of the breakers passed to Watch when it is collected:
+//
+//	collector := prometheus.NewCollector()
+//	st.MetricsObserver = collector
+//	cb := gobreaker.NewCircuitBreaker(st)
+//	collector.Watch(cb)
+//	collector.MustRegister(prom.DefaultRegisterer)
+package prometheus
+
+import (
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+// Collector is a prometheus.Collector and a gobreaker.MetricsObserver.
+type Collector struct {
+	requests     *prom.CounterVec
+	stateChanges *prom.CounterVec
+	state        *prom.Desc
+	counts       *prom.Desc
+
+	mutex    sync.Mutex
+	breakers []*gobreaker.CircuitBreaker
+}
+
+// NewCollector returns a new Collector with the following metrics:
+//
+//	gobreaker_requests_total{name, result}       completed requests by result ("success" or "failure")
+//	gobreaker_state_changes_total{name, from, to} state changes
+//	gobreaker_state{name}                        current state (0: closed, 1: half-open, 2: open)
+//	gobreaker_counts{name, count}                current Counts of the generation
+func NewCollector() *Collector {
+	return &Collector{
+		requests: prom.NewCounterVec(prom.CounterOpts{
+			Name: "gobreaker_requests_total",
+			Help: "Number of requests completed through the circuit breaker.",
+		}, []string{"name", "result"}),
+		stateChanges: prom.NewCounterVec(prom.CounterOpts{
+			Name: "gobreaker_state_changes_total",
+			Help: "Number of state changes of the circuit breaker.",
+		}, []string{"name", "from", "to"}),
+		state: prom.NewDesc("gobreaker_state",
+			"Current state of the circuit breaker (0: closed, 1: half-open, 2: open).",
+			[]string{"name"}, nil),
+		counts: prom.NewDesc("gobreaker_counts",
+			"Current counts of the circuit breaker, cleared on each new generation.",
+			[]string{"name", "count"}, nil),
+	}
+}
+
+// Watch adds cb to the breakers whose state and Counts are exported.
+func (c *Collector) Watch(cb *gobreaker.CircuitBreaker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.breakers = append(c.breakers, cb)
+}
+
+// MustRegister registers the Collector with r and panics on error.
+func (c *Collector) MustRegister(r prom.Registerer) {
+	r.MustRegister(c)
+}
+
+// ObserveResult implements gobreaker.MetricsObserver.
+func (c *Collector) ObserveResult(name string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	c.requests.WithLabelValues(name, result).Inc()
+}
+
+// ObserveStateChange implements gobreaker.MetricsObserver.
+func (c *Collector) ObserveStateChange(name string, from gobreaker.State, to gobreaker.State) {
+	c.stateChanges.WithLabelValues(name, from.String(), to.String()).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.requests.Describe(ch)
+	c.stateChanges.Describe(ch)
+	ch <- c.state
+	ch <- c.counts
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.requests.Collect(ch)
+	c.stateChanges.Collect(ch)
+
+	c.mutex.Lock()
+	breakers := append([]*gobreaker.CircuitBreaker(nil), c.breakers...)
+	c.mutex.Unlock()
+
+	for _, cb := range breakers {
+		name := cb.Name()
+		ch <- prom.MustNewConstMetric(c.state, prom.GaugeValue, float64(cb.State()), name)
+
+		counts := cb.Counts()
+		for _, v := range []struct {
+			count string
+			value uint32
+		}{
+			{"requests", counts.Requests},
+			{"total_successes", counts.TotalSuccesses},
+			{"total_failures", counts.TotalFailures},
+			{"consecutive_successes", counts.ConsecutiveSuccesses},
+			{"consecutive_failures", counts.ConsecutiveFailures},
+		} {
+			ch <- prom.MustNewConstMetric(c.counts, prom.GaugeValue, float64(v.value), name, v.count)
+		}
+	}
+}
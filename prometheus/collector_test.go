@@ -0,0 +1,56 @@
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{Name: "cb", MetricsObserver: c})
+	c.Watch(cb)
+
+	reg := prom.NewRegistry()
+	c.MustRegister(reg)
+
+	_, err := cb.Execute(func() (interface{}, error) { return nil, nil })
+	assert.Nil(t, err)
+	for i := 0; i < 6; i++ {
+		cb.Execute(func() (interface{}, error) { return nil, fmt.Errorf("fail") })
+	}
+	assert.Equal(t, gobreaker.StateOpen, cb.State())
+
+	expected := `
+# HELP gobreaker_requests_total Number of requests completed through the circuit breaker.
+# TYPE gobreaker_requests_total counter
+gobreaker_requests_total{name="cb",result="failure"} 6
+gobreaker_requests_total{name="cb",result="success"} 1
+# HELP gobreaker_state Current state of the circuit breaker (0: closed, 1: half-open, 2: open).
+# TYPE gobreaker_state gauge
+gobreaker_state{name="cb"} 2
+# HELP gobreaker_state_changes_total Number of state changes of the circuit breaker.
+# TYPE gobreaker_state_changes_total counter
+gobreaker_state_changes_total{from="closed",name="cb",to="open"} 1
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"gobreaker_requests_total", "gobreaker_state", "gobreaker_state_changes_total"))
+
+	cb.Reset()
+	cb.Execute(func() (interface{}, error) { return nil, fmt.Errorf("fail") })
+	expected = `
+# HELP gobreaker_counts Current counts of the circuit breaker, cleared on each new generation.
+# TYPE gobreaker_counts gauge
+gobreaker_counts{count="consecutive_failures",name="cb"} 1
+gobreaker_counts{count="consecutive_successes",name="cb"} 0
+gobreaker_counts{count="requests",name="cb"} 1
+gobreaker_counts{count="total_failures",name="cb"} 1
+gobreaker_counts{count="total_successes",name="cb"} 0
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "gobreaker_counts"))
+}
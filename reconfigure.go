@@ -0,0 +1,212 @@
+package gobreaker
+
+import "time"
+
+// Reconfigure updates the configuration of a live CircuitBreaker with st,
+// applying the same defaults as NewCircuitBreaker, without changing its state or Counts.
+//
+// The following fields of st take effect:
+// MaxRequests, SuccessThreshold, HalfOpenSuccessRatio, HalfOpenSampleSize, HalfOpenAdmitRatio,
+// HalfOpenWait, FairHalfOpen, Interval, IntervalJitter, RandFunc, ClearAfterConsecutiveSuccesses, MaxOpenDuration,
+// Timeout, BackoffExpiry, ReadyToTrip, ConsecutiveFailuresThreshold, EvaluateOnSuccess, TripImmediately,
+// TripMargin, NearTripMargin, OnNearTrip, BeforeStateChange, OnStateChange, OnStateChangeWithCounts, OnHalfOpen,
+// OnSuccess, OnFailure, OnReject, RejectionError, OnAdmit, AdmissionFunc, AttributeStaleToCurrentGeneration,
+// MaxConcurrent, SlowCallDuration, StuckOpenAfter, OnStuckOpen, MetricsObserver, Logger, EventBufferSize,
+// DropOldestEvents and ResetDependents.
+// All other fields are ignored, either because they define the structure of the CircuitBreaker,
+// such as Name, InitialState, Clock, WindowType, MinimumSamples, HalfLife and TrackLatency,
+// or because they are read without the lock while requests run,
+// such as IsSuccessful, ClassifyError, IsSuccessfulResult, RequestTimeout, Retry, Fallback and ProbeFunc.
+//
+// A new Timeout or BackoffExpiry applies from the next time the CircuitBreaker opens.
+// A new StuckOpenAfter applies at once, still counted from the time the CircuitBreaker left the closed state.
+// A new Interval applies from the next generation, except that in the closed state
+// the current generation ends no later than the new Interval from now,
+// and doesn't end at all if the new Interval is 0.
+// Interval is ignored if WindowType is WindowTypeCount or WindowTypeTime.
+func (cb *CircuitBreaker) Reconfigure(st Settings) {
+	n := new(CircuitBreaker)
+	n.configure(st)
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.maxRequests = n.maxRequests
+	cb.successThreshold = n.successThreshold
+	cb.limitInFlight = n.limitInFlight
+	cb.halfOpenSuccessRatio = n.halfOpenSuccessRatio
+	cb.halfOpenSampleSize = n.halfOpenSampleSize
+	cb.halfOpenAdmitRatio = n.halfOpenAdmitRatio
+	cb.halfOpenWait = n.halfOpenWait
+	cb.fairHalfOpen = n.fairHalfOpen
+	cb.random = n.random
+
+	if cb.window == nil {
+		cb.reconfigureInterval(n, cb.clock.Now())
+	}
+	cb.clearAfterConsecutiveSuccesses = n.clearAfterConsecutiveSuccesses
+
+	cb.maxOpenDuration = n.maxOpenDuration
+	cb.timeout = n.timeout
+	cb.backoffExpiry = n.backoffExpiry
+
+	cb.readyToTrip = n.readyToTrip
+	cb.evaluateOnSuccess = n.evaluateOnSuccess
+	cb.tripImmediately = n.tripImmediately
+	cb.tripMargin = n.tripMargin
+	cb.nearTripMargin = n.nearTripMargin
+	cb.onNearTrip = n.onNearTrip
+
+	cb.beforeStateChange = n.beforeStateChange
+	cb.onStateChange = n.onStateChange
+	cb.onStateChangeWithCounts = n.onStateChangeWithCounts
+	cb.onHalfOpen = n.onHalfOpen
+	cb.onSuccessHook = n.onSuccessHook
+	cb.onFailureHook = n.onFailureHook
+	cb.onReject = n.onReject
+	cb.rejectionError = n.rejectionError
+	cb.onAdmit = n.onAdmit
+
+	cb.admissionFunc = n.admissionFunc
+	cb.attributeStaleToCurrentGeneration = n.attributeStaleToCurrentGeneration
+	cb.maxConcurrent = n.maxConcurrent
+	cb.slowCallDuration = n.slowCallDuration
+	cb.metricsObserver = n.metricsObserver
+	cb.logger = n.logger
+	cb.eventBufferSize = n.eventBufferSize
+	cb.dropOldestEvents = n.dropOldestEvents
+	cb.resetDependents = n.resetDependents
+
+	// 开启状态下按新的设置重新设置定时器，仍从进入开启状态时计算
+	cb.stuckOpenAfter = n.stuckOpenAfter
+	cb.onStuckOpen = n.onStuckOpen
+	cb.armStuckOpen()
+
+	// 半开状态下的名额可能变多，唤醒等待的请求重新检查
+	cb.wakeHalfOpenWaiters()
+}
+
+// reconfigureInterval 更新周期的设置，关闭状态下让当前周期最迟在新的周期长度后结束，调用方需要持有锁
+func (cb *CircuitBreaker) reconfigureInterval(n *CircuitBreaker, now time.Time) {
+	changed := cb.interval != n.interval
+	cb.interval = n.interval
+	cb.intervalJitter = n.intervalJitter
+
+	if !changed || cb.state != StateClosed {
+		return
+	}
+	if cb.interval == 0 {
+		cb.expiry = time.Time{}
+	} else if expiry := now.Add(cb.nextInterval()); cb.expiry.IsZero() || expiry.Before(cb.expiry) {
+		cb.expiry = expiry
+	}
+}
+
+// Settings returns the configuration in effect in the CircuitBreaker, after NewCircuitBreaker and Reconfigure
+// have applied their defaults, e.g. a Timeout of 60 seconds if none was given.
+// It is meant for diagnostics, and NewCircuitBreaker(cb.Settings()) returns a CircuitBreaker
+// with the same configuration.
+//
+// The function and interface fields hold the functions and values that the CircuitBreaker calls,
+// including the built-in defaults, so ReadyToTrip, IsSuccessful, RandFunc, Logger and Clock are never nil.
+// A field that only selects a default is folded into the field it configures:
+// ConsecutiveFailuresThreshold is 0 and the ReadyToTrip built from it is returned instead.
+// Likewise, SuccessThreshold is 0 when MaxRequests is used in its place,
+// Interval and HalfLife are 0 with a sliding window, and IntervalJitter is 0 without Interval.
+// InitialState is StateClosed, since the state is not part of the configuration; see State and Snapshot.
+// Functions can't be compared or encoded, so to expose the configuration, e.g. as JSON,
+// copy the other fields or report only whether a function field is nil.
+func (cb *CircuitBreaker) Settings() Settings {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	panicCountsAsFailure := !cb.ignorePanics
+	st := Settings{
+		Name:                              cb.name,
+		MaxRequests:                       cb.maxRequests,
+		HalfOpenAdmitRatio:                cb.halfOpenAdmitRatio,
+		HalfOpenWait:                      cb.halfOpenWait,
+		FairHalfOpen:                      cb.fairHalfOpen,
+		Interval:                          cb.interval,
+		RandFunc:                          cb.random,
+		ClearAfterConsecutiveSuccesses:    cb.clearAfterConsecutiveSuccesses,
+		MaxOpenDuration:                   cb.maxOpenDuration,
+		StuckOpenAfter:                    cb.stuckOpenAfter,
+		OnStuckOpen:                       cb.onStuckOpen,
+		Timeout:                           cb.timeout,
+		BackoffExpiry:                     cb.backoffExpiry,
+		ReadyToTrip:                       cb.readyToTrip,
+		EvaluateOnSuccess:                 cb.evaluateOnSuccess,
+		TripImmediately:                   cb.tripImmediately,
+		OnStateChange:                     cb.onStateChange,
+		BeforeStateChange:                 cb.beforeStateChange,
+		TwoStepCallbackTimeout:            cb.twoStepCallbackTimeout,
+		OnHalfOpen:                        cb.onHalfOpen,
+		OnSuccess:                         cb.onSuccessHook,
+		OnFailure:                         cb.onFailureHook,
+		OnReject:                          cb.onReject,
+		RejectionError:                    cb.rejectionError,
+		OnAdmit:                           cb.onAdmit,
+		Fallback:                          cb.fallback,
+		RecoverPanic:                      cb.recoverPanic,
+		PanicCountsAsFailure:              &panicCountsAsFailure,
+		OnStateChangeWithCounts:           cb.onStateChangeWithCounts,
+		IsSuccessful:                      cb.isSuccessful,
+		ClassifyError:                     cb.classifyError,
+		IsSuccessfulResult:                cb.isSuccessfulResult,
+		OnNearTrip:                        cb.onNearTrip,
+		NearTripMargin:                    cb.nearTripMargin,
+		TripMargin:                        cb.tripMargin,
+		AdmissionFunc:                     cb.admissionFunc,
+		TrackAdmissionLatency:             cb.trackAdmissionLatency,
+		AttributeStaleToCurrentGeneration: cb.attributeStaleToCurrentGeneration,
+		HalfLife:                          cb.halfLife,
+		IgnoreContextErrors:               cb.ignoreContextErrors,
+		IgnoreContextCancellation:         cb.ignoreContextCancellation,
+		RequestTimeout:                    cb.requestTimeout,
+		MetricsObserver:                   cb.metricsObserver,
+		Logger:                            cb.logger,
+		SlowCallDuration:                  cb.slowCallDuration,
+		SharedStateStore:                  cb.sharedStore,
+		Retry:                             cb.retry,
+		MaxConcurrent:                     cb.maxConcurrent,
+		HedgeDelay:                        cb.hedgeDelay,
+		ProbeFunc:                         cb.probeFunc,
+		ProbeInterval:                     cb.probeInterval,
+		Clock:                             cb.clock,
+		EventBufferSize:                   cb.eventBufferSize,
+		DropOldestEvents:                  cb.dropOldestEvents,
+		ResetDependents:                   cb.resetDependents,
+	}
+
+	// 没有设置 SuccessThreshold 时 successThreshold 等于 maxRequests，返回 0 才能保持原来的含义
+	if cb.halfOpenSuccessRatio > 0 {
+		st.HalfOpenSuccessRatio = cb.halfOpenSuccessRatio
+		st.HalfOpenSampleSize = cb.halfOpenSampleSize
+	} else if cb.limitInFlight {
+		st.SuccessThreshold = cb.successThreshold
+	}
+
+	if cb.interval > 0 {
+		st.IntervalJitter = cb.intervalJitter
+	}
+
+	switch w := cb.window.(type) {
+	case *countWindow:
+		st.WindowType = WindowTypeCount
+		st.WindowSize = uint32(len(w.outcomes))
+	case *timeWindow:
+		st.WindowType = WindowTypeTime
+		st.RollingWindow = w.width * time.Duration(len(w.buckets))
+		st.BucketCount = len(w.buckets)
+	}
+	if cb.recent != nil {
+		st.MinimumSamples = uint32(len(cb.recent.outcomes))
+	}
+	if cb.latency != nil {
+		st.TrackLatency = true
+		st.LatencyBuckets = append([]time.Duration(nil), cb.latency.bounds...)
+		st.ResetLatency = cb.resetLatency
+	}
+	return st
+}
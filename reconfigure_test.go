@@ -0,0 +1,126 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconfigure(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "live"})
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(cb))
+	}
+
+	// state and counts are kept, and the new ReadyToTrip is used right away
+	var changes int
+	cb.Reconfigure(Settings{
+		Name:        "ignored",
+		MaxRequests: 2,
+		Timeout:     time.Duration(10) * time.Second,
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= 4
+		},
+		OnStateChange: func(string, State, State) { changes++ },
+	})
+	assert.Equal(t, "live", cb.Name())
+	assert.Equal(t, newCounts(3, 0, 3, 0, 3), cb.Counts())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, 1, changes)
+
+	// the new Timeout and MaxRequests apply
+	pseudoSleep(cb, time.Duration(10)*time.Second)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+
+	// defaults are applied as in NewCircuitBreaker
+	cb.Reconfigure(Settings{})
+	cb.mutex.Lock()
+	assert.Equal(t, uint32(1), cb.maxRequests)
+	assert.Equal(t, defaultTimeout, cb.timeout)
+	cb.mutex.Unlock()
+}
+
+func TestReconfigureInterval(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	assert.True(t, cb.ExpiresAt().IsZero())
+
+	// the current generation ends after the new Interval
+	before := time.Now()
+	cb.Reconfigure(Settings{Interval: time.Duration(30) * time.Second})
+	assert.True(t, !cb.ExpiresAt().Before(before.Add(time.Duration(30)*time.Second)))
+
+	// a shorter Interval brings the end forward, a longer one doesn't postpone it
+	cb.Reconfigure(Settings{Interval: time.Duration(10) * time.Second})
+	expiry := cb.ExpiresAt()
+	assert.True(t, expiry.Before(before.Add(time.Duration(11)*time.Second)))
+	cb.Reconfigure(Settings{Interval: time.Minute})
+	assert.Equal(t, expiry, cb.ExpiresAt())
+
+	cb.Reconfigure(Settings{})
+	assert.True(t, cb.ExpiresAt().IsZero())
+
+	// ignored with a sliding window
+	cb = NewCircuitBreaker(Settings{WindowType: WindowTypeCount})
+	cb.Reconfigure(Settings{Interval: time.Minute})
+	assert.True(t, cb.ExpiresAt().IsZero())
+}
+
+func TestSettings(t *testing.T) {
+	st := NewCircuitBreaker(Settings{Name: "effective"}).Settings()
+	assert.Equal(t, "effective", st.Name)
+	assert.Equal(t, uint32(1), st.MaxRequests)
+	assert.Equal(t, uint32(0), st.SuccessThreshold)
+	assert.Equal(t, time.Duration(60)*time.Second, st.Timeout)
+	assert.Equal(t, 0.2, st.NearTripMargin)
+	assert.Equal(t, 16, st.EventBufferSize)
+	assert.NotNil(t, st.ReadyToTrip)
+	assert.NotNil(t, st.IsSuccessful)
+	assert.NotNil(t, st.TripMargin)
+	assert.NotNil(t, st.RandFunc)
+	assert.NotNil(t, st.Logger)
+	assert.NotNil(t, st.Clock)
+	assert.Nil(t, st.OnStateChange)
+	assert.True(t, *st.PanicCountsAsFailure)
+	assert.Equal(t, WindowTypeGeneration, st.WindowType)
+	assert.False(t, st.TrackLatency)
+
+	// ConsecutiveFailuresThreshold is folded into ReadyToTrip
+	st = NewCircuitBreaker(Settings{ConsecutiveFailuresThreshold: 3}).Settings()
+	assert.Equal(t, uint32(0), st.ConsecutiveFailuresThreshold)
+	assert.False(t, st.ReadyToTrip(Counts{ConsecutiveFailures: 2}))
+	assert.True(t, st.ReadyToTrip(Counts{ConsecutiveFailures: 3}))
+
+	st = NewCircuitBreaker(Settings{
+		MaxRequests:    2,
+		Interval:       time.Minute,
+		IntervalJitter: time.Hour,
+		WindowType:     WindowTypeTime,
+		RollingWindow:  time.Duration(10) * time.Second,
+		BucketCount:    5,
+		MinimumSamples: 20,
+		TrackLatency:   true,
+		LatencyBuckets: []time.Duration{time.Second, time.Millisecond},
+	}).Settings()
+	assert.Equal(t, time.Duration(0), st.Interval) // ignored with a sliding window
+	assert.Equal(t, time.Duration(0), st.IntervalJitter)
+	assert.Equal(t, WindowTypeTime, st.WindowType)
+	assert.Equal(t, time.Duration(10)*time.Second, st.RollingWindow)
+	assert.Equal(t, 5, st.BucketCount)
+	assert.Equal(t, uint32(20), st.MinimumSamples)
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Second}, st.LatencyBuckets)
+
+	// a CircuitBreaker created from the effective Settings has the same configuration
+	cb := NewCircuitBreaker(Settings{Name: "copy", SuccessThreshold: 3, HalfOpenWait: time.Second, WindowType: WindowTypeCount, WindowSize: 7})
+	copied := NewCircuitBreaker(cb.Settings())
+	assert.Equal(t, cb.Settings().SuccessThreshold, copied.Settings().SuccessThreshold)
+	assert.Equal(t, uint32(3), copied.successThreshold)
+	assert.True(t, copied.limitInFlight)
+	assert.Equal(t, time.Second, copied.halfOpenWait)
+	assert.Equal(t, uint32(7), copied.Settings().WindowSize)
+}
@@ -0,0 +1,142 @@
+// Package redisstore implements gobreaker.SharedStateStore on Redis,
+// so that CircuitBreakers with the same name share their state across processes.
+package redisstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+)
+
+// defaultTimeout is the default timeout of each Redis operation.
+const defaultTimeout = time.Duration(100) * time.Millisecond
+
+// incrCounts atomically updates the counts hash and sets its TTL when it is created.
+var incrCounts = redis.NewScript(`
+local key = KEYS[1]
+local success = ARGV[1] == "1"
+local ttl = tonumber(ARGV[2])
+
+local created = redis.call("EXISTS", key) == 0
+redis.call("HINCRBY", key, "requests", 1)
+if success then
+	redis.call("HINCRBY", key, "total_successes", 1)
+	redis.call("HINCRBY", key, "consecutive_successes", 1)
+	redis.call("HSET", key, "consecutive_failures", 0)
+else
+	redis.call("HINCRBY", key, "total_failures", 1)
+	redis.call("HINCRBY", key, "consecutive_failures", 1)
+	redis.call("HSET", key, "consecutive_successes", 0)
+end
+if created and ttl > 0 then
+	redis.call("PEXPIRE", key, ttl)
+end
+
+return redis.call("HMGET", key, "requests", "total_successes", "total_failures",
+	"consecutive_successes", "consecutive_failures")
+`)
+
+// Store is a gobreaker.SharedStateStore backed by Redis.
+type Store struct {
+	client  redis.UniversalClient
+	prefix  string
+	timeout time.Duration
+}
+
+// New returns a new Store that uses client.
+// Keys are prefixed with "gobreaker:" and each operation times out after 100 milliseconds.
+func New(client redis.UniversalClient) *Store {
+	return &Store{
+		client:  client,
+		prefix:  "gobreaker:",
+		timeout: defaultTimeout,
+	}
+}
+
+// WithPrefix sets the prefix of the keys and returns the Store.
+func (s *Store) WithPrefix(prefix string) *Store {
+	s.prefix = prefix
+	return s
+}
+
+// WithTimeout sets the timeout of each operation and returns the Store.
+func (s *Store) WithTimeout(timeout time.Duration) *Store {
+	s.timeout = timeout
+	return s
+}
+
+func (s *Store) stateKey(name string) string {
+	return s.prefix + name + ":state"
+}
+
+func (s *Store) countsKey(name string) string {
+	return s.prefix + name + ":counts"
+}
+
+// GetState implements gobreaker.SharedStateStore.
+func (s *Store) GetState(name string) (gobreaker.State, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	v, err := s.client.Get(ctx, s.stateKey(name)).Int()
+	if err == redis.Nil {
+		return gobreaker.StateClosed, nil
+	} else if err != nil {
+		return gobreaker.StateClosed, err
+	}
+	return gobreaker.State(v), nil
+}
+
+// SetState implements gobreaker.SharedStateStore.
+// Storing the closed state deletes the key instead.
+func (s *Store) SetState(name string, state gobreaker.State, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if state == gobreaker.StateClosed {
+			pipe.Del(ctx, s.stateKey(name))
+		} else {
+			pipe.Set(ctx, s.stateKey(name), int(state), ttl)
+		}
+		pipe.Del(ctx, s.countsKey(name))
+		return nil
+	})
+	return err
+}
+
+// IncrCounts implements gobreaker.SharedStateStore.
+func (s *Store) IncrCounts(name string, success bool, ttl time.Duration) (gobreaker.Counts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	flag := "0"
+	if success {
+		flag = "1"
+	}
+	v, err := incrCounts.Run(ctx, s.client, []string{s.countsKey(name)}, flag, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return gobreaker.Counts{}, err
+	}
+
+	var values [5]uint32
+	for i := range values {
+		if str, ok := v[i].(string); ok {
+			n, err := strconv.ParseUint(str, 10, 32)
+			if err != nil {
+				return gobreaker.Counts{}, err
+			}
+			values[i] = uint32(n)
+		}
+	}
+	return gobreaker.Counts{
+		Requests:             values[0],
+		TotalSuccesses:       values[1],
+		TotalFailures:        values[2],
+		ConsecutiveSuccesses: values[3],
+		ConsecutiveFailures:  values[4],
+	}, nil
+}
@@ -0,0 +1,81 @@
+package redisstore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	return New(redis.NewClient(&redis.Options{Addr: mr.Addr()})), mr
+}
+
+func TestStore(t *testing.T) {
+	s, mr := newStore(t)
+
+	state, err := s.GetState("cb")
+	assert.Nil(t, err)
+	assert.Equal(t, gobreaker.StateClosed, state)
+
+	counts, err := s.IncrCounts("cb", false, time.Duration(30)*time.Second)
+	assert.Nil(t, err)
+	counts, err = s.IncrCounts("cb", false, time.Duration(30)*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, gobreaker.Counts{Requests: 2, TotalFailures: 2, ConsecutiveFailures: 2}, counts)
+	counts, err = s.IncrCounts("cb", true, time.Duration(30)*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, gobreaker.Counts{Requests: 3, TotalSuccesses: 1, TotalFailures: 2, ConsecutiveSuccesses: 1}, counts)
+
+	// the counts expire after the interval from their creation
+	mr.FastForward(time.Duration(31) * time.Second)
+	counts, err = s.IncrCounts("cb", true, time.Duration(30)*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), counts.Requests)
+
+	assert.Nil(t, s.SetState("cb", gobreaker.StateOpen, time.Duration(60)*time.Second))
+	state, err = s.GetState("cb")
+	assert.Nil(t, err)
+	assert.Equal(t, gobreaker.StateOpen, state)
+	assert.False(t, mr.Exists("gobreaker:cb:counts"))
+
+	mr.FastForward(time.Duration(61) * time.Second)
+	state, err = s.GetState("cb")
+	assert.Nil(t, err)
+	assert.Equal(t, gobreaker.StateClosed, state)
+}
+
+func TestSharedBreakers(t *testing.T) {
+	s, mr := newStore(t)
+	st := gobreaker.Settings{Name: "fleet", SharedStateStore: s}
+	a := gobreaker.NewCircuitBreaker(st)
+	b := gobreaker.NewCircuitBreaker(st)
+	fail := func(cb *gobreaker.CircuitBreaker) {
+		cb.Execute(func() (interface{}, error) { return nil, fmt.Errorf("fail") })
+	}
+
+	for i := 0; i < 3; i++ {
+		fail(a)
+		fail(b)
+	}
+	assert.Equal(t, gobreaker.StateOpen, b.State())
+	_, err := a.Execute(func() (interface{}, error) { return nil, nil })
+	assert.True(t, errors.Is(err, gobreaker.ErrOpenState))
+
+	// Redis is down: the breakers keep working locally
+	addr := mr.Addr()
+	mr.Close()
+	c := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:             "fleet",
+		SharedStateStore: New(redis.NewClient(&redis.Options{Addr: addr})).WithTimeout(time.Duration(10) * time.Millisecond),
+	})
+	_, err = c.Execute(func() (interface{}, error) { return nil, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, gobreaker.StateClosed, c.State())
+}
@@ -0,0 +1,200 @@
+package gobreaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BreakerStatus is the status of a CircuitBreaker in a Registry at one point in time.
+// Expiry is the time of the next scheduled transition, as returned by ExpiresAt.
+type BreakerStatus struct {
+	Name   string
+	State  State
+	Counts Counts
+	Expiry time.Time
+}
+
+// Registry holds named CircuitBreakers and is safe for concurrent use.
+//
+// OnStateChange, if not nil, is called whenever the state of a CircuitBreaker created by
+// the Registry changes, after the OnStateChange of its own Settings.
+// Set it before creating CircuitBreakers.
+type Registry struct {
+	OnStateChange func(name string, from State, to State)
+
+	mutex    sync.RWMutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry returns a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// GetOrCreate returns the CircuitBreaker registered under name.
+// If there is none, it creates one with st, whose Name is set to name, and registers it.
+func (r *Registry) GetOrCreate(name string, st Settings) *CircuitBreaker {
+	if cb, ok := r.Get(name); ok {
+		return cb
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// 加写锁期间可能已经被其他 goroutine 创建
+	if cb, ok := r.breakers[name]; ok {
+		return cb
+	}
+
+	st.Name = name
+	if r.OnStateChange != nil {
+		onStateChange, registryOnStateChange := st.OnStateChange, r.OnStateChange
+		st.OnStateChange = func(name string, from State, to State) {
+			if onStateChange != nil {
+				onStateChange(name, from, to)
+			}
+			registryOnStateChange(name, from, to)
+		}
+	}
+
+	cb := NewCircuitBreaker(st)
+	r.breakers[name] = cb
+	return cb
+}
+
+// Get returns the CircuitBreaker registered under name, if any.
+func (r *Registry) Get(name string) (*CircuitBreaker, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	cb, ok := r.breakers[name]
+	return cb, ok
+}
+
+// All returns all registered CircuitBreakers sorted by name.
+func (r *Registry) All() []*CircuitBreaker {
+	all := r.breakerList()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+	return all
+}
+
+// Snapshot returns the status of all registered CircuitBreakers sorted by name.
+// The status of each CircuitBreaker is read at once under its lock,
+// so its state, Counts and expiry are consistent with each other.
+func (r *Registry) Snapshot() []BreakerStatus {
+	all := r.breakerList()
+	statuses := make([]BreakerStatus, len(all))
+	for i, cb := range all {
+		statuses[i] = cb.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// AnyOpen reports whether any registered CircuitBreaker is open.
+func (r *Registry) AnyOpen() bool {
+	for _, cb := range r.breakerList() {
+		if cb.State() == StateOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// ShutdownError is returned by Registry.Shutdown when some CircuitBreakers still had requests
+// in flight when the context was done. Names are the names of those CircuitBreakers, sorted,
+// and Err is the error of the context, so errors.Is(err, context.DeadlineExceeded) works as with Drain.
+type ShutdownError struct {
+	Names []string
+	Err   error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("gobreaker: circuit breakers not drained: %s: %v", strings.Join(e.Names, ", "), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown drains and then closes every registered CircuitBreaker, for a graceful shutdown.
+//
+// First all CircuitBreakers stop admitting new requests at once, as Drain does,
+// and Shutdown waits until none of them has a request in flight, or until ctx is done.
+// Then it closes all of them with Close, whether they have drained or not,
+// so their background resources are released either way; the requests still in flight
+// may finish afterwards and are recorded as usual. Shutdown returns nil if every CircuitBreaker has drained in time,
+// or a *ShutdownError listing those that haven't.
+//
+// The CircuitBreakers stay registered, so GetOrCreate returns a closed CircuitBreaker after Shutdown.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	all := r.breakerList()
+	errs := make([]error, len(all))
+
+	// 同时开始排空，所有熔断器都立即停止放行新的请求
+	var wg sync.WaitGroup
+	for i, cb := range all {
+		wg.Add(1)
+		go func(i int, cb *CircuitBreaker) {
+			defer wg.Done()
+			errs[i] = cb.Drain(ctx)
+		}(i, cb)
+	}
+	wg.Wait()
+
+	var names []string
+	var ctxErr error
+	for i, cb := range all {
+		if errs[i] != nil {
+			names = append(names, cb.Name())
+			ctxErr = errs[i]
+		}
+		cb.Close()
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return &ShutdownError{Names: names, Err: ctxErr}
+}
+
+// breakerList 返回所有熔断器的副本，不持有 Registry 的锁访问熔断器，
+// 避免与在熔断器锁内调用的 OnStateChange 互相等待
+func (r *Registry) breakerList() []*CircuitBreaker {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	all := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, cb := range r.breakers {
+		all = append(all, cb)
+	}
+	return all
+}
+
+// status 在一次加锁中读取熔断器的状态、计数和过期时间
+func (cb *CircuitBreaker) status() BreakerStatus {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	state, _ := cb.currentState(now)
+	if cb.window != nil && cb.state == StateClosed {
+		cb.syncWindow(now)
+	}
+	return BreakerStatus{
+		Name:   cb.name,
+		State:  state,
+		Counts: cb.counts,
+		Expiry: cb.expiry,
+	}
+}
@@ -0,0 +1,149 @@
+package gobreaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	var changes []stateTransition
+	var own int
+	r := NewRegistry()
+	r.OnStateChange = func(name string, from State, to State) {
+		changes = append(changes, stateTransition{name, from, to})
+	}
+
+	b := r.GetOrCreate("b", Settings{OnStateChange: func(string, State, State) { own++ }})
+	a := r.GetOrCreate("a", Settings{Name: "ignored"})
+	assert.Equal(t, "a", a.Name())
+	assert.True(t, b == r.GetOrCreate("b", Settings{}))
+
+	cb, ok := r.Get("a")
+	assert.True(t, ok)
+	assert.True(t, a == cb)
+	_, ok = r.Get("c")
+	assert.False(t, ok)
+
+	assert.Equal(t, []*CircuitBreaker{a, b}, r.All())
+
+	b.Trip()
+	a.Trip()
+	assert.Equal(t, []stateTransition{{"b", StateClosed, StateOpen}, {"a", StateClosed, StateOpen}}, changes)
+	assert.Equal(t, 1, own)
+}
+
+func TestRegistryInParallel(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	breakers := make([]*CircuitBreaker, 100)
+	for i := range breakers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			breakers[i] = r.GetOrCreate("shared", Settings{})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, cb := range breakers {
+		assert.True(t, breakers[0] == cb)
+	}
+	assert.Len(t, r.All(), 1)
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	r := NewRegistry()
+	assert.Equal(t, []BreakerStatus{}, r.Snapshot())
+	assert.False(t, r.AnyOpen())
+
+	b := r.GetOrCreate("b", Settings{})
+	a := r.GetOrCreate("a", Settings{})
+	assert.Nil(t, succeed(a))
+	assert.False(t, r.AnyOpen())
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(b))
+	}
+	assert.True(t, r.AnyOpen())
+
+	statuses := r.Snapshot()
+	assert.Equal(t, 2, len(statuses))
+	assert.Equal(t, BreakerStatus{Name: "a", State: StateClosed, Counts: newCounts(1, 1, 0, 1, 0)}, statuses[0])
+	assert.Equal(t, "b", statuses[1].Name)
+	assert.Equal(t, StateOpen, statuses[1].State)
+	assert.True(t, statuses[1].Expiry.Equal(b.ExpiresAt()))
+}
+
+// blockRequest 在 cb 中执行一个请求，返回请求开始后才返回，关闭 release 时请求结束
+func blockRequest(cb *CircuitBreaker, release chan struct{}) chan error {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := cb.Execute(func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		done <- err
+	}()
+	<-started
+	return done
+}
+
+func TestRegistryShutdown(t *testing.T) {
+	r := NewRegistry()
+	a := r.GetOrCreate("a", Settings{})
+	b := r.GetOrCreate("b", Settings{})
+
+	release := make(chan struct{})
+	done := blockRequest(a, release)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- r.Shutdown(context.Background()) }()
+
+	// 排空期间所有熔断器都不再放行新的请求
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.True(t, errors.Is(succeed(a), ErrDraining))
+	assert.True(t, errors.Is(succeed(b), ErrDraining))
+	assert.Equal(t, 0, len(shutdown))
+
+	close(release)
+	assert.Nil(t, <-done)
+	assert.Nil(t, <-shutdown)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), a.Counts())
+
+	// 排空后关闭
+	assert.True(t, errors.Is(succeed(a), ErrBreakerClosed))
+	assert.True(t, errors.Is(succeed(b), ErrBreakerClosed))
+}
+
+func TestRegistryShutdownTimeout(t *testing.T) {
+	r := NewRegistry()
+	a := r.GetOrCreate("a", Settings{})
+	b := r.GetOrCreate("b", Settings{})
+	c := r.GetOrCreate("c", Settings{})
+
+	release := make(chan struct{})
+	doneA := blockRequest(a, release)
+	doneC := blockRequest(c, release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(20)*time.Millisecond)
+	defer cancel()
+	err := r.Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	var shutdownErr *ShutdownError
+	assert.True(t, errors.As(err, &shutdownErr))
+	assert.Equal(t, []string{"a", "c"}, shutdownErr.Names)
+
+	// 没有排空的熔断器同样被关闭，正在进行的请求之后仍可以结束
+	assert.True(t, errors.Is(succeed(a), ErrBreakerClosed))
+	assert.True(t, errors.Is(succeed(b), ErrBreakerClosed))
+	close(release)
+	assert.Nil(t, <-doneA)
+	assert.Nil(t, <-doneC)
+}
@@ -0,0 +1,107 @@
+package gobreaker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetrySettings configures how Execute and ExecuteContext retry a failed request:
+//
+// MaxAttempts is the maximum number of attempts, including the first one.
+// If MaxAttempts is less than or equal to 1, requests are not retried.
+//
+// Backoff is called with the number of the failed attempt, starting at 1,
+// and returns how long to wait before the next attempt.
+// If Backoff is nil, the next attempt is made immediately.
+// ExponentialBackoff can also be used here.
+//
+// CountAttempts selects how the CircuitBreaker counts a retried request.
+// If CountAttempts is false, the whole operation passes through the CircuitBreaker once:
+// it is admitted once and only its final outcome is counted.
+// Retrying stops early if the CircuitBreaker becomes open during the operation,
+// and the error of the last attempt is returned.
+// RequestTimeout then limits the whole operation.
+// If CountAttempts is true, every attempt passes through the CircuitBreaker and is counted on its own.
+// Retrying stops when an attempt is rejected, and the rejection is returned as usual,
+// so Fallback applies to it.
+//
+// An attempt is retried only if its error is classified as a failure by IsSuccessful or ClassifyError.
+// ErrOpenState, ErrTooManyRequests, ErrBreakerClosed and ErrDraining are never retried.
+// ExecuteContext also stops retrying when its context is done.
+type RetrySettings struct {
+	MaxAttempts   int
+	Backoff       func(attempt int) time.Duration
+	CountAttempts bool
+}
+
+// executeRetry 在 execute 的基础上按 Retry 的设置重试
+func executeRetry[T any](cb *CircuitBreaker, c call[T], req func() (T, error)) (T, error) {
+	if req == nil {
+		var zero T
+		return zero, ErrNilRequest
+	}
+	if cb.retry.MaxAttempts <= 1 {
+		return execute(cb, c, req)
+	}
+
+	ctx := context.Background()
+	if cb.retry.CountAttempts {
+		return retry(cb, ctx, c.classify, false, func() (T, error) { return execute(cb, c, req) })
+	}
+	return execute(cb, c, func() (T, error) { return retry(cb, ctx, c.classify, true, req) })
+}
+
+// executeContextRetry 在 executeContext 的基础上按 Retry 的设置重试
+func executeContextRetry[T any](cb *CircuitBreaker, ctx context.Context, c call[T], req func(context.Context) (T, error)) (T, error) {
+	if req == nil {
+		var zero T
+		return zero, ErrNilRequest
+	}
+	if cb.retry.MaxAttempts <= 1 {
+		return executeContext(cb, ctx, c, req)
+	}
+
+	if cb.retry.CountAttempts {
+		return retry(cb, ctx, c.classify, false, func() (T, error) { return executeContext(cb, ctx, c, req) })
+	}
+	return executeContext(cb, ctx, c, func(ctx context.Context) (T, error) {
+		return retry(cb, ctx, c.classify, true, func() (T, error) { return req(ctx) })
+	})
+}
+
+// retry 执行 attempt，classify 判断为失败时等待 Backoff 后重试，直到成功、达到 MaxAttempts 或 ctx 结束。
+// stopOnOpen 为 true 时，如果熔断器在重试期间变为开启状态，也会停止重试
+func retry[T any](cb *CircuitBreaker, ctx context.Context, classify func(result T, err error) ErrorClass, stopOnOpen bool, attempt func() (T, error)) (result T, err error) {
+	for n := 1; ; n++ {
+		result, err = attempt()
+		if n >= cb.retry.MaxAttempts || classify(result, err) != ErrorClassFailure ||
+			errors.Is(err, ErrOpenState) || errors.Is(err, ErrTooManyRequests) || errors.Is(err, ErrBreakerClosed) ||
+			errors.Is(err, ErrDraining) {
+			return result, err
+		}
+
+		if cb.retry.Backoff != nil && !sleepContext(ctx, cb.retry.Backoff(n)) {
+			return result, err
+		}
+		if ctx.Err() != nil || (stopOnOpen && cb.State() == StateOpen) {
+			return result, err
+		}
+	}
+}
+
+// sleepContext 等待 d，ctx 先结束时返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,141 @@
+package gobreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	var backoffs []int
+	cb := NewCircuitBreaker(Settings{Retry: RetrySettings{
+		MaxAttempts: 3,
+		Backoff: func(attempt int) time.Duration {
+			backoffs = append(backoffs, attempt)
+			return time.Millisecond
+		},
+	}})
+	errFail := errors.New("fail")
+
+	attempts := 0
+	result, err := cb.Execute(func() (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errFail
+		}
+		return "ok", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", result)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []int{1, 2}, backoffs)
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts()) // the operation counts once
+
+	attempts = 0
+	_, err = cb.Execute(func() (interface{}, error) {
+		attempts++
+		return nil, errFail
+	})
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, newCounts(2, 1, 1, 0, 1), cb.Counts())
+
+	// the breaker opens during the operation
+	attempts = 0
+	_, err = cb.Execute(func() (interface{}, error) {
+		attempts++
+		cb.Trip()
+		return nil, errFail
+	})
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryCountAttempts(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		ReadyToTrip: func(counts Counts) bool { return counts.ConsecutiveFailures >= 2 },
+		Retry:       RetrySettings{MaxAttempts: 3, CountAttempts: true},
+	})
+	errFail := errors.New("fail")
+
+	attempts := 0
+	_, err := cb.Execute(func() (interface{}, error) {
+		attempts++
+		if attempts < 2 {
+			return nil, errFail
+		}
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, newCounts(2, 1, 1, 1, 0), cb.Counts()) // every attempt counts
+
+	// the second failure trips the breaker and the third attempt is rejected
+	attempts = 0
+	_, err = cb.Execute(func() (interface{}, error) {
+		attempts++
+		return nil, errFail
+	})
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRetryNotRetried(t *testing.T) {
+	errNotFound := errors.New("not found")
+	cb := NewCircuitBreaker(Settings{
+		ClassifyError: func(err error) ErrorClass {
+			if err == errNotFound {
+				return ErrorClassIgnore
+			}
+			return ErrorClassFailure
+		},
+		Retry: RetrySettings{MaxAttempts: 3},
+	})
+
+	attempts := 0
+	_, err := cb.Execute(func() (interface{}, error) {
+		attempts++
+		return nil, errNotFound
+	})
+	assert.Equal(t, errNotFound, err)
+	assert.Equal(t, 1, attempts)
+
+	// a nested breaker's rejection is not retried either
+	attempts = 0
+	_, err = cb.Execute(func() (interface{}, error) {
+		attempts++
+		return nil, ErrOpenState
+	})
+	assert.Equal(t, ErrOpenState, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryContext(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Retry: RetrySettings{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Hour },
+	}})
+	errFail := errors.New("fail")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	_, err := cb.ExecuteContext(ctx, func(ctx context.Context) (interface{}, error) {
+		attempts++
+		cancel() // stops the backoff
+		return nil, errFail
+	})
+	assert.Equal(t, errFail, err)
+	assert.Equal(t, 1, attempts)
+
+	tcb := NewTypedCircuitBreaker[int](Settings{Retry: RetrySettings{MaxAttempts: 2}})
+	attempts = 0
+	n, err := tcb.Execute(func() (int, error) {
+		attempts++
+		return attempts, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
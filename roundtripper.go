@@ -0,0 +1,124 @@
+package gobreaker
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoundTripper is an http.RoundTripper that sends requests through a CircuitBreaker.
+//
+// IsSuccessfulResponse classifies a response received without a transport error.
+// If IsSuccessfulResponse is nil, the response is classified by its status code with StatusClassifier,
+// which can also ignore a response, so that it is not counted at all.
+// If StatusClassifier is nil too, DefaultStatusClassifier is used.
+// Transport errors are classified by the IsSuccessful or ClassifyError of the CircuitBreaker.
+//
+// If a response classified as a failure trips the CircuitBreaker and has a Retry-After header,
+// the CircuitBreaker stays open until the time given by the header instead of for its Timeout.
+type RoundTripper struct {
+	IsSuccessfulResponse func(resp *http.Response) bool
+	StatusClassifier     func(status int) ErrorClass
+
+	cb   *CircuitBreaker
+	next http.RoundTripper
+}
+
+// DefaultStatusClassifier classifies 429 Too Many Requests and status codes of 500 and above as failures,
+// since they indicate that the server is unavailable or overloaded,
+// and every other status code as a success, including client errors such as 404 Not Found.
+func DefaultStatusClassifier(status int) ErrorClass {
+	if status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+		return ErrorClassFailure
+	}
+	return ErrorClassSuccess
+}
+
+// NewRoundTripper returns a new RoundTripper that guards next with cb.
+// If next is nil, http.DefaultTransport is used.
+func NewRoundTripper(cb *CircuitBreaker, next http.RoundTripper) *RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RoundTripper{cb: cb, next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+// If the CircuitBreaker rejects the request, RoundTrip closes the request body
+// and returns the rejection error, such as ErrOpenState, without calling the next RoundTripper.
+// A response classified as a failure is still returned to the caller.
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	generation, err := rt.cb.beforeRequest()
+	if err != nil {
+		// RoundTripper 即使出错也必须关闭请求的 body，否则连接会泄漏
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		rt.cb.afterResult(generation, err, elapsed)
+		return nil, err
+	}
+
+	class := rt.classifyResponse(resp)
+	rt.cb.afterClassified(generation, class, nil, elapsed)
+	if class == ErrorClassFailure {
+		if d, ok := retryAfterDelay(resp.Header.Get("Retry-After"), rt.cb.clock.Now()); ok {
+			rt.cb.holdOpen(generation, d)
+		}
+	}
+	return resp, nil
+}
+
+func (rt *RoundTripper) classifyResponse(resp *http.Response) ErrorClass {
+	if rt.IsSuccessfulResponse != nil {
+		if rt.IsSuccessfulResponse(resp) {
+			return ErrorClassSuccess
+		}
+		return ErrorClassFailure
+	}
+	if rt.StatusClassifier != nil {
+		return rt.StatusClassifier(resp.StatusCode)
+	}
+	return DefaultStatusClassifier(resp.StatusCode)
+}
+
+// retryAfterDelay 解析 Retry-After 头，支持秒数和 HTTP 日期两种格式，返回距离 now 的时间
+func retryAfterDelay(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// holdOpen 在请求 before 放行之后熔断器变为开启状态时，把切换到半开状态的时间设置为 d 之后，
+// 强制开启时不修改
+func (cb *CircuitBreaker) holdOpen(before uint64, d time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state != StateOpen || cb.forced || cb.stateGeneration <= before {
+		return
+	}
+	cb.openTimeout = d
+	cb.expiry = cb.clock.Now().Add(d)
+}
@@ -0,0 +1,174 @@
+package gobreaker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRoundTripper(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	cb := NewCircuitBreaker(Settings{})
+	client := &http.Client{Transport: NewRoundTripper(cb, nil)}
+
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, newCounts(1, 1, 0, 1, 0), cb.Counts())
+
+	// 5xx responses are failures but still returned
+	status = http.StatusServiceUnavailable
+	for i := 0; i < 6; i++ {
+		resp, err = client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	// rejected requests close their body
+	body := &closeRecorder{Reader: strings.NewReader("payload")}
+	req, _ := http.NewRequest(http.MethodPost, server.URL, body)
+	_, err = NewRoundTripper(cb, nil).RoundTrip(req)
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.True(t, body.closed)
+}
+
+func TestRoundTripperClassifier(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{})
+	rt := NewRoundTripper(cb, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Body: http.NoBody}, nil
+	}))
+	rt.IsSuccessfulResponse = func(resp *http.Response) bool {
+		return resp.StatusCode != http.StatusTooManyRequests
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	_, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+
+	errTransport := fmt.Errorf("connection refused")
+	rt = NewRoundTripper(cb, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errTransport
+	}))
+	_, err = rt.RoundTrip(req)
+	assert.Equal(t, errTransport, err)
+	assert.Equal(t, newCounts(2, 0, 2, 0, 2), cb.Counts())
+}
+
+func TestRoundTripperStatusClassifier(t *testing.T) {
+	assert.Equal(t, ErrorClassSuccess, DefaultStatusClassifier(http.StatusOK))
+	assert.Equal(t, ErrorClassSuccess, DefaultStatusClassifier(http.StatusNotFound))
+	assert.Equal(t, ErrorClassFailure, DefaultStatusClassifier(http.StatusTooManyRequests))
+	assert.Equal(t, ErrorClassFailure, DefaultStatusClassifier(http.StatusServiceUnavailable))
+
+	status := http.StatusNotFound
+	cb := NewCircuitBreaker(Settings{})
+	rt := NewRoundTripper(cb, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: http.NoBody}, nil
+	}))
+	rt.StatusClassifier = func(status int) ErrorClass {
+		if status == http.StatusNotFound {
+			return ErrorClassIgnore
+		}
+		return DefaultStatusClassifier(status)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	_, err := rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	status = http.StatusTooManyRequests
+	_, err = rt.RoundTrip(req)
+	assert.Nil(t, err)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+}
+
+func TestRoundTripperRetryAfter(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		Timeout:     time.Duration(60) * time.Second,
+		ReadyToTrip: func(counts Counts) bool { return counts.ConsecutiveFailures >= 2 },
+	})
+	retryAfter := "120"
+	rt := NewRoundTripper(cb, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: http.NoBody}
+		resp.Header.Set("Retry-After", retryAfter)
+		return resp, nil
+	}))
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	// a failure that doesn't trip leaves the expiry alone
+	rt.RoundTrip(req)
+	assert.Equal(t, StateClosed, cb.State())
+
+	rt.RoundTrip(req)
+	assert.Equal(t, StateOpen, cb.State())
+	remaining := time.Until(cb.ExpiresAt())
+	assert.True(t, remaining > time.Duration(115)*time.Second && remaining <= time.Duration(120)*time.Second)
+
+	// an HTTP date
+	cb.Reset()
+	retryAfter = time.Now().Add(time.Duration(10) * time.Second).UTC().Format(http.TimeFormat)
+	rt.RoundTrip(req)
+	rt.RoundTrip(req)
+	remaining = time.Until(cb.ExpiresAt())
+	assert.True(t, remaining > time.Duration(8)*time.Second && remaining <= time.Duration(10)*time.Second)
+
+	// an invalid header is ignored
+	cb.Reset()
+	retryAfter = "soon"
+	rt.RoundTrip(req)
+	rt.RoundTrip(req)
+	remaining = time.Until(cb.ExpiresAt())
+	assert.True(t, remaining > time.Duration(55)*time.Second && remaining <= time.Duration(60)*time.Second)
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	d, ok := retryAfterDelay(" 5 ", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(5)*time.Second, d)
+
+	d, ok = retryAfterDelay("Sat, 01 Jan 2000 00:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(30)*time.Second, d)
+
+	d, ok = retryAfterDelay("Fri, 31 Dec 1999 23:59:00 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+
+	_, ok = retryAfterDelay("", now)
+	assert.False(t, ok)
+	_, ok = retryAfterDelay("-1", now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,64 @@
+package gobreaker
+
+import "time"
+
+// SharedStateStore shares the state and Counts of CircuitBreakers with the same name
+// across processes, so that the first instance to trip protects the others.
+//
+// GetState returns the shared state of the CircuitBreaker name.
+// It returns StateClosed if no state is stored.
+//
+// SetState stores state as the shared state of name and clears its shared Counts.
+// If ttl is greater than 0, the state expires after ttl.
+//
+// IncrCounts atomically records the result of a request in the shared Counts of name
+// and returns the updated Counts. If ttl is greater than 0, the shared Counts expire
+// ttl after they are created, like the closed-state Interval.
+type SharedStateStore interface {
+	GetState(name string) (State, error)
+	SetState(name string, state State, ttl time.Duration) error
+	IncrCounts(name string, success bool, ttl time.Duration) (Counts, error)
+}
+
+// sharedState 在请求前读取共享状态，读取失败时返回 false，此时只使用本地状态
+func (cb *CircuitBreaker) sharedState() (State, bool) {
+	if cb.sharedStore == nil {
+		return StateClosed, false
+	}
+	state, err := cb.sharedStore.GetState(cb.name)
+	return state, err == nil
+}
+
+// sharedCounts 在请求后更新共享计数，更新失败时返回 false
+func (cb *CircuitBreaker) sharedCounts(success bool) (Counts, bool) {
+	if cb.sharedStore == nil {
+		return Counts{}, false
+	}
+	counts, err := cb.sharedStore.IncrCounts(cb.name, success, cb.interval)
+	return counts, err == nil
+}
+
+// applySharedState 在共享状态为开启时将本地状态也切换为开启，调用方需要持有锁。
+// 这次切换来自共享状态，不会再写回存储，否则会不断延长共享状态的过期时间
+func (cb *CircuitBreaker) applySharedState(state State, now time.Time) {
+	if state != StateOpen {
+		return
+	}
+	cb.syncingShared = true
+	cb.setState(StateOpen, now)
+	cb.syncingShared = false
+}
+
+// publishState 将本地的开启和关闭状态写入共享存储，调用方需要持有锁。
+// 半开状态只在本地探测，不写入；写入失败时忽略，继续使用本地状态
+func (cb *CircuitBreaker) publishState(state State) {
+	if cb.sharedStore == nil || cb.syncingShared {
+		return
+	}
+	switch state {
+	case StateOpen:
+		cb.sharedStore.SetState(cb.name, StateOpen, cb.openTimeout)
+	case StateClosed:
+		cb.sharedStore.SetState(cb.name, StateClosed, 0)
+	}
+}
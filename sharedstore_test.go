@@ -0,0 +1,112 @@
+package gobreaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	mutex  sync.Mutex
+	states map[string]State
+	counts map[string]Counts
+	err    error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{states: map[string]State{}, counts: map[string]Counts{}}
+}
+
+func (s *memoryStore) GetState(name string) (State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.states[name], s.err
+}
+
+func (s *memoryStore) SetState(name string, state State, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.states[name] = state
+	delete(s.counts, name)
+	return nil
+}
+
+func (s *memoryStore) IncrCounts(name string, success bool, ttl time.Duration) (Counts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return Counts{}, s.err
+	}
+	c := s.counts[name]
+	c.onRequest()
+	if success {
+		c.onSuccess()
+	} else {
+		c.onFailure()
+	}
+	s.counts[name] = c
+	return c, nil
+}
+
+// expire emulates the expiry of the shared state after its ttl
+func (s *memoryStore) expire(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.states, name)
+}
+
+func TestSharedStateStore(t *testing.T) {
+	store := newMemoryStore()
+	a := NewCircuitBreaker(Settings{Name: "shared", SharedStateStore: store})
+	b := NewCircuitBreaker(Settings{Name: "shared", SharedStateStore: store})
+
+	// failures of the fleet add up
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, fail(a))
+		assert.Nil(t, fail(b))
+	}
+	assert.Equal(t, StateClosed, a.State())
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, StateOpen, store.states["shared"])
+
+	// the shared open state protects the other instance
+	assert.True(t, errors.Is(succeed(a), ErrOpenState))
+	assert.Equal(t, StateOpen, a.State())
+
+	// the half-open state is not shared
+	pseudoSleep(b, time.Duration(60)*time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.Equal(t, StateOpen, store.states["shared"])
+
+	// closing is shared once the shared open state has expired
+	store.expire("shared")
+	assert.Nil(t, succeed(b))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, StateClosed, store.states["shared"])
+}
+
+func TestSharedStateStoreFallback(t *testing.T) {
+	store := newMemoryStore()
+	store.err = fmt.Errorf("unreachable")
+	cb := NewCircuitBreaker(Settings{Name: "fallback", SharedStateStore: store})
+
+	assert.Nil(t, succeed(cb))
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Empty(t, store.states)
+
+	// the store comes back while the breaker is open
+	store.err = nil
+	pseudoSleep(cb, time.Duration(60)*time.Second)
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
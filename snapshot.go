@@ -0,0 +1,172 @@
+package gobreaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Snapshot is the state of a CircuitBreaker that can be saved and restored across restarts.
+// Expiry is the time of the next scheduled transition, as returned by ExpiresAt.
+// StateSince is the time when the CircuitBreaker entered the state, as returned by StateSince.
+// OpenAttempts is the number of times the CircuitBreaker has opened since it was last closed,
+// which is passed to Settings.BackoffExpiry.
+// The forced state set by SetForcedState is not part of a Snapshot.
+type Snapshot struct {
+	State        State     `json:"state"`
+	Generation   uint64    `json:"generation"`
+	Counts       Counts    `json:"counts"`
+	Expiry       time.Time `json:"expiry"`
+	StateSince   time.Time `json:"stateSince"`
+	OpenAttempts int       `json:"openAttempts,omitempty"`
+}
+
+// NewCircuitBreakerFromSnapshot returns a new CircuitBreaker configured with st
+// and restored from snapshot. See Restore for how the snapshot is restored.
+// It returns an error if the state of snapshot is invalid.
+func NewCircuitBreakerFromSnapshot(st Settings, snapshot Snapshot) (*CircuitBreaker, error) {
+	cb := NewCircuitBreaker(st)
+	if err := cb.Restore(snapshot); err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+// Snapshot returns the current state of the CircuitBreaker without changing it.
+func (cb *CircuitBreaker) Snapshot() Snapshot {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// 与 ExpiresAt 一样不调用 currentState，保存的是原样的状态
+	return Snapshot{
+		State:        cb.state,
+		Generation:   cb.generation,
+		Counts:       cb.counts,
+		Expiry:       cb.expiry,
+		StateSince:   cb.stateSince,
+		OpenAttempts: cb.openAttempts,
+	}
+}
+
+// Restore replaces the state of the CircuitBreaker with snapshot.
+// Restoring doesn't call OnStateChange, but if the saved expiry has already passed,
+// the CircuitBreaker makes the due transition right away as usual:
+// an open CircuitBreaker becomes half-open, and a closed one clears its Counts.
+// Requests that were in flight when the snapshot was taken are removed from Counts,
+// since they never complete in the restored CircuitBreaker.
+// If WindowType is WindowTypeCount or WindowTypeTime, the window starts empty
+// and replaces the restored totals on the next update.
+// The last results kept for MinimumSamples are dropped.
+// It returns an error if the state of snapshot is invalid.
+func (cb *CircuitBreaker) Restore(snapshot Snapshot) error {
+	switch snapshot.State {
+	case StateClosed, StateHalfOpen, StateOpen:
+	default:
+		return fmt.Errorf("gobreaker: invalid state in snapshot: %d", snapshot.State)
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	cb.state = snapshot.State
+	cb.generation = snapshot.Generation
+	cb.stateGeneration = snapshot.Generation
+	cb.counts = snapshot.Counts
+	if finished := cb.counts.TotalSuccesses + cb.counts.TotalFailures; cb.counts.Requests > finished {
+		cb.counts.Requests = finished
+	}
+	cb.expiry = snapshot.Expiry
+	cb.openAttempts = snapshot.OpenAttempts
+	cb.stateSince = snapshot.StateSince
+	if cb.stateSince.IsZero() {
+		cb.stateSince = now
+	}
+	cb.openSince = cb.stateSince // 不知道离开关闭状态的时间，按进入当前状态的时间计算
+	cb.halfOpenInFlight = 0
+	cb.halfOpenSuccesses = 0
+	cb.halfOpenFailures = 0
+	cb.wakeHalfOpenWaiters()
+	if cb.state == StateOpen {
+		cb.openTimeout = cb.expiry.Sub(now)
+	}
+
+	// 衰减和窗口从恢复的计数重新开始
+	cb.decayed = decayedCounts{
+		requests:  float64(cb.counts.Requests),
+		successes: float64(cb.counts.TotalSuccesses),
+		failures:  float64(cb.counts.TotalFailures),
+		updated:   now,
+	}
+	if cb.window != nil {
+		cb.window.reset()
+	}
+	cb.resetRecent()
+
+	// 保存的过期时间已过时立即切换，例如开启状态直接变为半开状态
+	if state, _ := cb.currentState(now); state == StateClosed {
+		cb.stopProbe()
+	} else {
+		cb.startProbe()
+	}
+	cb.stuckOpenSince = time.Now() // 不知道离开关闭状态的实际时间，从恢复时开始计算
+	cb.armStuckOpen()
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+// It encodes the Snapshot of the CircuitBreaker as JSON.
+func (cb *CircuitBreaker) MarshalBinary() ([]byte, error) {
+	return json.Marshal(cb.Snapshot())
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// It decodes a Snapshot encoded by MarshalBinary and restores it with Restore.
+// The CircuitBreaker must have been created by NewCircuitBreaker, which sets its configuration.
+func (cb *CircuitBreaker) UnmarshalBinary(data []byte) error {
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	return cb.Restore(snapshot)
+}
+
+// Dump writes a human-readable description of the CircuitBreaker to w for debugging:
+// its name, state, generation, Counts, expiry and how long it has been in its state.
+// The values are read at once under the lock of the CircuitBreaker, so they are consistent with each other,
+// and written outside of it. Each value is on its own line as "key: value", so that the output can be grepped.
+// The state is the one returned by State, and the expiry is "none" if no transition is scheduled.
+func (cb *CircuitBreaker) Dump(w io.Writer) error {
+	cb.mutex.Lock()
+	now := cb.clock.Now()
+	state, generation := cb.currentState(now)
+	if cb.window != nil && cb.state == StateClosed {
+		cb.syncWindow(now)
+	}
+	counts, expiry, since := cb.counts, cb.expiry, cb.stateSince
+	cb.mutex.Unlock()
+
+	// 在锁外写入，w 阻塞时不会影响请求
+	expiryText := "none"
+	if !expiry.IsZero() {
+		expiryText = expiry.Format(time.RFC3339Nano)
+	}
+	_, err := fmt.Fprintf(w, "name: %s\n"+
+		"state: %s\n"+
+		"generation: %d\n"+
+		"requests: %d\n"+
+		"total_successes: %d\n"+
+		"total_failures: %d\n"+
+		"consecutive_successes: %d\n"+
+		"consecutive_failures: %d\n"+
+		"rejections: %d\n"+
+		"expiry: %s\n"+
+		"state_since: %s\n"+
+		"time_in_state: %s\n",
+		cb.name, state, generation,
+		counts.Requests, counts.TotalSuccesses, counts.TotalFailures,
+		counts.ConsecutiveSuccesses, counts.ConsecutiveFailures, counts.Rejections,
+		expiryText, since.Format(time.RFC3339Nano), now.Sub(since))
+	return err
+}
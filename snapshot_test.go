@@ -0,0 +1,105 @@
+package gobreaker
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{Name: "cb"})
+	assert.Nil(t, succeed(cb))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, fail(cb))
+	}
+	data, err := cb.MarshalBinary()
+	assert.Nil(t, err)
+	restored := NewCircuitBreaker(Settings{Name: "cb"})
+	assert.Nil(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, newCounts(6, 1, 5, 0, 5), restored.Counts())
+	assert.Nil(t, fail(restored))
+	assert.Equal(t, StateOpen, restored.State())
+
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, StateOpen, cb.State())
+	data, err = cb.MarshalBinary()
+	assert.Nil(t, err)
+	restored = NewCircuitBreaker(Settings{Name: "cb"})
+	assert.Nil(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, StateOpen, restored.State())
+	assert.Equal(t, cb.Snapshot().Generation, restored.Snapshot().Generation)
+	assert.Equal(t, 1, restored.Snapshot().OpenAttempts)
+	assert.True(t, cb.ExpiresAt().Equal(restored.ExpiresAt()))
+	_, err = restored.Execute(func() (interface{}, error) { return nil, nil })
+	assert.True(t, errors.Is(err, ErrOpenState))
+
+	// an open breaker whose expiry has passed is half-open right away
+	snapshot := cb.Snapshot()
+	snapshot.Expiry = time.Now().Add(-time.Second)
+	var changes []stateTransition
+	restored, err = NewCircuitBreakerFromSnapshot(Settings{
+		Name: "cb",
+		OnStateChange: func(name string, from State, to State) {
+			changes = append(changes, stateTransition{name, from, to})
+		},
+	}, snapshot)
+	assert.Nil(t, err)
+	assert.Equal(t, StateHalfOpen, restored.state)
+	assert.Equal(t, []stateTransition{{"cb", StateOpen, StateHalfOpen}}, changes)
+	assert.Nil(t, succeed(restored))
+	assert.Equal(t, StateClosed, restored.State())
+
+	_, err = NewCircuitBreakerFromSnapshot(Settings{}, Snapshot{State: State(7)})
+	assert.Error(t, err)
+	assert.Error(t, restored.UnmarshalBinary([]byte("{")))
+}
+
+func TestSnapshotInFlight(t *testing.T) {
+	tscb := NewTwoStepCircuitBreaker(Settings{})
+	tscb.Trip()
+	pseudoSleep(tscb.cb, time.Duration(60)*time.Second)
+	_, err := tscb.Allow()
+	assert.Nil(t, err)
+
+	// the request in flight never completes in the restored breaker, so it doesn't hold the half-open slot
+	cb, err := NewCircuitBreakerFromSnapshot(Settings{}, tscb.cb.Snapshot())
+	assert.Nil(t, err)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Equal(t, Counts{}, cb.Counts())
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestDump(t *testing.T) {
+	clock := &testClock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cb := NewCircuitBreaker(Settings{Name: "dump", Clock: clock})
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, fail(cb))
+	clock.advance(1500 * time.Millisecond)
+
+	var buf bytes.Buffer
+	assert.Nil(t, cb.Dump(&buf))
+	assert.Equal(t, "name: dump\n"+
+		"state: closed\n"+
+		"generation: 1\n"+
+		"requests: 2\n"+
+		"total_successes: 1\n"+
+		"total_failures: 1\n"+
+		"consecutive_successes: 0\n"+
+		"consecutive_failures: 1\n"+
+		"rejections: 0\n"+
+		"expiry: none\n"+
+		"state_since: 2000-01-01T00:00:00Z\n"+
+		"time_in_state: 1.5s\n", buf.String())
+
+	cb.Trip()
+	buf.Reset()
+	assert.Nil(t, cb.Dump(&buf))
+	assert.Contains(t, buf.String(), "state: open\n")
+	assert.Contains(t, buf.String(), "expiry: 2000-01-01T00:01:01.5Z\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "time_in_state: 0s\n"))
+}
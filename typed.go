@@ -0,0 +1,106 @@
+package gobreaker
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedCircuitBreaker is a CircuitBreaker whose requests return a value of type T,
+// so that callers don't need to type-assert results.
+// It shares the state machine of CircuitBreaker.
+type TypedCircuitBreaker[T any] struct {
+	cb *CircuitBreaker
+}
+
+// NewTypedCircuitBreaker returns a new TypedCircuitBreaker configured with the given Settings.
+func NewTypedCircuitBreaker[T any](st Settings) *TypedCircuitBreaker[T] {
+	return &TypedCircuitBreaker[T]{
+		cb: NewCircuitBreaker(st),
+	}
+}
+
+// Name returns the name of the TypedCircuitBreaker.
+func (tcb *TypedCircuitBreaker[T]) Name() string {
+	return tcb.cb.Name()
+}
+
+// State returns the current state of the TypedCircuitBreaker.
+func (tcb *TypedCircuitBreaker[T]) State() State {
+	return tcb.cb.State()
+}
+
+// Counts returns internal counters
+func (tcb *TypedCircuitBreaker[T]) Counts() Counts {
+	return tcb.cb.Counts()
+}
+
+// Generation returns the current generation of the TypedCircuitBreaker.
+func (tcb *TypedCircuitBreaker[T]) Generation() uint64 {
+	return tcb.cb.Generation()
+}
+
+// Trip places the TypedCircuitBreaker into the open state immediately.
+func (tcb *TypedCircuitBreaker[T]) Trip() {
+	tcb.cb.Trip()
+}
+
+// Reset places the TypedCircuitBreaker into the closed state immediately and clears the internal Counts.
+func (tcb *TypedCircuitBreaker[T]) Reset() {
+	tcb.cb.Reset()
+}
+
+// Subscribe returns a channel that receives the state changes of the TypedCircuitBreaker,
+// as CircuitBreaker.Subscribe does.
+func (tcb *TypedCircuitBreaker[T]) Subscribe() <-chan StateChange {
+	return tcb.cb.Subscribe()
+}
+
+// Unsubscribe stops sending state changes to ch and closes it, as CircuitBreaker.Unsubscribe does.
+func (tcb *TypedCircuitBreaker[T]) Unsubscribe(ch <-chan StateChange) {
+	tcb.cb.Unsubscribe(ch)
+}
+
+// Drain stops admitting new requests and waits for the requests in flight, as CircuitBreaker.Drain does.
+func (tcb *TypedCircuitBreaker[T]) Drain(ctx context.Context) error {
+	return tcb.cb.Drain(ctx)
+}
+
+// Close releases the background resources of the TypedCircuitBreaker, as CircuitBreaker.Close does.
+func (tcb *TypedCircuitBreaker[T]) Close() error {
+	return tcb.cb.Close()
+}
+
+// Execute runs the given request if the TypedCircuitBreaker accepts it.
+// Execute returns the zero value of T and an error instantly if the TypedCircuitBreaker rejects the request.
+// Otherwise, Execute returns the result of the request.
+// Panics and retries are handled as in CircuitBreaker.Execute.
+// If Settings.Fallback is set, it is used for rejected requests as in CircuitBreaker.Execute;
+// its result must be nil, which gives the zero value of T, or a value of type T,
+// otherwise the error of Execute reports the mismatch.
+func (tcb *TypedCircuitBreaker[T]) Execute(req func() (T, error)) (T, error) {
+	return executeRetry(tcb.cb, defaultCall(tcb.cb, typedFallback[T](tcb.cb.fallback)), req)
+}
+
+// ExecuteContext is like Execute but passes ctx to the request, as in CircuitBreaker.ExecuteContext.
+func (tcb *TypedCircuitBreaker[T]) ExecuteContext(ctx context.Context, req func(context.Context) (T, error)) (T, error) {
+	return executeContextRetry(tcb.cb, ctx, defaultCall(tcb.cb, typedFallback[T](tcb.cb.fallback)), req)
+}
+
+// typedFallback 将 Settings.Fallback 转换为返回 T 的 fallback，fallback 为 nil 时返回 nil
+func typedFallback[T any](fallback func(err error) (interface{}, error)) func(err error) (T, error) {
+	if fallback == nil {
+		return nil
+	}
+	return func(err error) (T, error) {
+		var zero T
+		result, err := fallback(err)
+		if result == nil {
+			return zero, err
+		}
+		v, ok := result.(T)
+		if !ok {
+			return zero, fmt.Errorf("gobreaker: fallback returned %T, not %T", result, zero)
+		}
+		return v, err
+	}
+}
@@ -0,0 +1,112 @@
+package gobreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedCircuitBreaker(t *testing.T) {
+	tcb := NewTypedCircuitBreaker[int](Settings{Name: "typed"})
+	assert.Equal(t, "typed", tcb.Name())
+
+	n, err := tcb.Execute(func() (int, error) { return 42, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 42, n)
+
+	for i := 0; i < 6; i++ {
+		_, err = tcb.Execute(func() (int, error) { return 1, fmt.Errorf("fail") })
+		assert.Error(t, err)
+	}
+	assert.Equal(t, StateOpen, tcb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), tcb.Counts())
+
+	// the zero value is returned on rejection
+	n, err = tcb.Execute(func() (int, error) { return 42, nil })
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.Equal(t, 0, n)
+
+	s, err := NewTypedCircuitBreaker[string](Settings{}).ExecuteContext(context.Background(),
+		func(ctx context.Context) (string, error) { return "ok", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", s)
+
+	_, err = tcb.Execute(nil)
+	assert.Equal(t, ErrNilRequest, err)
+}
+
+func TestTypedCircuitBreakerTimeout(t *testing.T) {
+	tcb := NewTypedCircuitBreaker[*int](Settings{RequestTimeout: time.Duration(10) * time.Millisecond})
+	p, err := tcb.Execute(func() (*int, error) {
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		n := 1
+		return &n, nil
+	})
+	assert.Equal(t, ErrRequestTimeout, err)
+	assert.Nil(t, p)
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), tcb.Counts())
+}
+
+func TestTypedCircuitBreakerFallback(t *testing.T) {
+	tcb := NewTypedCircuitBreaker[int](Settings{
+		Fallback: func(err error) (interface{}, error) { return 7, nil },
+	})
+	tcb.Trip()
+	n, err := tcb.Execute(func() (int, error) { return 42, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+
+	// a result of another type is reported instead of being dropped
+	scb := NewTypedCircuitBreaker[string](Settings{
+		Fallback: func(err error) (interface{}, error) { return 7, nil },
+	})
+	scb.Trip()
+	s, err := scb.ExecuteContext(context.Background(), func(context.Context) (string, error) { return "ok", nil })
+	assert.Equal(t, "", s)
+	assert.Equal(t, "gobreaker: fallback returned int, not string", err.Error())
+
+	// a nil result gives the zero value
+	ncb := NewTypedCircuitBreaker[int](Settings{
+		Fallback: func(err error) (interface{}, error) { return nil, err },
+	})
+	ncb.Trip()
+	n, err = ncb.Execute(func() (int, error) { return 42, nil })
+	assert.True(t, errors.Is(err, ErrOpenState))
+	assert.Equal(t, 0, n)
+}
+
+func TestTypedCircuitBreakerLifecycle(t *testing.T) {
+	tcb := NewTypedCircuitBreaker[int](Settings{ProbeFunc: func() error { return nil }})
+	ch := tcb.Subscribe()
+
+	tcb.Trip()
+	assert.Equal(t, StateOpen, tcb.State())
+	assert.Equal(t, StateOpen, (<-ch).To)
+	tcb.Reset()
+	assert.Equal(t, StateClosed, tcb.State())
+	assert.Equal(t, uint64(3), tcb.Generation())
+	tcb.Unsubscribe(ch)
+
+	assert.Nil(t, tcb.Drain(context.Background()))
+	_, err := tcb.Execute(func() (int, error) { return 1, nil })
+	assert.True(t, errors.Is(err, ErrDraining))
+
+	// Close stops the probe goroutine started when the breaker opened
+	tcb.Trip()
+	assert.Nil(t, tcb.Close())
+	tcb.cb.mutex.Lock()
+	assert.Nil(t, tcb.cb.probeStop)
+	tcb.cb.mutex.Unlock()
+}
+
+func BenchmarkTypedExecute(b *testing.B) {
+	tcb := NewTypedCircuitBreaker[int](Settings{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tcb.Execute(func() (int, error) { return i, nil })
+	}
+}
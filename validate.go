@@ -0,0 +1,146 @@
+package gobreaker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSettings is returned by Settings.Validate and NewCircuitBreakerWithValidation
+// when the Settings are invalid. The returned error describes every problem found.
+// 该错误在 Settings 的值无效或互相矛盾时返回
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// NewCircuitBreakerWithValidation returns a new CircuitBreaker configured with st
+// like NewCircuitBreaker, after checking st with Validate.
+// Unlike NewCircuitBreaker, which silently replaces invalid values by defaults,
+// it returns an error wrapping ErrInvalidSettings if st is invalid.
+func NewCircuitBreakerWithValidation(st Settings) (*CircuitBreaker, error) {
+	if err := st.Validate(); err != nil {
+		return nil, err
+	}
+	return NewCircuitBreaker(st), nil
+}
+
+// Validate reports the values of st that NewCircuitBreaker would replace, clamp or ignore:
+// negative durations and sizes, ratios outside of [0, 1], an unknown InitialState or WindowType,
+// an IntervalJitter larger than half of Interval, and fields that have no effect
+// because of the value of another field, such as WindowSize without WindowTypeCount.
+// It returns nil if st is valid, or an error wrapping ErrInvalidSettings that lists every problem.
+// Validate can't check the functions of st, such as ReadyToTrip.
+func (st Settings) Validate() error {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"HalfOpenWait", st.HalfOpenWait},
+		{"Interval", st.Interval},
+		{"IntervalJitter", st.IntervalJitter},
+		{"MaxOpenDuration", st.MaxOpenDuration},
+		{"StuckOpenAfter", st.StuckOpenAfter},
+		{"Timeout", st.Timeout},
+		{"TwoStepCallbackTimeout", st.TwoStepCallbackTimeout},
+		{"HalfLife", st.HalfLife},
+		{"RequestTimeout", st.RequestTimeout},
+		{"RollingWindow", st.RollingWindow},
+		{"SlowCallDuration", st.SlowCallDuration},
+		{"HedgeDelay", st.HedgeDelay},
+		{"ProbeInterval", st.ProbeInterval},
+	} {
+		if d.value < 0 {
+			report("%s is negative: %s", d.name, d.value)
+		}
+	}
+	for _, b := range st.LatencyBuckets {
+		if b < 0 {
+			report("LatencyBuckets has a negative bound: %s", b)
+		}
+	}
+	if st.BucketCount < 0 {
+		report("BucketCount is negative: %d", st.BucketCount)
+	}
+	if st.EventBufferSize < 0 {
+		report("EventBufferSize is negative: %d", st.EventBufferSize)
+	}
+
+	for _, r := range []struct {
+		name  string
+		value float64
+	}{
+		{"HalfOpenSuccessRatio", st.HalfOpenSuccessRatio},
+		{"HalfOpenAdmitRatio", st.HalfOpenAdmitRatio},
+		{"NearTripMargin", st.NearTripMargin},
+	} {
+		if math.IsNaN(r.value) || r.value < 0 || r.value > 1 {
+			report("%s is not between 0 and 1: %v", r.name, r.value)
+		}
+	}
+
+	switch st.InitialState {
+	case StateClosed, StateHalfOpen, StateOpen:
+	default:
+		report("InitialState is unknown: %d", st.InitialState)
+	}
+
+	// 以下的值会被 configure 截断或忽略
+	if st.IntervalJitter > 0 {
+		if st.Interval <= 0 {
+			report("IntervalJitter is set without Interval")
+		} else if st.IntervalJitter > st.Interval/2 {
+			report("IntervalJitter %s is larger than half of Interval %s", st.IntervalJitter, st.Interval)
+		}
+	}
+	if st.HalfOpenSampleSize > 0 && st.HalfOpenSuccessRatio == 0 {
+		report("HalfOpenSampleSize is set without HalfOpenSuccessRatio")
+	}
+	if st.SuccessThreshold > 0 && st.HalfOpenSuccessRatio > 0 {
+		report("SuccessThreshold and HalfOpenSuccessRatio are both set")
+	}
+	if st.FairHalfOpen && st.HalfOpenWait <= 0 {
+		report("FairHalfOpen is set without HalfOpenWait")
+	}
+	if (st.StuckOpenAfter > 0) != (st.OnStuckOpen != nil) {
+		report("StuckOpenAfter and OnStuckOpen must be set together")
+	}
+	if st.HalfOpenWait > 0 && st.HalfOpenAdmitRatio > 0 {
+		report("HalfOpenWait and HalfOpenAdmitRatio are both set")
+	}
+	if st.ConsecutiveFailuresThreshold > 0 && st.ReadyToTrip != nil {
+		report("ConsecutiveFailuresThreshold and ReadyToTrip are both set")
+	}
+	if len(st.LatencyBuckets) > 0 && !st.TrackLatency {
+		report("LatencyBuckets is set without TrackLatency")
+	}
+
+	switch st.WindowType {
+	case WindowTypeGeneration:
+		if st.WindowSize > 0 || st.RollingWindow > 0 || st.BucketCount > 0 {
+			report("WindowSize, RollingWindow and BucketCount require WindowTypeCount or WindowTypeTime")
+		}
+	case WindowTypeCount:
+		if st.RollingWindow > 0 || st.BucketCount > 0 {
+			report("RollingWindow and BucketCount require WindowTypeTime")
+		}
+	case WindowTypeTime:
+		if st.WindowSize > 0 {
+			report("WindowSize requires WindowTypeCount")
+		}
+	default:
+		report("WindowType is unknown: %d", st.WindowType)
+	}
+	if st.WindowType != WindowTypeGeneration && (st.Interval > 0 || st.HalfLife > 0) {
+		report("Interval and HalfLife are ignored with a sliding window")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("gobreaker: %w: %s", ErrInvalidSettings, strings.Join(problems, "; "))
+}
@@ -0,0 +1,68 @@
+package gobreaker
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Settings{}.Validate())
+	assert.Nil(t, Settings{
+		MaxRequests:      3,
+		SuccessThreshold: 5,
+		Interval:         time.Minute,
+		IntervalJitter:   30 * time.Second,
+		Timeout:          time.Second,
+		InitialState:     StateOpen,
+		TrackLatency:     true,
+		LatencyBuckets:   []time.Duration{time.Millisecond},
+	}.Validate())
+	assert.Nil(t, Settings{WindowType: WindowTypeTime, RollingWindow: time.Minute, BucketCount: 6}.Validate())
+
+	for _, tc := range []struct {
+		st      Settings
+		problem string
+	}{
+		{Settings{Timeout: -time.Second}, "Timeout is negative: -1s"},
+		{Settings{Interval: -time.Second}, "Interval is negative: -1s"},
+		{Settings{HalfOpenAdmitRatio: 1.5}, "HalfOpenAdmitRatio is not between 0 and 1: 1.5"},
+		{Settings{NearTripMargin: math.NaN()}, "NearTripMargin is not between 0 and 1: NaN"},
+		{Settings{InitialState: State(7)}, "InitialState is unknown: 7"},
+		{Settings{IntervalJitter: time.Second}, "IntervalJitter is set without Interval"},
+		{Settings{Interval: time.Second, IntervalJitter: time.Second}, "IntervalJitter 1s is larger than half of Interval 1s"},
+		{Settings{HalfOpenSampleSize: 4}, "HalfOpenSampleSize is set without HalfOpenSuccessRatio"},
+		{Settings{FairHalfOpen: true}, "FairHalfOpen is set without HalfOpenWait"},
+		{Settings{StuckOpenAfter: time.Minute}, "StuckOpenAfter and OnStuckOpen must be set together"},
+		{Settings{SuccessThreshold: 2, HalfOpenSuccessRatio: 0.5}, "SuccessThreshold and HalfOpenSuccessRatio are both set"},
+		{Settings{HalfOpenWait: time.Second, HalfOpenAdmitRatio: 0.1}, "HalfOpenWait and HalfOpenAdmitRatio are both set"},
+		{Settings{ConsecutiveFailuresThreshold: 3, ReadyToTrip: defaultReadyToTrip}, "ConsecutiveFailuresThreshold and ReadyToTrip are both set"},
+		{Settings{LatencyBuckets: []time.Duration{time.Second}}, "LatencyBuckets is set without TrackLatency"},
+		{Settings{WindowSize: 10}, "WindowSize, RollingWindow and BucketCount require WindowTypeCount or WindowTypeTime"},
+		{Settings{WindowType: WindowTypeCount, BucketCount: 3}, "RollingWindow and BucketCount require WindowTypeTime"},
+		{Settings{WindowType: WindowTypeCount, Interval: time.Second}, "Interval and HalfLife are ignored with a sliding window"},
+		{Settings{WindowType: WindowType(9)}, "WindowType is unknown: 9"},
+	} {
+		err := tc.st.Validate()
+		assert.True(t, errors.Is(err, ErrInvalidSettings), tc.problem)
+		assert.Equal(t, "gobreaker: invalid settings: "+tc.problem, err.Error())
+	}
+
+	// every problem is reported
+	err := Settings{Timeout: -time.Second, HalfOpenSuccessRatio: 2}.Validate()
+	assert.Equal(t, "gobreaker: invalid settings: Timeout is negative: -1s; HalfOpenSuccessRatio is not between 0 and 1: 2", err.Error())
+}
+
+func TestNewCircuitBreakerWithValidation(t *testing.T) {
+	cb, err := NewCircuitBreakerWithValidation(Settings{Name: "valid", Timeout: time.Second})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid", cb.Name())
+	assert.Equal(t, time.Second, cb.timeout)
+
+	cb, err = NewCircuitBreakerWithValidation(Settings{InitialState: State(7)})
+	assert.Nil(t, cb)
+	assert.True(t, errors.Is(err, ErrInvalidSettings))
+}
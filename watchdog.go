@@ -0,0 +1,50 @@
+package gobreaker
+
+import "time"
+
+// armStuckOpen 在开启或半开状态下设置 stuckOpenTimer，从离开关闭状态起每隔 stuckOpenAfter 触发一次，
+// 关闭状态下只取消已有的定时器。调用方需要持有锁
+func (cb *CircuitBreaker) armStuckOpen() {
+	cb.disarmStuckOpen()
+	if cb.state == StateClosed || cb.closed || cb.stuckOpenAfter <= 0 || cb.onStuckOpen == nil {
+		return
+	}
+
+	// 下一次触发的时间是 stuckOpenAfter 的整数倍，Reconfigure 重新设置时不会从头计时
+	delay := cb.stuckOpenAfter - time.Since(cb.stuckOpenSince)%cb.stuckOpenAfter
+	arm := cb.stuckOpenArm
+	cb.stuckOpenTimer = time.AfterFunc(delay, func() { cb.stuckOpen(arm) })
+}
+
+// disarmStuckOpen 取消定时器，已经触发但还没有获得锁的回调会因 stuckOpenArm 变化而放弃。
+// 调用方需要持有锁
+func (cb *CircuitBreaker) disarmStuckOpen() {
+	cb.stuckOpenArm++
+	if cb.stuckOpenTimer != nil {
+		cb.stuckOpenTimer.Stop()
+		cb.stuckOpenTimer = nil
+	}
+}
+
+// stuckOpen 在定时器触发时检查熔断器是否仍未关闭，是则重新设置定时器并在解锁后调用 onStuckOpen
+func (cb *CircuitBreaker) stuckOpen(arm uint64) {
+	cb.mutex.Lock()
+	if cb.stuckOpenArm != arm {
+		cb.mutex.Unlock()
+		return
+	}
+
+	// 达到 maxOpenDuration 时变为关闭状态，changeState 会取消定时器
+	if state, _ := cb.currentState(cb.clock.Now()); state == StateClosed || cb.stuckOpenArm != arm {
+		cb.mutex.Unlock()
+		return
+	}
+
+	name := cb.name
+	onStuckOpen := cb.onStuckOpen
+	duration := time.Since(cb.stuckOpenSince)
+	cb.armStuckOpen()
+	cb.mutex.Unlock()
+
+	onStuckOpen(name, duration)
+}
@@ -0,0 +1,87 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStuckOpenBreaker(calls chan time.Duration) *CircuitBreaker {
+	return NewCircuitBreaker(Settings{
+		Name:           "cb",
+		StuckOpenAfter: time.Duration(20) * time.Millisecond,
+		OnStuckOpen: func(name string, duration time.Duration) {
+			calls <- duration
+		},
+	})
+}
+
+func TestStuckOpen(t *testing.T) {
+	calls := make(chan time.Duration, 10)
+	cb := newStuckOpenBreaker(calls)
+
+	// 关闭状态下不触发
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	assert.Equal(t, 0, len(calls))
+
+	// 开启状态下每隔 StuckOpenAfter 触发一次
+	cb.Trip()
+	first := <-calls
+	second := <-calls
+	assert.True(t, first >= time.Duration(20)*time.Millisecond)
+	assert.True(t, second >= time.Duration(40)*time.Millisecond)
+
+	// Close 之后不再触发
+	assert.Nil(t, cb.Close())
+	for len(calls) > 0 {
+		<-calls
+	}
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	assert.Equal(t, 0, len(calls))
+}
+
+func TestStuckOpenCancelled(t *testing.T) {
+	calls := make(chan time.Duration, 10)
+	cb := newStuckOpenBreaker(calls)
+
+	// 在 StuckOpenAfter 之前离开开启状态时取消定时器
+	cb.Trip()
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	cb.Reset()
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	assert.Equal(t, 0, len(calls))
+
+	// 再次开启时重新计时
+	cb.Trip()
+	assert.True(t, <-calls >= time.Duration(20)*time.Millisecond)
+	cb.Close()
+}
+
+func TestStuckOpenFlapping(t *testing.T) {
+	calls := make(chan time.Duration, 10)
+	cb := NewCircuitBreaker(Settings{
+		Timeout:        time.Duration(5) * time.Millisecond,
+		StuckOpenAfter: time.Duration(40) * time.Millisecond,
+		OnStuckOpen: func(name string, duration time.Duration) {
+			calls <- duration
+		},
+	})
+	defer cb.Close()
+
+	// 开启和半开之间反复切换时仍从离开关闭状态时计时
+	cb.Trip()
+	deadline := time.Now().Add(time.Duration(60) * time.Millisecond)
+	for time.Now().Before(deadline) {
+		fail(cb)
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, cb.TripCount() > 2)
+	assert.Equal(t, 1, len(calls))
+	assert.True(t, <-calls >= time.Duration(40)*time.Millisecond)
+
+	// 关闭后取消定时器
+	cb.Reset()
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	assert.Equal(t, 0, len(calls))
+}
@@ -0,0 +1,141 @@
+package gobreaker
+
+import "time"
+
+// WindowType is a type that represents how CircuitBreaker aggregates the results of requests in the closed state.
+type WindowType int
+
+// These constants are window types of CircuitBreaker.
+const (
+	// WindowTypeGeneration counts all results since the last state change or interval reset.
+	// 默认方式，统计自上次状态变更或 Interval 清空以来的所有结果
+	WindowTypeGeneration WindowType = iota
+	// WindowTypeCount counts the results of the last WindowSize requests.
+	// 只统计最近 WindowSize 个请求的结果
+	WindowTypeCount
+	// WindowTypeTime counts the results of the requests in the last RollingWindow.
+	// 只统计最近 RollingWindow 时间内的请求结果
+	WindowTypeTime
+)
+
+// window 在关闭状态下保存最近的请求结果，用来计算 TotalSuccesses 和 TotalFailures
+type window interface {
+	record(success bool, now time.Time)
+	totals(now time.Time) (successes, failures uint32)
+	reset()
+	// empty 返回一个配置相同的空窗口
+	empty() window
+}
+
+// countWindow 是基于数量的滑动窗口，用环形缓冲区保存最近 size 个结果
+type countWindow struct {
+	outcomes  []bool
+	head      int // 最旧结果的位置
+	size      int // 当前保存的结果数
+	successes uint32
+	failures  uint32
+}
+
+func newCountWindow(size uint32) *countWindow {
+	return &countWindow{outcomes: make([]bool, size)}
+}
+
+func (w *countWindow) record(success bool, now time.Time) {
+	if w.size == len(w.outcomes) {
+		// 窗口已满，移除最旧的结果
+		if w.outcomes[w.head] {
+			w.successes--
+		} else {
+			w.failures--
+		}
+		w.head = (w.head + 1) % len(w.outcomes)
+		w.size--
+	}
+
+	w.outcomes[(w.head+w.size)%len(w.outcomes)] = success
+	w.size++
+	if success {
+		w.successes++
+	} else {
+		w.failures++
+	}
+}
+
+func (w *countWindow) totals(now time.Time) (uint32, uint32) {
+	return w.successes, w.failures
+}
+
+func (w *countWindow) reset() {
+	w.head = 0
+	w.size = 0
+	w.successes = 0
+	w.failures = 0
+}
+
+func (w *countWindow) empty() window {
+	return newCountWindow(uint32(len(w.outcomes)))
+}
+
+// bucket 是时间窗口中的一个桶，epoch 是桶对应的时间片编号
+type bucket struct {
+	epoch     int64
+	successes uint32
+	failures  uint32
+}
+
+// timeWindow 是基于时间的滚动窗口，由固定数量的桶组成，
+// 过期的桶在访问时才清零，不需要后台 goroutine
+type timeWindow struct {
+	buckets []bucket
+	width   time.Duration // 每个桶覆盖的时间
+}
+
+func newTimeWindow(length time.Duration, count int) *timeWindow {
+	width := length / time.Duration(count)
+	if width <= 0 {
+		width = 1
+	}
+	w := &timeWindow{buckets: make([]bucket, count), width: width}
+	w.reset()
+	return w
+}
+
+func (w *timeWindow) epoch(now time.Time) int64 {
+	return now.UnixNano() / int64(w.width)
+}
+
+func (w *timeWindow) record(success bool, now time.Time) {
+	epoch := w.epoch(now)
+	b := &w.buckets[epoch%int64(len(w.buckets))]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	if success {
+		b.successes++
+	} else {
+		b.failures++
+	}
+}
+
+func (w *timeWindow) totals(now time.Time) (successes, failures uint32) {
+	oldest := w.epoch(now) - int64(len(w.buckets)) + 1
+	for _, b := range w.buckets {
+		if b.epoch >= oldest {
+			successes += b.successes
+			failures += b.failures
+		}
+	}
+	return successes, failures
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = bucket{epoch: -1}
+	}
+}
+
+func (w *timeWindow) empty() window {
+	e := &timeWindow{buckets: make([]bucket, len(w.buckets)), width: w.width}
+	e.reset()
+	return e
+}
@@ -0,0 +1,156 @@
+package gobreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var zeroTime time.Time
+
+func TestCountWindow(t *testing.T) {
+	w := newCountWindow(3)
+	w.record(false, zeroTime)
+	w.record(true, zeroTime)
+	s, f := w.totals(zeroTime)
+	assert.Equal(t, uint32(1), s)
+	assert.Equal(t, uint32(1), f)
+
+	w.record(true, zeroTime)
+	w.record(true, zeroTime) // evicts the failure
+	s, f = w.totals(zeroTime)
+	assert.Equal(t, uint32(3), s)
+	assert.Equal(t, uint32(0), f)
+
+	w.reset()
+	s, f = w.totals(zeroTime)
+	assert.Equal(t, uint32(0), s+f)
+}
+
+func TestWindowTypeCount(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		WindowType: WindowTypeCount,
+		WindowSize: 4,
+		Interval:   1, // ignored
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.TotalFailures >= 3
+		},
+	})
+	assert.Equal(t, 0, int(cb.interval))
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, succeed(cb))
+	}
+	// the old failures have slid out of the window
+	assert.Equal(t, newCounts(4, 4, 0, 4, 0), cb.Counts())
+
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, newCounts(4, 2, 2, 0, 2), cb.Counts())
+
+	// requests in flight are counted on top of the window
+	ch := succeedLater(cb, time.Duration(50)*time.Millisecond)
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	assert.Equal(t, newCounts(5, 2, 2, 0, 2), cb.Counts())
+	assert.Nil(t, <-ch)
+	assert.Equal(t, newCounts(4, 2, 2, 1, 0), cb.Counts())
+
+	assert.Nil(t, fail(cb)) // window: failure, failure, success, failure
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+
+	cb = NewCircuitBreaker(Settings{WindowType: WindowTypeCount})
+	assert.Equal(t, defaultWindowSize, len(cb.window.(*countWindow).outcomes))
+}
+
+func TestTimeWindow(t *testing.T) {
+	w := newTimeWindow(time.Duration(10)*time.Second, 5)
+	assert.Equal(t, time.Duration(2)*time.Second, w.width)
+
+	start := time.Unix(1000, 0)
+	w.record(false, start)
+	w.record(true, start.Add(time.Duration(3)*time.Second))
+	s, f := w.totals(start.Add(time.Duration(9) * time.Second))
+	assert.Equal(t, uint32(1), s)
+	assert.Equal(t, uint32(1), f)
+
+	// the first bucket has expired
+	s, f = w.totals(start.Add(time.Duration(10) * time.Second))
+	assert.Equal(t, uint32(1), s)
+	assert.Equal(t, uint32(0), f)
+
+	// sparse requests: a stale bucket is zeroed lazily when reused
+	w.record(false, start.Add(time.Duration(60)*time.Second))
+	s, f = w.totals(start.Add(time.Duration(60) * time.Second))
+	assert.Equal(t, uint32(0), s)
+	assert.Equal(t, uint32(1), f)
+	assert.Equal(t, uint32(1), w.buckets[0].failures)
+}
+
+func TestWindowTypeTime(t *testing.T) {
+	cb := NewCircuitBreaker(Settings{
+		WindowType:    WindowTypeTime,
+		RollingWindow: time.Duration(10) * time.Second,
+		BucketCount:   10,
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.TotalFailures >= 3
+		},
+	})
+	start := time.Now()
+
+	cb.replay(false, start)
+	cb.replay(false, start.Add(time.Duration(5)*time.Second))
+	assert.Equal(t, newCounts(2, 0, 2, 0, 2), cb.counts)
+
+	// the first failure has rolled out of the window
+	state, _ := cb.replay(false, start.Add(time.Duration(11)*time.Second))
+	assert.Equal(t, StateClosed, state)
+	assert.Equal(t, newCounts(2, 0, 2, 0, 3), cb.counts)
+
+	state, _ = cb.replay(false, start.Add(time.Duration(12)*time.Second))
+	assert.Equal(t, StateOpen, state)
+
+	// Counts expire without new requests
+	cb = NewCircuitBreaker(Settings{WindowType: WindowTypeTime, RollingWindow: time.Duration(100) * time.Millisecond})
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, newCounts(1, 0, 1, 0, 1), cb.Counts())
+	time.Sleep(time.Duration(150) * time.Millisecond)
+	assert.Equal(t, newCounts(0, 0, 0, 0, 1), cb.Counts())
+	assert.Equal(t, defaultBucketCount, len(cb.window.(*timeWindow).buckets))
+}
+
+func TestMinimumSamples(t *testing.T) {
+	var seen []Counts
+	cb := NewCircuitBreaker(Settings{
+		Interval:       time.Duration(10) * time.Second,
+		MinimumSamples: 4,
+		ReadyToTrip: func(counts Counts) bool {
+			seen = append(seen, counts)
+			return counts.FailureRatio() >= 0.5
+		},
+	})
+
+	// not called before 4 results are known
+	assert.Nil(t, fail(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Nil(t, succeed(cb))
+	assert.Equal(t, 0, len(seen))
+
+	// the interval reset clears Counts but not the last results
+	pseudoSleep(cb, time.Duration(11)*time.Second)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Equal(t, newCounts(0, 0, 0, 0, 0), cb.Counts())
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 1, len(seen))
+	assert.Equal(t, newCounts(4, 2, 2, 0, 1), seen[0])
+	assert.Equal(t, StateOpen, cb.State())
+
+	// the state change drops the last results
+	cb.Reset()
+	assert.Nil(t, fail(cb))
+	assert.Equal(t, 1, len(seen))
+	assert.Equal(t, StateClosed, cb.State())
+}